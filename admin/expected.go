@@ -0,0 +1,97 @@
+package admin
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	. "github.com/protolambda/httphelpers/codes"
+	"github.com/protolambda/muskoka-server/auth"
+	"github.com/protolambda/muskoka-server/model"
+	"github.com/protolambda/muskoka-server/tasks"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ExpectedHandler sets the expected post-state root of a task, after the task was created.
+type ExpectedHandler struct {
+	// Tasks is the task store with the tasks to update.
+	Tasks tasks.Store
+	// CheckToken decides if the bearer token of the request belongs to an admin.
+	CheckToken func(token string) bool
+}
+
+// NewExpectedHandler creates a handler to set expected post-states. By default, every token is denied.
+func NewExpectedHandler(tasks tasks.Store) *ExpectedHandler {
+	return &ExpectedHandler{
+		Tasks: tasks,
+		CheckToken: func(token string) bool {
+			return false
+		},
+	}
+}
+
+var defaultExpectedHandler *ExpectedHandler
+var defaultExpectedHandlerOnce sync.Once
+
+// the handler for the cloud function, with its dependencies configured by the environment.
+func getDefaultExpectedHandler() *ExpectedHandler {
+	defaultExpectedHandlerOnce.Do(func() {
+		taskStore, err := tasks.FromEnv(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to create task store: %v", err)
+		}
+		defaultExpectedHandler = NewExpectedHandler(taskStore)
+		defaultExpectedHandler.CheckToken = auth.TokenChecker(os.Getenv("MUSKOKA_ADMIN_TOKEN"))
+	})
+	return defaultExpectedHandler
+}
+
+// SetExpected is the cloud function entry point, see ExpectedHandler.
+func SetExpected(w http.ResponseWriter, r *http.Request) {
+	getDefaultExpectedHandler().ServeHTTP(w, r)
+}
+
+func (h *ExpectedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if token, ok := auth.BearerToken(r); !ok || !h.CheckToken(token) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// the key in the route takes precedence, the form value is only for the route without a key.
+	key, ok := mux.Vars(r)["key"]
+	if !ok {
+		key = r.FormValue("key")
+	}
+	if key == "" {
+		SERVER_BAD_INPUT.Report(w, "No key specified. Set the 'key' URL param.")
+		return
+	}
+	if !model.KeyRegex.Match([]byte(key)) {
+		SERVER_BAD_INPUT.Report(w, "task key is invalid")
+		return
+	}
+	// an empty root removes the expectation
+	root := r.FormValue("root")
+	if root != "" && !model.RootRegex.Match([]byte(root)) {
+		SERVER_BAD_INPUT.Report(w, "root is not a 0x-prefixed lowercase hex hash tree root")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	err := h.Tasks.SetExpectedPostRoot(ctx, key, root)
+	if err == tasks.ErrNotFound {
+		w.WriteHeader(404)
+		return
+	}
+	if SERVER_ERR.Check(w, err, "could not set expected post root") {
+		return
+	}
+	SERVER_OK.Report(w, "updated expected post root, and judged the results again")
+}
@@ -0,0 +1,20 @@
+module github.com/protolambda/muskoka-server/admin
+
+go 1.11
+
+require (
+	cloud.google.com/go v0.46.2 // indirect
+	github.com/gorilla/mux v1.7.3
+	github.com/protolambda/httphelpers v0.2.0
+	github.com/protolambda/muskoka-server/auth v0.0.0
+	github.com/protolambda/muskoka-server/model v0.0.0
+	github.com/protolambda/muskoka-server/tasks v0.0.0
+	google.golang.org/api v0.10.0 // indirect
+	google.golang.org/grpc v1.23.1
+)
+
+replace github.com/protolambda/muskoka-server/auth => ../auth
+
+replace github.com/protolambda/muskoka-server/model => ../model
+
+replace github.com/protolambda/muskoka-server/tasks => ../tasks
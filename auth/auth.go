@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// TokenChecker accepts only the given token. An empty token is never accepted.
+func TokenChecker(adminToken string) func(token string) bool {
+	return func(token string) bool {
+		return adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+	}
+}
+
+// BearerToken gets the token of the "Authorization: Bearer <token>" header of the request.
+// Returns false if the request has no bearer token.
+func BearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimPrefix(header, "Bearer "), true
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTokenChecker(t *testing.T) {
+	cases := []struct {
+		name       string
+		adminToken string
+		token      string
+		ok         bool
+	}{
+		{"matching token", "secret", "secret", true},
+		{"other token", "secret", "other", false},
+		{"prefix of the token", "secret", "sec", false},
+		{"no admin token", "", "", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := TokenChecker(c.adminToken)(c.token); got != c.ok {
+				t.Errorf("got %v, expected %v", got, c.ok)
+			}
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	cases := []struct {
+		name   string
+		header string
+		token  string
+		ok     bool
+	}{
+		{"bearer token", "Bearer secret", "secret", true},
+		{"empty bearer token", "Bearer ", "", true},
+		{"no header", "", "", false},
+		{"other scheme", "Basic c2VjcmV0", "", false},
+		{"lowercase scheme", "bearer secret", "", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if c.header != "" {
+				r.Header.Set("Authorization", c.header)
+			}
+			token, ok := BearerToken(r)
+			if token != c.token || ok != c.ok {
+				t.Errorf("got token %q (%v), expected %q (%v)", token, ok, c.token, c.ok)
+			}
+		})
+	}
+}
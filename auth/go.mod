@@ -0,0 +1,3 @@
+module github.com/protolambda/muskoka-server/auth
+
+go 1.11
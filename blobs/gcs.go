@@ -0,0 +1,90 @@
+package blobs
+
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"fmt"
+	"google.golang.org/api/iterator"
+	"io"
+)
+
+type GCSStore struct {
+	bucket *storage.BucketHandle
+}
+
+func NewGCSStore(ctx context.Context, bucketName string) (*GCSStore, error) {
+	cl, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %v", err)
+	}
+	return &GCSStore{bucket: cl.Bucket(bucketName)}, nil
+}
+
+func (s *GCSStore) Put(ctx context.Context, key string, r io.Reader) error {
+	// cancelling the context aborts the upload, the object will not be created.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := s.bucket.Object(key).NewWriter(ctx)
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("could not write object %s: %v", key, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("could not push object %s to cloud bucket: %v", key, err)
+	}
+	return nil
+}
+
+func (s *GCSStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := s.bucket.Object(key).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrNotFound
+	}
+	return r, err
+}
+
+func (s *GCSStore) Stat(ctx context.Context, key string) (*ObjectAttrs, error) {
+	attrs, err := s.bucket.Object(key).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectAttrs{Key: attrs.Name, Size: attrs.Size, Updated: attrs.Updated}, nil
+}
+
+func (s *GCSStore) List(ctx context.Context, prefix string) ([]ObjectAttrs, error) {
+	out := make([]ObjectAttrs, 0)
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ObjectAttrs{Key: attrs.Name, Size: attrs.Size, Updated: attrs.Updated})
+	}
+	return out, nil
+}
+
+// Copy copies the object within the bucket, with a server-side copy.
+func (s *GCSStore) Copy(ctx context.Context, src string, dst string) error {
+	_, err := s.bucket.Object(dst).CopierFrom(s.bucket.Object(src)).Run(ctx)
+	if err == storage.ErrObjectNotExist {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("could not copy object %s to %s: %v", src, dst, err)
+	}
+	return nil
+}
+
+func (s *GCSStore) Delete(ctx context.Context, key string) error {
+	err := s.bucket.Object(key).Delete(ctx)
+	if err == storage.ErrObjectNotExist {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,8 @@
+module github.com/protolambda/muskoka-server/blobs
+
+go 1.11
+
+require (
+	cloud.google.com/go v0.46.2
+	google.golang.org/api v0.10.0
+)
@@ -0,0 +1,250 @@
+package blobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestStore creates a local store in the "store" directory of a temporary directory, which is removed after the test.
+func newTestStore(t *testing.T) *LocalStore {
+	dir, err := ioutil.TempDir("", "muskoka-blobs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	store, err := NewLocalStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func putObject(t *testing.T, store Store, key string, data string) {
+	if err := store.Put(context.Background(), key, strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readObject(t *testing.T, store Store, key string) string {
+	rc, err := store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("could not get object %s: %v", key, err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestLocalStoreKeys(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	cases := []struct {
+		key   string
+		valid bool
+	}{
+		{"a", true},
+		{"v0.9.0/minimal/states/0x01.ssz", true},
+		{"", false},
+		{"..", false},
+		{"../a", false},
+		{"a/../../b", false},
+		{"a/./b", false},
+		{"/a", false},
+		{"a//b", false},
+		{"a/", false},
+		{`a\b`, false},
+	}
+	for _, c := range cases {
+		t.Run(c.key, func(t *testing.T) {
+			err := store.Put(ctx, c.key, strings.NewReader("x"))
+			if c.valid != (err == nil) {
+				t.Errorf("got error %v, expected the key to be valid: %v", err, c.valid)
+			}
+			if c.valid {
+				return
+			}
+			if _, err := store.Get(ctx, c.key); err == nil || err == ErrNotFound {
+				t.Errorf("got error %v for get, expected an invalid key", err)
+			}
+			if _, err := store.Stat(ctx, c.key); err == nil || err == ErrNotFound {
+				t.Errorf("got error %v for stat, expected an invalid key", err)
+			}
+			if err := store.Delete(ctx, c.key); err == nil || err == ErrNotFound {
+				t.Errorf("got error %v for delete, expected an invalid key", err)
+			}
+		})
+	}
+	// nothing was written outside of the store
+	if _, err := os.Stat(filepath.Join(filepath.Dir(store.Dir()), "a")); !os.IsNotExist(err) {
+		t.Errorf("got error %v, expected no object outside of the store", err)
+	}
+}
+
+// failingReader fails after its data is read.
+type failingReader struct {
+	r io.Reader
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection lost")
+	}
+	return n, err
+}
+
+func TestLocalStorePutAtomic(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	putObject(t, store, "a/b", "old")
+	if err := store.Put(ctx, "a/b", &failingReader{r: strings.NewReader("partial")}); err == nil {
+		t.Fatal("expected the put of a failing reader to fail")
+	}
+	if got := readObject(t, store, "a/b"); got != "old" {
+		t.Errorf("got %q, expected the object to be unchanged by the failed put", got)
+	}
+	if err := store.Put(ctx, "a/c", &failingReader{r: strings.NewReader("partial")}); err == nil {
+		t.Fatal("expected the put of a failing reader to fail")
+	}
+	if _, err := store.Get(ctx, "a/c"); err != ErrNotFound {
+		t.Errorf("got error %v, expected the failed put to not store the object", err)
+	}
+	// the temporary files of the failed puts are removed
+	infos, err := ioutil.ReadDir(filepath.Join(store.Dir(), "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Name() != "b" {
+		t.Errorf("got %d files, expected only the stored object", len(infos))
+	}
+
+	putObject(t, store, "a/b", "new")
+	if got := readObject(t, store, "a/b"); got != "new" {
+		t.Errorf("got %q, expected the object to be overwritten", got)
+	}
+}
+
+func TestLocalStoreList(t *testing.T) {
+	store := newTestStore(t)
+	for _, key := range []string{"b", "a/b/c", "ab", "a/bc", "a/b/d"} {
+		putObject(t, store, key, key)
+	}
+	// an incomplete write is not listed
+	if err := ioutil.WriteFile(filepath.Join(store.Dir(), "a", ".put-1"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		prefix string
+		keys   []string
+	}{
+		{"", []string{"a/b/c", "a/b/d", "a/bc", "ab", "b"}},
+		{"a", []string{"a/b/c", "a/b/d", "a/bc", "ab"}},
+		{"a/", []string{"a/b/c", "a/b/d", "a/bc"}},
+		{"a/b", []string{"a/b/c", "a/b/d", "a/bc"}},
+		{"a/b/", []string{"a/b/c", "a/b/d"}},
+		{"a/b/c", []string{"a/b/c"}},
+		{"c", []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.prefix, func(t *testing.T) {
+			objects, err := store.List(context.Background(), c.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys := make([]string, 0, len(objects))
+			for _, o := range objects {
+				keys = append(keys, o.Key)
+				if o.Size != int64(len(o.Key)) {
+					t.Errorf("got size %d of %s, expected %d", o.Size, o.Key, len(o.Key))
+				}
+			}
+			if !reflect.DeepEqual(keys, c.keys) {
+				t.Errorf("got keys %q, expected %q", keys, c.keys)
+			}
+		})
+	}
+}
+
+func TestLocalStoreStat(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	putObject(t, store, "a/b", "abc")
+	attrs, err := store.Stat(ctx, "a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Key != "a/b" || attrs.Size != 3 || attrs.Updated.IsZero() {
+		t.Errorf("got attributes %+v, expected key a/b, size 3, and the time of the put", attrs)
+	}
+	// a directory is not an object
+	for _, key := range []string{"a", "a/c"} {
+		if _, err := store.Stat(ctx, key); err != ErrNotFound {
+			t.Errorf("got error %v for %s, expected ErrNotFound", err, key)
+		}
+	}
+}
+
+func TestLocalStoreDelete(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	putObject(t, store, "a/b", "abc")
+	if err := store.Delete(ctx, "a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "a/b"); err != ErrNotFound {
+		t.Errorf("got error %v, expected the object to be deleted", err)
+	}
+	if err := store.Delete(ctx, "a/b"); err != ErrNotFound {
+		t.Errorf("got error %v for a deleted object, expected ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, "c"); err != ErrNotFound {
+		t.Errorf("got error %v for a missing object, expected ErrNotFound", err)
+	}
+}
+
+func TestLocalStoreCopy(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	putObject(t, store, "a", "abc")
+	putObject(t, store, "c", "old")
+	cases := []struct {
+		name string
+		src  string
+		dst  string
+		err  error
+	}{
+		{"new object", "a", "b/a", nil},
+		{"overwrite", "a", "c", nil},
+		{"missing object", "d", "e", ErrNotFound},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := store.Copy(ctx, c.src, c.dst)
+			if err != c.err {
+				t.Fatalf("got error %v, expected %v", err, c.err)
+			}
+			if err != nil {
+				if _, err := store.Stat(ctx, c.dst); err != ErrNotFound {
+					t.Errorf("got error %v, expected no copy", err)
+				}
+				return
+			}
+			if got := readObject(t, store, c.dst); got != "abc" {
+				t.Errorf("got copy %q, expected %q", got, "abc")
+			}
+			if got := readObject(t, store, c.src); got != "abc" {
+				t.Errorf("got source %q after the copy, expected it to be unchanged", got)
+			}
+		})
+	}
+}
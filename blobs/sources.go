@@ -0,0 +1,86 @@
+package blobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+var ErrUnknownURL = errors.New("URL does not refer to a known store")
+
+var ErrTooLarge = errors.New("object is too large")
+
+// Source is a store of which the objects are served under a URL prefix.
+type Source struct {
+	// the URL prefix of the objects, the rest of the URL is the key of the object
+	URLPrefix string
+	Store     Store
+}
+
+// Sources resolve the URLs of objects to the stores the objects are kept in.
+type Sources []Source
+
+// Get opens the object the URL refers to. The caller has to close it.
+// Returns ErrUnknownURL if the URL has none of the prefixes, ErrNotFound if the object does not exist.
+func (s Sources) Get(ctx context.Context, url string) (io.ReadCloser, error) {
+	for _, src := range s {
+		if strings.HasPrefix(url, src.URLPrefix) {
+			return src.Store.Get(ctx, strings.TrimPrefix(url, src.URLPrefix))
+		}
+	}
+	return nil, ErrUnknownURL
+}
+
+// GetLimited opens the object the URL refers to, like Get, but reading more than limit bytes fails with ErrTooLarge.
+// The size of the object cannot be trusted before it is read: the limit is enforced while reading.
+func (s Sources) GetLimited(ctx context.Context, url string, limit int64) (io.ReadCloser, error) {
+	rc, err := s.Get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedReader{ReadCloser: rc, remaining: limit}, nil
+}
+
+// limitedReader fails with ErrTooLarge once more than the remaining bytes are read.
+type limitedReader struct {
+	io.ReadCloser
+	remaining int64
+	tooLarge  bool
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if r.tooLarge {
+		return 0, ErrTooLarge
+	}
+	// read one byte more than remaining, to tell an object of exactly the limit from a larger one
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if int64(n) > r.remaining {
+		r.tooLarge = true
+		return int(r.remaining), ErrTooLarge
+	}
+	r.remaining -= int64(n)
+	return n, err
+}
+
+// ResultSourcesFromEnv returns the cloud storage buckets with result files, as configured by the environment:
+// the RESULTS_BUCKET bucket, and the buckets in MUSKOKA_RESULT_BUCKETS (comma separated).
+// Objects are referred to by their public URL: https://storage.googleapis.com/<bucket>/<key>
+func ResultSourcesFromEnv(ctx context.Context) (Sources, error) {
+	var out Sources
+	for _, bucketName := range append([]string{os.Getenv("RESULTS_BUCKET")}, strings.Split(os.Getenv("MUSKOKA_RESULT_BUCKETS"), ",")...) {
+		if bucketName == "" {
+			continue
+		}
+		store, err := NewGCSStore(ctx, bucketName)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Source{URLPrefix: "https://storage.googleapis.com/" + bucketName + "/", Store: store})
+	}
+	return out, nil
+}
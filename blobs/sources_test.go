@@ -0,0 +1,85 @@
+package blobs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSourcesGet(t *testing.T) {
+	inputs := newTestStore(t)
+	outputs := newTestStore(t)
+	putObject(t, inputs, "a", "input")
+	putObject(t, outputs, "a", "output")
+	sources := Sources{{URLPrefix: "inputs://", Store: inputs}, {URLPrefix: "https://example.com/outputs/", Store: outputs}}
+	cases := []struct {
+		name string
+		url  string
+		want string
+		err  error
+	}{
+		{"first prefix", "inputs://a", "input", nil},
+		{"second prefix", "https://example.com/outputs/a", "output", nil},
+		{"missing object", "inputs://b", "", ErrNotFound},
+		{"unknown prefix", "https://example.com/other/a", "", ErrUnknownURL},
+		{"key without prefix", "a", "", ErrUnknownURL},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rc, err := sources.Get(context.Background(), c.url)
+			if err != c.err {
+				t.Fatalf("got error %v, expected %v", err, c.err)
+			}
+			if err != nil {
+				return
+			}
+			defer rc.Close()
+			var buf bytes.Buffer
+			if _, err := io.Copy(&buf, rc); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != c.want {
+				t.Errorf("got %q, expected %q", buf.String(), c.want)
+			}
+		})
+	}
+}
+
+func TestSourcesGetLimited(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	putObject(t, store, "a", "0123456789")
+	sources := Sources{{URLPrefix: "local://", Store: store}}
+	cases := []struct {
+		name  string
+		url   string
+		limit int64
+		want  string
+		err   error
+	}{
+		{"below the limit", "local://a", 20, "0123456789", nil},
+		{"at the limit", "local://a", 10, "0123456789", nil},
+		{"above the limit", "local://a", 9, "", ErrTooLarge},
+		{"empty limit", "local://a", 0, "", ErrTooLarge},
+		{"missing object", "local://b", 20, "", ErrNotFound},
+		{"unknown prefix", "other://a", 20, "", ErrUnknownURL},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rc, err := sources.GetLimited(ctx, c.url, c.limit)
+			if err == nil {
+				defer rc.Close()
+				var data []byte
+				data, err = ioutil.ReadAll(rc)
+				if err == nil && string(data) != c.want {
+					t.Errorf("got %q, expected %q", data, c.want)
+				}
+			}
+			if err != c.err {
+				t.Errorf("got error %v, expected %v", err, c.err)
+			}
+		})
+	}
+}
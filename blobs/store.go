@@ -0,0 +1,61 @@
+package blobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+type ObjectAttrs struct {
+	Key     string
+	Size    int64
+	Updated time.Time
+}
+
+type Store interface {
+	// Put stores the contents of r under the given key, overwriting any existing object.
+	// The object is not stored if reading r fails.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get opens the object for reading. The caller has to close it. Returns ErrNotFound if the object does not exist.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Stat retrieves the attributes of the object. Returns ErrNotFound if the object does not exist.
+	Stat(ctx context.Context, key string) (*ObjectAttrs, error)
+	// List the attributes of all objects with a key starting with the given prefix, sorted by key.
+	List(ctx context.Context, prefix string) ([]ObjectAttrs, error)
+	// Delete removes the object. Returns ErrNotFound if the object does not exist.
+	Delete(ctx context.Context, key string) error
+	// Copy copies the object to another key, overwriting any existing object, within the store:
+	// the contents are not passed through this process. Returns ErrNotFound if the object does not exist.
+	Copy(ctx context.Context, src string, dst string) error
+}
+
+var envStore Store
+var envErr error
+var envOnce sync.Once
+
+// FromEnv returns the store for transition inputs, as configured by the environment:
+// a local directory if TRANSITIONS_DIR is set, the TRANSITIONS_BUCKET cloud storage bucket otherwise.
+// The store is created once, and shared by all callers in the process.
+func FromEnv(ctx context.Context) (Store, error) {
+	envOnce.Do(func() {
+		if dir := os.Getenv("TRANSITIONS_DIR"); dir != "" {
+			envStore, envErr = NewLocalStore(dir)
+			return
+		}
+		bucketName := "muskoka-transitions"
+		if envName := os.Getenv("TRANSITIONS_BUCKET"); envName != "" {
+			bucketName = envName
+		}
+		envStore, envErr = NewGCSStore(ctx, bucketName)
+	})
+	if envErr != nil {
+		return nil, fmt.Errorf("could not create transitions store: %v", envErr)
+	}
+	return envStore, nil
+}
@@ -0,0 +1,5 @@
+module github.com/protolambda/muskoka-server/clientkeys
+
+go 1.11
+
+require golang.org/x/crypto v0.0.0-20190605123033-f99c8df09eb5
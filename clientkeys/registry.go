@@ -0,0 +1,161 @@
+package clientkeys
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ed25519"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownKey       = errors.New("unknown client key")
+	ErrRevokedKey       = errors.New("client key is revoked")
+	ErrInactiveKey      = errors.New("client key is not active")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// Key is a public key of a client. A client may have multiple keys, e.g. while rotating keys.
+type Key struct {
+	// name of the client the key belongs to
+	Client string `json:"client"`
+	// identifies the key within the keys of the client
+	ID string `json:"id"`
+	// ed25519 public key, base64 encoded
+	PublicKey string `json:"public-key"`
+	// optional, the key is not accepted before this time
+	NotBefore *time.Time `json:"not-before,omitempty"`
+	// optional, the key is not accepted after this time. Set it to rotate to a new key.
+	NotAfter *time.Time `json:"not-after,omitempty"`
+	// revoked keys are never accepted, e.g. when the private key leaked.
+	Revoked bool `json:"revoked"`
+}
+
+// KeysFile is the format of a file with client keys.
+type KeysFile struct {
+	Keys []*Key `json:"keys"`
+}
+
+var ClientRegex, _ = regexp.Compile("^[0-9a-zA-Z][-_0-9a-zA-Z]{0,128}$")
+
+var KeyIDRegex, _ = regexp.Compile("^[-_.0-9a-zA-Z]{1,64}$")
+
+// the parsed form of a key
+type entry struct {
+	key    *Key
+	public ed25519.PublicKey
+}
+
+// Registry keeps track of the public keys of clients. It is safe for concurrent use.
+type Registry struct {
+	mu sync.RWMutex
+	// client -> key ID -> key
+	keys map[string]map[string]*entry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{keys: make(map[string]map[string]*entry)}
+}
+
+func parseKey(k *Key) (*entry, error) {
+	if !ClientRegex.MatchString(k.Client) {
+		return nil, fmt.Errorf("invalid client name: %q", k.Client)
+	}
+	if !KeyIDRegex.MatchString(k.ID) {
+		return nil, fmt.Errorf("invalid key id of client %s: %q", k.Client, k.ID)
+	}
+	pub, err := base64.StdEncoding.DecodeString(k.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("public key %s of client %s is not valid base64: %v", k.ID, k.Client, err)
+	}
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key %s of client %s is not an ed25519 public key", k.ID, k.Client)
+	}
+	return &entry{key: k, public: ed25519.PublicKey(pub)}, nil
+}
+
+// Add adds a key to the registry. Returns an error if the key is invalid, or already registered.
+func (r *Registry) Add(k *Key) error {
+	e, err := parseKey(k)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clientKeys, ok := r.keys[k.Client]
+	if !ok {
+		clientKeys = make(map[string]*entry)
+		r.keys[k.Client] = clientKeys
+	}
+	if _, ok := clientKeys[k.ID]; ok {
+		return fmt.Errorf("key %s of client %s is already registered", k.ID, k.Client)
+	}
+	clientKeys[k.ID] = e
+	return nil
+}
+
+// Load replaces all keys of the registry with the keys in the JSON keys file. See KeysFile.
+// The registry is not changed if the file has an invalid key.
+func (r *Registry) Load(src io.Reader) error {
+	var f KeysFile
+	if err := json.NewDecoder(src).Decode(&f); err != nil {
+		return fmt.Errorf("could not decode keys file: %v", err)
+	}
+	loaded := NewRegistry()
+	for _, k := range f.Keys {
+		if err := loaded.Add(k); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	r.keys = loaded.keys
+	r.mu.Unlock()
+	return nil
+}
+
+// LoadFile creates a registry with the keys in the JSON keys file at the given path.
+func LoadFile(path string) (*Registry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open keys file: %v", err)
+	}
+	defer f.Close()
+	r := NewRegistry()
+	if err := r.Load(f); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// HasClient checks if any keys, active or not, are registered for the client.
+func (r *Registry) HasClient(client string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.keys[client]) > 0
+}
+
+// Verify checks the signature of the message against the given key of the client.
+// The key must be active at the given time, and must not be revoked.
+func (r *Registry) Verify(client string, keyID string, msg []byte, sig []byte, now time.Time) error {
+	r.mu.RLock()
+	e, ok := r.keys[client][keyID]
+	r.mu.RUnlock()
+	if !ok {
+		return ErrUnknownKey
+	}
+	if e.key.Revoked {
+		return ErrRevokedKey
+	}
+	if (e.key.NotBefore != nil && now.Before(*e.key.NotBefore)) || (e.key.NotAfter != nil && now.After(*e.key.NotAfter)) {
+		return ErrInactiveKey
+	}
+	if !ed25519.Verify(e.public, msg, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package clientkeys
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	pub := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	cases := []struct {
+		name string
+		file string
+		err  bool
+	}{
+		{"keys", `{"keys":[{"client":"zrnt","id":"a","public-key":"` + pub + `"},{"client":"zrnt","id":"b","public-key":"` + pub + `"}]}`, false},
+		{"no keys", `{"keys":[]}`, false},
+		{"repeated key", `{"keys":[{"client":"zrnt","id":"a","public-key":"` + pub + `"},{"client":"zrnt","id":"a","public-key":"` + pub + `"}]}`, true},
+		{"invalid client", `{"keys":[{"client":"-zrnt","id":"a","public-key":"` + pub + `"}]}`, true},
+		{"invalid key id", `{"keys":[{"client":"zrnt","id":"a/b","public-key":"` + pub + `"}]}`, true},
+		{"invalid base64", `{"keys":[{"client":"zrnt","id":"a","public-key":"not base64!"}]}`, true},
+		{"short key", `{"keys":[{"client":"zrnt","id":"a","public-key":"` + base64.StdEncoding.EncodeToString(make([]byte, 16)) + `"}]}`, true},
+		{"invalid json", `{"keys":`, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := NewRegistry()
+			if err := r.Add(&Key{Client: "prysm", ID: "old", PublicKey: pub}); err != nil {
+				t.Fatal(err)
+			}
+			err := r.Load(strings.NewReader(c.file))
+			if (err != nil) != c.err {
+				t.Fatalf("got error %v, expected error: %v", err, c.err)
+			}
+			// a file with an invalid key does not change the registry, a valid file replaces all keys
+			if got := r.HasClient("prysm"); got != c.err {
+				t.Errorf("got previous keys %v, expected %v", got, c.err)
+			}
+			if got, want := r.HasClient("zrnt"), !c.err && c.name == "keys"; got != want {
+				t.Errorf("got loaded keys %v, expected %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package clientkeys
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/crypto/ed25519"
+	"time"
+)
+
+// SignatureField is the field of a signed JSON message that holds the signature. It is not part of the signed data.
+const SignatureField = "signature"
+
+// Canonical encodes a JSON object message in its canonical form, the data that is signed:
+// without the signature field, object keys sorted, no insignificant whitespace, and no escaping of HTML characters.
+// Numbers are kept as written.
+func Canonical(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return nil, fmt.Errorf("message is not a JSON object: %v", err)
+	}
+	delete(obj, SignatureField)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	// maps are encoded with sorted keys
+	if err := enc.Encode(obj); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Sign signs the canonical form of the JSON message, and returns the base64 encoded signature.
+func Sign(priv ed25519.PrivateKey, data []byte) (string, error) {
+	msg, err := Canonical(data)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, msg)), nil
+}
+
+// VerifyMessage checks the base64 encoded signature of the canonical form of the JSON message,
+// against the given key of the client. See Verify.
+func (r *Registry) VerifyMessage(client string, keyID string, data []byte, signature string, now time.Time) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return ErrInvalidSignature
+	}
+	msg, err := Canonical(data)
+	if err != nil {
+		return err
+	}
+	return r.Verify(client, keyID, msg, sig, now)
+}
@@ -0,0 +1,101 @@
+package clientkeys
+
+import (
+	"encoding/base64"
+	"golang.org/x/crypto/ed25519"
+	"testing"
+	"time"
+)
+
+func TestCanonical(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		want string
+		err  bool
+	}{
+		{"sorted keys", `{"b":1,"a":2}`, `{"a":2,"b":1}`, false},
+		{"whitespace", "{ \"a\" :\n [1, 2],\t\"b\": {\"d\": true, \"c\": null} }", `{"a":[1,2],"b":{"c":null,"d":true}}`, false},
+		{"signature is removed", `{"signature":"abc","a":"x"}`, `{"a":"x"}`, false},
+		{"nested signature is kept", `{"a":{"signature":"abc"}}`, `{"a":{"signature":"abc"}}`, false},
+		{"numbers as written", `{"a":1.50,"b":1e3,"c":12345678901234567890}`, `{"a":1.50,"b":1e3,"c":12345678901234567890}`, false},
+		{"html is not escaped", `{"a":"<b>&</b>"}`, `{"a":"<b>&</b>"}`, false},
+		{"unicode escapes are decoded", `{"a":"\u00e9"}`, `{"a":"é"}`, false},
+		{"not an object", `[1,2]`, "", true},
+		{"invalid json", `{"a":`, "", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := Canonical([]byte(c.data))
+			if (err != nil) != c.err {
+				t.Fatalf("got error %v, expected error: %v", err, c.err)
+			}
+			if string(got) != c.want {
+				t.Errorf("got %s, expected %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestVerifyMessage(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+	encodedPub := base64.StdEncoding.EncodeToString(pub)
+	r := NewRegistry()
+	for _, k := range []*Key{
+		{Client: "zrnt", ID: "active", PublicKey: encodedPub},
+		{Client: "zrnt", ID: "window", PublicKey: encodedPub, NotBefore: &before, NotAfter: &after},
+		{Client: "zrnt", ID: "future", PublicKey: encodedPub, NotBefore: &after},
+		{Client: "zrnt", ID: "expired", PublicKey: encodedPub, NotAfter: &before},
+		{Client: "zrnt", ID: "revoked", PublicKey: encodedPub, Revoked: true},
+	} {
+		if err := r.Add(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const msg = `{"client-name":"zrnt","success":true,"post-hash":"0x01"}`
+	sig, err := Sign(priv, []byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSig, err := Sign(otherPriv, []byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		client string
+		keyID  string
+		data   string
+		sig    string
+		want   error
+	}{
+		{"valid", "zrnt", "active", msg, sig, nil},
+		{"reformatted message", "zrnt", "active", `{"success": true, "post-hash": "0x01", "client-name": "zrnt", "signature": "x"}`, sig, nil},
+		{"within validity window", "zrnt", "window", msg, sig, nil},
+		{"changed message", "zrnt", "active", `{"client-name":"zrnt","success":false,"post-hash":"0x01"}`, sig, ErrInvalidSignature},
+		{"other key", "zrnt", "active", msg, otherSig, ErrInvalidSignature},
+		{"not base64", "zrnt", "active", msg, "not base64!", ErrInvalidSignature},
+		{"short signature", "zrnt", "active", msg, base64.StdEncoding.EncodeToString([]byte("short")), ErrInvalidSignature},
+		{"unknown key", "zrnt", "other", msg, sig, ErrUnknownKey},
+		{"unknown client", "prysm", "active", msg, sig, ErrUnknownKey},
+		{"not active yet", "zrnt", "future", msg, sig, ErrInactiveKey},
+		{"expired", "zrnt", "expired", msg, sig, ErrInactiveKey},
+		{"revoked", "zrnt", "revoked", msg, sig, ErrRevokedKey},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := r.VerifyMessage(c.client, c.keyID, []byte(c.data), c.sig, now); err != c.want {
+				t.Errorf("got error %v, expected %v", err, c.want)
+			}
+		})
+	}
+}
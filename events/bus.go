@@ -0,0 +1,92 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+var ErrTopicNotFound = errors.New("topic not found")
+
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+type Message struct {
+	// unique per published message, set by the bus.
+	ID   string
+	Data []byte
+}
+
+// Handler processes a received message. Errors are logged. The message is redelivered after an error,
+// unless it is a *BadMessageError: a message that can never be processed is dropped.
+type Handler func(ctx context.Context, m *Message) error
+
+// BadMessageError is the error of a message that can never be processed, e.g. a message that cannot be decoded.
+// Other errors are assumed to be temporary.
+type BadMessageError struct {
+	Err error
+}
+
+func (e *BadMessageError) Error() string {
+	return e.Err.Error()
+}
+
+// BadMessage marks the error as the error of a message that can never be processed, see BadMessageError.
+func BadMessage(err error) error {
+	return &BadMessageError{Err: err}
+}
+
+// IsBadMessage checks if the error is a *BadMessageError.
+func IsBadMessage(err error) bool {
+	_, ok := err.(*BadMessageError)
+	return ok
+}
+
+type Bus interface {
+	// TopicExists checks if messages can be published to the given topic.
+	TopicExists(ctx context.Context, topic string) (bool, error)
+	// Publish sends the data to all subscriptions of the topic, and returns the ID of the message.
+	// Returns ErrTopicNotFound if the topic does not exist.
+	Publish(ctx context.Context, topic string, data []byte) (string, error)
+	// SubscriptionExists checks if messages can be received from the given subscription.
+	SubscriptionExists(ctx context.Context, sub string) (bool, error)
+	// Receive calls the handler for each message of the subscription, until the context is done.
+	// Messages are redelivered if the handler fails, see Handler.
+	// Returns ErrSubscriptionNotFound if the subscription does not exist.
+	Receive(ctx context.Context, sub string, h Handler) error
+}
+
+// TransitionTopic is the topic new transition tasks are published to.
+func TransitionTopic(specVersion string, specConfig string) string {
+	return fmt.Sprintf("transition~%s~%s", specVersion, specConfig)
+}
+
+// ResultsTopic is the topic the results of a client are published to.
+func ResultsTopic(clientName string) string {
+	return fmt.Sprintf("results~%s", clientName)
+}
+
+// VerifyTopic is the topic stored results are published to, to verify their post-state in the background.
+const VerifyTopic = "verify"
+
+// WorkerSubscription is the subscription of a worker to the transition topic of a spec version and config.
+func WorkerSubscription(specVersion string, specConfig string, clientName string, workerID string) string {
+	return fmt.Sprintf("%s~%s~%s~%s", specVersion, specConfig, clientName, workerID)
+}
+
+var envBus Bus
+var envErr error
+var envOnce sync.Once
+
+// FromEnv returns the Pub/Sub bus of the GCP_PROJECT.
+// The bus is created once, and shared by all callers in the process.
+func FromEnv(ctx context.Context) (Bus, error) {
+	envOnce.Do(func() {
+		envBus, envErr = NewPubSubBus(ctx, os.Getenv("GCP_PROJECT"))
+	})
+	if envErr != nil {
+		return nil, fmt.Errorf("could not create event bus: %v", envErr)
+	}
+	return envBus, nil
+}
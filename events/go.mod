@@ -0,0 +1,8 @@
+module github.com/protolambda/muskoka-server/events
+
+go 1.11
+
+require (
+	cloud.google.com/go/pubsub v1.0.1
+	google.golang.org/grpc v1.21.1
+)
@@ -0,0 +1,153 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type localSub struct {
+	topic string
+	queue []*Message
+	// signals receivers that the queue is not empty
+	ready chan struct{}
+}
+
+// LocalBus is an in-memory event bus. Like Pub/Sub, every subscription gets a copy of each message published to
+// its topic after the subscription was created, and receivers of the same subscription share the messages.
+// A message is redelivered after the handler fails, see Handler.
+type LocalBus struct {
+	// RetryDelay is the time before a failed message is delivered again.
+	RetryDelay time.Duration
+	mu         sync.Mutex
+	topics     map[string][]*localSub
+	subs       map[string]*localSub
+	nextID     uint64
+}
+
+func NewLocalBus() *LocalBus {
+	return &LocalBus{
+		RetryDelay: time.Second,
+		topics:     make(map[string][]*localSub),
+		subs:       make(map[string]*localSub),
+	}
+}
+
+// CreateTopic creates the topic, if it does not exist yet.
+func (b *LocalBus) CreateTopic(topic string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.topics[topic]; !ok {
+		b.topics[topic] = nil
+	}
+}
+
+// CreateSubscription creates a subscription to an existing topic.
+func (b *LocalBus) CreateSubscription(sub string, topic string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs, ok := b.topics[topic]
+	if !ok {
+		return ErrTopicNotFound
+	}
+	if _, ok := b.subs[sub]; ok {
+		return fmt.Errorf("subscription %s already exists", sub)
+	}
+	s := &localSub{topic: topic, ready: make(chan struct{}, 1)}
+	b.subs[sub] = s
+	b.topics[topic] = append(subs, s)
+	return nil
+}
+
+func (b *LocalBus) TopicExists(ctx context.Context, topic string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.topics[topic]
+	return ok, nil
+}
+
+func (b *LocalBus) Publish(ctx context.Context, topic string, data []byte) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs, ok := b.topics[topic]
+	if !ok {
+		return "", ErrTopicNotFound
+	}
+	b.nextID++
+	id := strconv.FormatUint(b.nextID, 10)
+	for _, s := range subs {
+		// each subscription gets its own copy, handlers may not share the data.
+		dataCopy := make([]byte, len(data))
+		copy(dataCopy, data)
+		s.queue = append(s.queue, &Message{ID: id, Data: dataCopy})
+		s.signal()
+	}
+	return id, nil
+}
+
+func (b *LocalBus) SubscriptionExists(ctx context.Context, sub string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.subs[sub]
+	return ok, nil
+}
+
+func (b *LocalBus) Receive(ctx context.Context, sub string, h Handler) error {
+	b.mu.Lock()
+	s, ok := b.subs[sub]
+	b.mu.Unlock()
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+	for {
+		b.mu.Lock()
+		var m *Message
+		if len(s.queue) > 0 {
+			m = s.queue[0]
+			s.queue = s.queue[1:]
+			// wake up any other receivers if there is more work
+			if len(s.queue) > 0 {
+				s.signal()
+			}
+		}
+		b.mu.Unlock()
+		if m == nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-s.ready:
+				continue
+			}
+		}
+		err := h(ctx, m)
+		if err == nil {
+			continue
+		}
+		if IsBadMessage(err) {
+			log.Printf("dropping message %s of subscription %s: %v", m.ID, sub, err)
+			continue
+		}
+		log.Printf("failed to handle message %s of subscription %s, it will be redelivered: %v", m.ID, sub, err)
+		b.redeliver(s, m)
+	}
+}
+
+// redeliver queues the message again after the retry delay.
+func (b *LocalBus) redeliver(s *localSub, m *Message) {
+	time.AfterFunc(b.RetryDelay, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		s.queue = append(s.queue, m)
+		s.signal()
+	})
+}
+
+func (s *localSub) signal() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
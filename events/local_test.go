@@ -0,0 +1,194 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiveAll receives the messages of the subscription with the given number of receivers,
+// until n messages were received, or until the timeout. Returns the data of the received messages, sorted.
+func receiveAll(t *testing.T, b *LocalBus, sub string, receivers int, n int) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var mu sync.Mutex
+	var got []string
+	var wg sync.WaitGroup
+	for i := 0; i < receivers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := b.Receive(ctx, sub, func(ctx context.Context, m *Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+				got = append(got, string(m.Data))
+				if len(got) == n {
+					cancel()
+				}
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	sort.Strings(got)
+	return got
+}
+
+func TestLocalBusErrors(t *testing.T) {
+	b := NewLocalBus()
+	ctx := context.Background()
+	b.CreateTopic("t")
+	cases := []struct {
+		name string
+		do   func() error
+		want error
+	}{
+		{"publish to missing topic", func() error {
+			_, err := b.Publish(ctx, "x", []byte("a"))
+			return err
+		}, ErrTopicNotFound},
+		{"subscribe to missing topic", func() error { return b.CreateSubscription("s", "x") }, ErrTopicNotFound},
+		{"receive from missing subscription", func() error {
+			return b.Receive(ctx, "x", func(ctx context.Context, m *Message) error { return nil })
+		}, ErrSubscriptionNotFound},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.do(); err != c.want {
+				t.Errorf("got error %v, expected %v", err, c.want)
+			}
+		})
+	}
+	if err := b.CreateSubscription("s", "t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CreateSubscription("s", "t"); err == nil {
+		t.Error("expected an error for a repeated subscription")
+	}
+}
+
+func TestLocalBusExists(t *testing.T) {
+	b := NewLocalBus()
+	ctx := context.Background()
+	b.CreateTopic("t")
+	if err := b.CreateSubscription("s", "t"); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		exists func() (bool, error)
+		want   bool
+	}{
+		{"topic", func() (bool, error) { return b.TopicExists(ctx, "t") }, true},
+		{"missing topic", func() (bool, error) { return b.TopicExists(ctx, "s") }, false},
+		{"subscription", func() (bool, error) { return b.SubscriptionExists(ctx, "s") }, true},
+		{"missing subscription", func() (bool, error) { return b.SubscriptionExists(ctx, "t") }, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.exists()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("got %v, expected %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLocalBusDelivery(t *testing.T) {
+	b := NewLocalBus()
+	ctx := context.Background()
+	b.CreateTopic("t")
+	b.CreateTopic("other")
+	if _, err := b.Publish(ctx, "t", []byte("before")); err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range []string{"s1", "s2"} {
+		if err := b.CreateSubscription(sub, "t"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.CreateSubscription("s3", "other"); err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]bool)
+	for _, data := range []string{"a", "b", "c", "d"} {
+		id, err := b.Publish(ctx, "t", []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids[id] {
+			t.Errorf("repeated message id %s", id)
+		}
+		ids[id] = true
+	}
+	cases := []struct {
+		name      string
+		sub       string
+		receivers int
+		n         int
+		want      []string
+	}{
+		// messages published before the subscription was created are not delivered
+		{"single receiver", "s1", 1, 4, []string{"a", "b", "c", "d"}},
+		// receivers of the same subscription share the messages, every message is received once
+		{"shared receivers", "s2", 3, 4, []string{"a", "b", "c", "d"}},
+		{"other topic", "s3", 1, 1, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := receiveAll(t, b, c.sub, c.receivers, c.n); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got messages %v, expected %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLocalBusRedelivery(t *testing.T) {
+	b := NewLocalBus()
+	b.RetryDelay = time.Millisecond
+	ctx := context.Background()
+	b.CreateTopic("t")
+	if err := b.CreateSubscription("s", "t"); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"temporary", "bad", "ok"} {
+		if _, err := b.Publish(ctx, "t", []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	attempts := make(map[string]int)
+	err := b.Receive(ctx, "s", func(ctx context.Context, m *Message) error {
+		data := string(m.Data)
+		attempts[data]++
+		switch {
+		case data == "bad":
+			return BadMessage(errors.New("cannot decode"))
+		case data == "temporary" && attempts[data] < 3:
+			return errors.New("temporary failure")
+		}
+		if attempts["temporary"] == 3 && attempts["ok"] == 1 {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the failing message is delivered until it succeeds, the bad message is dropped after the first attempt.
+	want := map[string]int{"temporary": 3, "bad": 1, "ok": 1}
+	if !reflect.DeepEqual(attempts, want) {
+		t.Errorf("got attempts %v, expected %v", attempts, want)
+	}
+}
@@ -0,0 +1,79 @@
+package events
+
+import (
+	"cloud.google.com/go/pubsub"
+	"context"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+)
+
+type PubSubBus struct {
+	client *pubsub.Client
+	// applied to every subscription before receiving messages
+	ReceiveSettings pubsub.ReceiveSettings
+}
+
+func NewPubSubBus(ctx context.Context, projectID string) (*PubSubBus, error) {
+	cl, err := pubsub.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pubsub client: %v", err)
+	}
+	return &PubSubBus{
+		client: cl,
+		ReceiveSettings: pubsub.ReceiveSettings{
+			MaxExtension:           -1,
+			MaxOutstandingMessages: 20,
+			MaxOutstandingBytes:    1 << 10,
+			NumGoroutines:          4,
+			Synchronous:            true,
+		},
+	}, nil
+}
+
+func (b *PubSubBus) TopicExists(ctx context.Context, topic string) (bool, error) {
+	return b.client.Topic(topic).Exists(ctx)
+}
+
+func (b *PubSubBus) Publish(ctx context.Context, topic string, data []byte) (string, error) {
+	t := b.client.Topic(topic)
+	defer t.Stop()
+	id, err := t.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return "", ErrTopicNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not publish to topic %s: %v", topic, err)
+	}
+	return id, nil
+}
+
+func (b *PubSubBus) SubscriptionExists(ctx context.Context, sub string) (bool, error) {
+	return b.client.Subscription(sub).Exists(ctx)
+}
+
+func (b *PubSubBus) Receive(ctx context.Context, sub string, h Handler) error {
+	s := b.client.Subscription(sub)
+	if exists, err := s.Exists(ctx); err != nil {
+		return fmt.Errorf("could not check if pubsub subscription exists: %v", err)
+	} else if !exists {
+		return ErrSubscriptionNotFound
+	}
+	s.ReceiveSettings = b.ReceiveSettings
+	return s.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+		err := h(ctx, &Message{ID: m.ID, Data: m.Data})
+		if err == nil {
+			m.Ack()
+			return
+		}
+		if IsBadMessage(err) {
+			log.Printf("dropping message %s of subscription %s: %v", m.ID, sub, err)
+			m.Ack()
+			return
+		}
+		// Pub/Sub delivers the message again
+		log.Printf("failed to handle message %s of subscription %s, it will be redelivered: %v", m.ID, sub, err)
+		m.Nack()
+	})
+}
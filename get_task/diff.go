@@ -0,0 +1,185 @@
+package get_task
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	. "github.com/protolambda/httphelpers/codes"
+	"github.com/protolambda/muskoka-server/blobs"
+	"github.com/protolambda/muskoka-server/model"
+	"github.com/protolambda/muskoka-server/specs"
+	"github.com/protolambda/muskoka-server/tasks"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultMaxDiffs = 1000
+const defaultMaxPostStateSize = 64 << 20
+
+// DiffHandler serves the differences between the post-states of two results of a task.
+type DiffHandler struct {
+	// Tasks is the task store to get tasks from.
+	Tasks tasks.Store
+	// Specs has the state types to decode the post-states with.
+	Specs *specs.Registry
+	// Results are the stores of the result files. Post-states in other places cannot be compared.
+	Results blobs.Sources
+	// the size limit of post-states, in bytes. The post-states are held in memory.
+	MaxPostStateSize int64
+	// maximum number of differences to list
+	MaxDiffs int
+}
+
+func NewDiffHandler(tasks tasks.Store, results blobs.Sources) *DiffHandler {
+	return &DiffHandler{
+		Tasks:            tasks,
+		Specs:            specs.NewDefaultRegistry(),
+		Results:          results,
+		MaxPostStateSize: defaultMaxPostStateSize,
+		MaxDiffs:         defaultMaxDiffs,
+	}
+}
+
+var defaultDiffHandler *DiffHandler
+var defaultDiffHandlerOnce sync.Once
+
+// the handler for the cloud function, with its dependencies configured by the environment.
+func getDefaultDiffHandler() *DiffHandler {
+	defaultDiffHandlerOnce.Do(func() {
+		ctx := context.Background()
+		taskStore, err := tasks.FromEnv(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create task store: %v", err)
+		}
+		results, err := blobs.ResultSourcesFromEnv(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create results stores: %v", err)
+		}
+		defaultDiffHandler = NewDiffHandler(taskStore, results)
+	})
+	return defaultDiffHandler
+}
+
+// DiffTask is the cloud function entry point for post-state differences, see DiffHandler.
+func DiffTask(w http.ResponseWriter, r *http.Request) {
+	getDefaultDiffHandler().ServeHTTP(w, r)
+}
+
+// DiffResult lists the differences between the post-states of two results.
+type DiffResult struct {
+	// the key of the task
+	Key string `json:"key"`
+	// the keys of the compared results
+	A string `json:"a"`
+	B string `json:"b"`
+	// the hash tree roots of the post-states
+	ARoot string `json:"a-root"`
+	BRoot string `json:"b-root"`
+	// the differing fields and list elements, see specs.ObjDef.Diff
+	Diffs []specs.FieldDiff `json:"diffs"`
+	// true if there are more differences than listed
+	Truncated bool `json:"truncated"`
+}
+
+// ServeHTTP compares the post-states of the results "a" and "b" (URL params) of the task.
+func (h *DiffHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if key == "" {
+		key = r.URL.Query().Get("key")
+	}
+	if !model.KeyRegex.Match([]byte(key)) {
+		SERVER_BAD_INPUT.Report(w, "task key is invalid")
+		return
+	}
+	a, b := r.URL.Query().Get("a"), r.URL.Query().Get("b")
+	if !model.ResultKeyRegex.Match([]byte(a)) || !model.ResultKeyRegex.Match([]byte(b)) {
+		SERVER_BAD_INPUT.Report(w, "specify the keys of the results to compare with the 'a' and 'b' URL params")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancel()
+	task, err := h.getTask(ctx, key)
+	if err == tasks.ErrNotFound {
+		w.WriteHeader(404)
+		return
+	}
+	if SERVER_ERR.Check(w, err, "could not get task by key") {
+		return
+	}
+	spec, err := h.Specs.Get(task.SpecVersion, task.SpecConfig)
+	if SERVER_BAD_INPUT.Check(w, err, "spec of the task is not supported") {
+		return
+	}
+	def, err := spec.Obj(specs.BeaconState)
+	if SERVER_ERR.Check(w, err, "spec has no state type") {
+		return
+	}
+
+	res := &DiffResult{Key: key, A: a, B: b}
+	var states [2]interface{}
+	for i, resultKey := range []string{a, b} {
+		result, ok := task.Results[resultKey]
+		if !ok {
+			http.Error(w, fmt.Sprintf("result %s does not exist", resultKey), http.StatusNotFound)
+			return
+		}
+		data, err := h.fetchPostState(ctx, &result)
+		if err == blobs.ErrNotFound {
+			http.Error(w, fmt.Sprintf("post-state of result %s does not exist", resultKey), http.StatusNotFound)
+			return
+		}
+		if err == blobs.ErrUnknownURL || err == errNoPostState || err == blobs.ErrTooLarge {
+			SERVER_BAD_INPUT.Report(w, fmt.Sprintf("cannot compare post-state of result %s: %v", resultKey, err))
+			return
+		}
+		if SERVER_ERR.Check(w, err, fmt.Sprintf("could not fetch post-state of result %s", resultKey)) {
+			return
+		}
+		state, err := def.Decode(data)
+		if SERVER_BAD_INPUT.Check(w, err, fmt.Sprintf("post-state of result %s is not a valid state", resultKey)) {
+			return
+		}
+		states[i] = state
+	}
+	res.ARoot, res.BRoot = def.HashTreeRoot(states[0]), def.HashTreeRoot(states[1])
+	res.Diffs, res.Truncated = def.Diff(states[0], states[1], h.MaxDiffs)
+	if res.Diffs == nil {
+		res.Diffs = make([]specs.FieldDiff, 0)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// results are not changed, but a repeated result may replace them.
+	w.Header().Set("Cache-Control", "max-age=30")
+	w.WriteHeader(int(SERVER_OK))
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(res); err != nil {
+		log.Printf("failed to encode diff response to JSON: %v", err)
+	}
+}
+
+func (h *DiffHandler) getTask(ctx context.Context, key string) (*model.Task, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	return h.Tasks.GetTask(ctx, key)
+}
+
+var errNoPostState = errors.New("result has no post-state file")
+
+// fetchPostState reads the post-state of the result from the store its URL refers to.
+func (h *DiffHandler) fetchPostState(ctx context.Context, result *model.ResultEntry) ([]byte, error) {
+	if result.Files.PostState == "" {
+		return nil, errNoPostState
+	}
+	rc, err := h.Results.GetLimited(ctx, result.Files.PostState, h.MaxPostStateSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
@@ -4,9 +4,22 @@ go 1.11
 
 require (
 	cloud.google.com/go v0.46.2 // indirect
-	cloud.google.com/go/firestore v1.0.0
 	github.com/gorilla/mux v1.7.3
 	github.com/protolambda/httphelpers v0.2.0
+	github.com/protolambda/muskoka-server/blobs v0.0.0
+	github.com/protolambda/muskoka-server/model v0.0.0
+	github.com/protolambda/muskoka-server/specs v0.0.0
+	github.com/protolambda/muskoka-server/tasks v0.0.0
+	github.com/protolambda/zssz v0.1.4
 	google.golang.org/api v0.10.0 // indirect
 	google.golang.org/grpc v1.23.1
+	gopkg.in/yaml.v2 v2.2.2
 )
+
+replace github.com/protolambda/muskoka-server/blobs => ../blobs
+
+replace github.com/protolambda/muskoka-server/model => ../model
+
+replace github.com/protolambda/muskoka-server/specs => ../specs
+
+replace github.com/protolambda/muskoka-server/tasks => ../tasks
@@ -0,0 +1,164 @@
+package get_task
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	. "github.com/protolambda/httphelpers/codes"
+	"github.com/protolambda/muskoka-server/blobs"
+	"github.com/protolambda/muskoka-server/model"
+	"github.com/protolambda/muskoka-server/specs"
+	"github.com/protolambda/muskoka-server/tasks"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// InputHandler serves the inputs of a task, decoded with the SSZ types of the spec of the task.
+type InputHandler struct {
+	// Tasks is the task store to get tasks from.
+	Tasks tasks.Store
+	// Inputs stores the transition inputs.
+	Inputs blobs.Store
+	// Specs has the state and block types to decode the inputs with.
+	Specs *specs.Registry
+}
+
+func NewInputHandler(tasks tasks.Store, inputs blobs.Store) *InputHandler {
+	return &InputHandler{
+		Tasks:  tasks,
+		Inputs: inputs,
+		Specs:  specs.NewDefaultRegistry(),
+	}
+}
+
+var defaultInputHandler *InputHandler
+var defaultInputHandlerOnce sync.Once
+
+// the handler for the cloud function, with its dependencies configured by the environment.
+func getDefaultInputHandler() *InputHandler {
+	defaultInputHandlerOnce.Do(func() {
+		ctx := context.Background()
+		taskStore, err := tasks.FromEnv(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create task store: %v", err)
+		}
+		inputs, err := blobs.FromEnv(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create inputs store: %v", err)
+		}
+		defaultInputHandler = NewInputHandler(taskStore, inputs)
+	})
+	return defaultInputHandler
+}
+
+// TaskInput is the cloud function entry point for decoded task inputs, see InputHandler.
+func TaskInput(w http.ResponseWriter, r *http.Request) {
+	getDefaultInputHandler().ServeHTTP(w, r)
+}
+
+// ServeHTTP serves the pre-state of the task, or the block with the index of the "block" route var or URL param.
+// The "format" URL param is "json" (default) or "yaml". The "path" URL param selects a part of the input, see specs.ObjDef.View.
+func (h *InputHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mVars := mux.Vars(r)
+	params := r.URL.Query()
+	key := mVars["key"]
+	if key == "" {
+		key = params.Get("key")
+	}
+	if !model.KeyRegex.Match([]byte(key)) {
+		SERVER_BAD_INPUT.Report(w, "task key is invalid")
+		return
+	}
+	blockStr, isBlock := mVars["block"]
+	if !isBlock {
+		blockStr, isBlock = params.Get("block"), params.Get("block") != ""
+	}
+	format := params.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "yaml" {
+		SERVER_BAD_INPUT.Report(w, "format must be json or yaml")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	task, err := h.Tasks.GetTask(ctx, key)
+	if err == tasks.ErrNotFound {
+		w.WriteHeader(404)
+		return
+	}
+	if SERVER_ERR.Check(w, err, "could not get task by key") {
+		return
+	}
+	spec, err := h.Specs.Get(task.SpecVersion, task.SpecConfig)
+	if SERVER_BAD_INPUT.Check(w, err, "spec of the task is not supported") {
+		return
+	}
+
+	objType, objKey := specs.BeaconState, task.Inputs.Pre
+	if isBlock {
+		i, err := strconv.ParseUint(blockStr, 10, 32)
+		if SERVER_BAD_INPUT.Check(w, err, "block index is invalid") {
+			return
+		}
+		if i >= uint64(len(task.Inputs.Blocks)) {
+			http.Error(w, fmt.Sprintf("task has %d blocks", len(task.Inputs.Blocks)), http.StatusNotFound)
+			return
+		}
+		objType, objKey = specs.BeaconBlock, task.Inputs.Blocks[i]
+	}
+	def, err := spec.Obj(objType)
+	if SERVER_ERR.Check(w, err, "spec cannot decode the input") {
+		return
+	}
+	rc, err := h.Inputs.Get(ctx, objKey)
+	if err == blobs.ErrNotFound {
+		http.Error(w, "input does not exist", http.StatusNotFound)
+		return
+	}
+	if SERVER_ERR.Check(w, err, "could not get input") {
+		return
+	}
+	defer rc.Close()
+	// inputs were checked when they were uploaded, and are within the size limits of the spec.
+	data, err := ioutil.ReadAll(rc)
+	if SERVER_ERR.Check(w, err, "could not read input") {
+		return
+	}
+	obj, err := def.Decode(data)
+	if SERVER_ERR.Check(w, err, "could not decode input") {
+		return
+	}
+	out, err := def.View(obj, params.Get("path"))
+	if err != nil {
+		SERVER_BAD_INPUT.Report(w, fmt.Sprintf("invalid path: %v", err))
+		return
+	}
+
+	// inputs never change
+	w.Header().Set("Cache-Control", "max-age=86400")
+	if format == "yaml" {
+		data, err := yaml.Marshal(out)
+		if SERVER_ERR.Check(w, err, "could not encode input to YAML") {
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-yaml")
+		w.WriteHeader(int(SERVER_OK))
+		_, _ = w.Write(data)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(int(SERVER_OK))
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(out); err != nil {
+		log.Printf("failed to encode input to JSON: %v", err)
+	}
+}
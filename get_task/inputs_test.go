@@ -0,0 +1,130 @@
+package get_task
+
+import (
+	"bytes"
+	"context"
+	"github.com/gorilla/mux"
+	"github.com/protolambda/muskoka-server/blobs"
+	"github.com/protolambda/muskoka-server/model"
+	"github.com/protolambda/muskoka-server/specs"
+	"github.com/protolambda/muskoka-server/tasks"
+	"github.com/protolambda/zssz"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// encodeInput encodes a zeroed v0.9.0 minimal object of the given type, with the given slot.
+func encodeInput(t *testing.T, objType specs.ObjType, slot uint64) []byte {
+	spec, err := specs.NewDefaultRegistry().Get("v0.9.0", "minimal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := spec.Obj(objType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := def.Alloc()
+	reflect.ValueOf(obj).Elem().FieldByName("Slot").SetUint(slot)
+	var buf bytes.Buffer
+	if _, err := zssz.Encode(&buf, obj, def.SSZ); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testDir creates a temporary directory, which is removed after the test.
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "muskoka-get-task-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+// newTestInputHandler creates an input handler with the stores in a temporary directory,
+// with a task "a" with a pre-state at slot 3, and a block at slot 4. The task store is closed after the test.
+func newTestInputHandler(t *testing.T) *InputHandler {
+	dir := testDir(t)
+	store, err := tasks.OpenBoltStore(filepath.Join(dir, "tasks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	inputs, err := blobs.NewLocalStore(filepath.Join(dir, "inputs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for key, data := range map[string][]byte{
+		"pre.ssz":     encodeInput(t, specs.BeaconState, 3),
+		"block_0.ssz": encodeInput(t, specs.BeaconBlock, 4),
+	} {
+		if err := inputs.Put(ctx, key, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	task := model.NewTask("v0.9.0", "minimal", 1, time.Now())
+	task.Inputs = model.TaskInputs{Pre: "pre.ssz", Blocks: []string{"block_0.ssz"}}
+	if err := store.CreateTask(ctx, "a", task); err != nil {
+		t.Fatal(err)
+	}
+	return NewInputHandler(store, inputs)
+}
+
+func TestInputHandler(t *testing.T) {
+	h := newTestInputHandler(t)
+	r := mux.NewRouter()
+	r.Handle("/task/{key}/pre", h)
+	r.Handle("/task/{key}/block/{block}", h)
+	// the cloud function gets the key and block as URL params
+	r.Handle("/input", h)
+	cases := []struct {
+		name        string
+		url         string
+		code        int
+		contentType string
+		// the expected start of the body
+		want string
+	}{
+		{"pre-state", "/task/a/pre", http.StatusOK, "application/json", `{"genesis_time":"0","slot":"3",`},
+		{"pre-state YAML", "/task/a/pre?format=yaml", http.StatusOK, "application/x-yaml", "genesis_time: 0\nslot: 3\n"},
+		{"block", "/task/a/block/0", http.StatusOK, "application/json", `{"slot":"4",`},
+		{"block YAML", "/task/a/block/0?format=yaml", http.StatusOK, "application/x-yaml", "slot: 4\n"},
+		{"path", "/task/a/pre?path=fork.epoch", http.StatusOK, "application/json", `"0"`},
+		{"path YAML", "/task/a/block/0?path=slot&format=yaml", http.StatusOK, "application/x-yaml", "4\n"},
+		{"block URL param", "/input?key=a&block=0&path=slot", http.StatusOK, "application/json", `"4"`},
+		{"pre-state URL param", "/input?key=a&path=slot", http.StatusOK, "application/json", `"3"`},
+		{"unknown format", "/task/a/pre?format=xml", http.StatusBadRequest, "", ""},
+		{"out of range index", "/task/a/pre?path=validators.0", http.StatusBadRequest, "", ""},
+		{"unknown field", "/task/a/pre?path=validator", http.StatusBadRequest, "", ""},
+		{"invalid block index", "/task/a/block/x", http.StatusBadRequest, "", ""},
+		{"out of range block", "/task/a/block/1", http.StatusNotFound, "", ""},
+		{"unknown task", "/task/b/pre", http.StatusNotFound, "", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest("GET", c.url, nil))
+			if rec.Code != c.code {
+				t.Fatalf("got status %d, expected %d: %s", rec.Code, c.code, rec.Body.String())
+			}
+			if c.code != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != c.contentType {
+				t.Errorf("got content type %q, expected %q", got, c.contentType)
+			}
+			if !strings.HasPrefix(rec.Body.String(), c.want) {
+				t.Errorf("got body %.60q..., expected it to start with %q", rec.Body.String(), c.want)
+			}
+		})
+	}
+}
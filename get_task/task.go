@@ -1,68 +1,70 @@
 package get_task
 
 import (
-	"cloud.google.com/go/firestore"
 	"context"
 	"encoding/json"
 	"github.com/gorilla/mux"
 	. "github.com/protolambda/httphelpers/codes"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"github.com/protolambda/muskoka-server/model"
+	"github.com/protolambda/muskoka-server/tasks"
 	"log"
 	"net/http"
-	"os"
-	"regexp"
+	"sync"
 	"time"
 )
 
-var fsTransitionsCollection *firestore.CollectionRef
+const defaultMaxLineage = 16
 
-func init() {
-	projectID := os.Getenv("GCP_PROJECT")
-	ctx := context.Background()
+// Handler serves single tasks with their results.
+type Handler struct {
+	// Tasks is the task store to get tasks from.
+	Tasks tasks.Store
+	// maximum number of ancestors to include in the lineage of a task
+	MaxLineage int
+	// the current time, to decide on caching
+	Now func() time.Time
+}
 
-	// database
-	{
-		firestoreClient, err := firestore.NewClient(ctx, projectID)
-		if err != nil {
-			log.Fatalf("Failed to create firestore client: %v", err)
-		}
-		fsTransitionsCollection = firestoreClient.Collection("transitions")
+func NewHandler(tasks tasks.Store) *Handler {
+	return &Handler{
+		Tasks:      tasks,
+		MaxLineage: defaultMaxLineage,
+		Now:        time.Now,
 	}
 }
 
-type Task struct {
-	Index       int                    `firestore:"index" json:"index"`
-	Blocks      int                    `firestore:"blocks" json:"blocks"`
-	SpecVersion string                 `firestore:"spec-version" json:"spec-version"`
-	SpecConfig  string                 `firestore:"spec-config" json:"spec-config"`
-	Created     time.Time              `firestore:"created" json:"created"`
-	Results     map[string]ResultEntry `firestore:"results" json:"results"`
-	// Ignored for listing purposes
-	//WorkersVersioned map[string]string      `firestore:"workers-versioned"`
-	//Workers          map[string]bool        `firestore:"workers"`
-	//HasFail     bool                        `firestore:"has-fail"`
-}
+var defaultHandler *Handler
+var defaultHandlerOnce sync.Once
 
-type ResultEntry struct {
-	Success       bool           `firestore:"success" json:"success"`
-	Created       time.Time      `firestore:"created" json:"created"`
-	ClientName    string         `firestore:"client-name" json:"client-name"`
-	ClientVersion string         `firestore:"client-version" json:"client-version"`
-	PostHash      string         `firestore:"post-hash" json:"post-hash"`
-	Files         ResultFilesRef `firestore:"files" json:"files"`
+// the handler for the cloud function, with its dependencies configured by the environment.
+func getDefaultHandler() *Handler {
+	defaultHandlerOnce.Do(func() {
+		taskStore, err := tasks.FromEnv(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to create task store: %v", err)
+		}
+		defaultHandler = NewHandler(taskStore)
+	})
+	return defaultHandler
 }
 
-type ResultFilesRef struct {
-	PostState string `firestore:"post-state" json:"post-state"`
-	ErrLog    string `firestore:"err-log" json:"err-log"`
-	OutLog    string `firestore:"out-log" json:"out-log"`
+// TaskResult is a task, with its lineage.
+type TaskResult struct {
+	*model.Task
+	// keys of the ancestors of the task, starting with its parent, the task it was forked from.
+	// Ends early if the lineage is longer than the handler MaxLineage.
+	Lineage []string `json:"lineage"`
+	// where the successful results first disagree, by their per-block and per-slot state roots.
+	// Nil if no result reported these roots.
+	Divergence *model.Divergence `json:"divergence"`
 }
 
-// make sure keys don't start with `__`, or underscores at all
-var KeyRegex, _ = regexp.Compile("^[-0-9a-zA-Z=][-_0-9a-zA-Z=]{0,128}$")
-
+// GetTask is the cloud function entry point, see Handler.
 func GetTask(w http.ResponseWriter, r *http.Request) {
+	getDefaultHandler().ServeHTTP(w, r)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	mVars := mux.Vars(r)
 	params := r.URL.Query()
 	var key string
@@ -76,38 +78,50 @@ func GetTask(w http.ResponseWriter, r *http.Request) {
 		SERVER_BAD_INPUT.Report(w, "No key specified. Set the 'key' URL param.")
 		return
 	}
-	if !KeyRegex.Match([]byte(key)) {
+	if !model.KeyRegex.Match([]byte(key)) {
 		SERVER_BAD_INPUT.Report(w, "task key is invalid")
 		return
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), time.Second*5)
-	dat, err := fsTransitionsCollection.Doc(key).Get(ctx)
-	if status.Code(err) == codes.NotFound || (err == nil && !dat.Exists()) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	task, err := h.Tasks.GetTask(ctx, key)
+	if err == tasks.ErrNotFound {
 		w.WriteHeader(404)
 		return
 	}
 	if SERVER_ERR.Check(w, err, "could not get task by key") {
 		return
 	}
-	var task Task
-	if err := dat.DataTo(&task); SERVER_ERR.Check(w, err, "could not parse task data retrieved from key") {
-		return
+
+	res := &TaskResult{Task: task, Lineage: make([]string, 0), Divergence: task.Divergence()}
+	for parent := task.Parent; parent != "" && len(res.Lineage) < h.MaxLineage; {
+		res.Lineage = append(res.Lineage, parent)
+		ancestor, err := h.Tasks.GetTask(ctx, parent)
+		if err == tasks.ErrNotFound {
+			break
+		}
+		if SERVER_ERR.Check(w, err, "could not get ancestor task") {
+			return
+		}
+		parent = ancestor.Parent
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 
+	now := h.Now()
+
 	// TODO: experimental caching to make repeated retrieval of historical data by the same viewers cheaper.
 	//  Lengths/triggers can be tweaked.
 	// if older than a week -> cache for a day
 	// if older than 3 hours -> cache for an hour
 	// if newer than 30 seconds -> no cache
 	// otherwise -> cache for 30 seconds
-	if task.Created.Add(time.Hour * 24 * 7).Before(time.Now()) {
+	if task.Created.Add(time.Hour * 24 * 7).Before(now) {
 		w.Header().Set("Cache-Control", "max-age=86400") // 1 day
-	} else if task.Created.Add(time.Hour * 3).Before(time.Now()) {
+	} else if task.Created.Add(time.Hour * 3).Before(now) {
 		w.Header().Set("Cache-Control", "max-age=3600") // 1 hour
-	} else if task.Created.Add(time.Second * 30).After(time.Now()) {
+	} else if task.Created.Add(time.Second * 30).After(now) {
 		w.Header().Set("Cache-Control", "no-cache") // no cache
 	} else {
 		w.Header().Set("Cache-Control", "max-age=30") // half a minute
@@ -115,7 +129,7 @@ func GetTask(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(int(SERVER_OK))
 	enc := json.NewEncoder(w)
-	if err := enc.Encode(&task); err != nil {
+	if err := enc.Encode(res); err != nil {
 		log.Printf("failed to encode query response to JSON: ")
 	}
 }
@@ -3,12 +3,20 @@ module github.com/protolambda/muskoka-server
 go 1.11
 
 require (
-	cloud.google.com/go/pubsub v1.0.1
 	github.com/google/go-cmp v0.3.1 // indirect
 	github.com/gorilla/mux v1.7.3
+	github.com/protolambda/muskoka-server/admin v0.0.0
+	github.com/protolambda/muskoka-server/auth v0.0.0
+	github.com/protolambda/muskoka-server/blobs v0.0.0
+	github.com/protolambda/muskoka-server/clientkeys v0.0.0
+	github.com/protolambda/muskoka-server/events v0.0.0
 	github.com/protolambda/muskoka-server/get_task v0.0.0
+	github.com/protolambda/muskoka-server/importer v0.0.0
 	github.com/protolambda/muskoka-server/listing v0.0.0
 	github.com/protolambda/muskoka-server/results v0.0.0
+	github.com/protolambda/muskoka-server/specs v0.0.0
+	github.com/protolambda/muskoka-server/model v0.0.0
+	github.com/protolambda/muskoka-server/tasks v0.0.0
 	github.com/protolambda/muskoka-server/upload v0.0.0
 	go.opencensus.io v0.22.1 // indirect
 	golang.org/x/exp v0.0.0-20190912063710-ac5d2bfcbfe0 // indirect
@@ -19,6 +27,10 @@ require (
 	google.golang.org/appengine v1.6.2 // indirect
 )
 
+replace github.com/protolambda/muskoka-server/blobs => ./blobs
+
+replace github.com/protolambda/muskoka-server/events => ./events
+
 replace github.com/protolambda/muskoka-server/listing => ./listing
 
 replace github.com/protolambda/muskoka-server/results => ./results
@@ -26,3 +38,17 @@ replace github.com/protolambda/muskoka-server/results => ./results
 replace github.com/protolambda/muskoka-server/upload => ./upload
 
 replace github.com/protolambda/muskoka-server/get_task => ./get_task
+
+replace github.com/protolambda/muskoka-server/model => ./model
+
+replace github.com/protolambda/muskoka-server/tasks => ./tasks
+
+replace github.com/protolambda/muskoka-server/specs => ./specs
+
+replace github.com/protolambda/muskoka-server/importer => ./importer
+
+replace github.com/protolambda/muskoka-server/admin => ./admin
+
+replace github.com/protolambda/muskoka-server/clientkeys => ./clientkeys
+
+replace github.com/protolambda/muskoka-server/auth => ./auth
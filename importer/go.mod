@@ -0,0 +1,30 @@
+module github.com/protolambda/muskoka-server/importer
+
+go 1.11
+
+require (
+	github.com/protolambda/httphelpers v0.2.0
+	github.com/protolambda/muskoka-server/auth v0.0.0
+	github.com/protolambda/muskoka-server/blobs v0.0.0
+	github.com/protolambda/muskoka-server/events v0.0.0
+	github.com/protolambda/muskoka-server/model v0.0.0
+	github.com/protolambda/muskoka-server/specs v0.0.0
+	github.com/protolambda/muskoka-server/tasks v0.0.0
+	github.com/protolambda/muskoka-server/upload v0.0.0
+	github.com/protolambda/zssz v0.1.4
+	gopkg.in/yaml.v2 v2.2.2
+)
+
+replace github.com/protolambda/muskoka-server/auth => ../auth
+
+replace github.com/protolambda/muskoka-server/blobs => ../blobs
+
+replace github.com/protolambda/muskoka-server/events => ../events
+
+replace github.com/protolambda/muskoka-server/model => ../model
+
+replace github.com/protolambda/muskoka-server/specs => ../specs
+
+replace github.com/protolambda/muskoka-server/tasks => ../tasks
+
+replace github.com/protolambda/muskoka-server/upload => ../upload
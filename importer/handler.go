@@ -0,0 +1,188 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	. "github.com/protolambda/httphelpers/codes"
+	"github.com/protolambda/muskoka-server/auth"
+	"github.com/protolambda/muskoka-server/blobs"
+	"github.com/protolambda/muskoka-server/events"
+	"github.com/protolambda/muskoka-server/tasks"
+	"github.com/protolambda/muskoka-server/upload"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+)
+
+const defaultMaxImportSize = 256 << 20
+
+const defaultMaxImportEntries = 2000
+
+// Handler imports spec test cases in bulk, from an uploaded archive, for admins. See the README for the form values.
+type Handler struct {
+	// Uploads checks and stores the inputs, and creates the tasks.
+	Uploads *upload.Handler
+	// CheckToken decides if the bearer token of the request belongs to an admin.
+	CheckToken func(token string) bool
+	// maximum total size of the test case files in an archive
+	MaxSize int64
+	// maximum number of entries in an archive
+	MaxEntries int
+}
+
+func NewHandler(uploads *upload.Handler) *Handler {
+	return &Handler{
+		Uploads: uploads,
+		CheckToken: func(token string) bool {
+			return false
+		},
+		MaxSize:    defaultMaxImportSize,
+		MaxEntries: defaultMaxImportEntries,
+	}
+}
+
+var defaultHandler *Handler
+var defaultHandlerOnce sync.Once
+
+// the handler for the cloud function, with its dependencies configured by the environment.
+func getDefaultHandler() *Handler {
+	defaultHandlerOnce.Do(func() {
+		ctx := context.Background()
+		inputs, err := blobs.FromEnv(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create inputs store: %v", err)
+		}
+		taskStore, err := tasks.FromEnv(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create task store: %v", err)
+		}
+		bus, err := events.FromEnv(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create event bus: %v", err)
+		}
+		// imported tasks are not chained from results, no result stores are needed.
+		uploads := upload.NewHandler(inputs, taskStore, bus, nil)
+		uploads.LegacyInputs = upload.LegacyInputsFromEnv()
+		defaultHandler = NewHandler(uploads)
+		defaultHandler.CheckToken = auth.TokenChecker(os.Getenv("MUSKOKA_ADMIN_TOKEN"))
+	})
+	return defaultHandler
+}
+
+// Import is the cloud function entry point, see Handler.
+func Import(w http.ResponseWriter, r *http.Request) {
+	getDefaultHandler().ServeHTTP(w, r)
+}
+
+type CaseResult struct {
+	// name of the case, its path in the archive
+	Case string `json:"case"`
+	// key of the created task, empty if the case could not be imported
+	Key string `json:"key"`
+	// why the case could not be imported, empty if it was imported
+	Error string `json:"error"`
+	// true if the case was imported without a post-state: the transition is expected to fail,
+	// but the task has no expected post-state to judge results against.
+	Unjudged bool `json:"unjudged"`
+}
+
+type ImportResult struct {
+	Cases []CaseResult `json:"cases"`
+	// why the import stopped, empty if the whole upload was read. The listed cases were imported before it stopped.
+	Error string `json:"error"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if token, ok := auth.BearerToken(r); !ok || !h.CheckToken(token) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	mr, err := r.MultipartReader()
+	if SERVER_BAD_INPUT.Check(w, err, "cannot parse multipart upload") {
+		return
+	}
+	im := &Importer{Uploads: h.Uploads}
+	res := ImportResult{Cases: make([]CaseResult, 0)}
+	archived := false
+	// once the archive is read, tasks may have been created: the cases are reported together with the error.
+	fail := func(msg string) {
+		if !archived {
+			SERVER_BAD_INPUT.Report(w, msg)
+			return
+		}
+		log.Println(msg)
+		res.Error = msg
+		writeImportResult(w, int(SERVER_BAD_INPUT), &res)
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail("cannot parse multipart upload: " + err.Error())
+			return
+		}
+		switch name := part.FormName(); name {
+		case "spec-version", "spec-config":
+			value, err := upload.ReadFormValue(part)
+			if err != nil {
+				fail("cannot read form value " + name + ": " + err.Error())
+				return
+			}
+			if archived {
+				fail("form value " + name + " must precede the archive")
+				return
+			}
+			if name == "spec-version" {
+				im.SpecVersion = value
+			} else {
+				im.SpecConfig = value
+			}
+		case "archive":
+			if archived {
+				fail("only one archive can be imported at a time")
+				return
+			}
+			if _, err := h.Uploads.Specs.Get(im.SpecVersion, im.SpecConfig); SERVER_BAD_INPUT.Check(w, err, "Cannot recognize provided spec version + config") {
+				return
+			}
+			archived = true
+			err := ReadArchive(h.Uploads.TempDir, part, h.MaxSize, h.MaxEntries, func(c *Case, err error) error {
+				// a case that is invalid, or cannot be imported, does not stop the import of the other cases
+				cr := CaseResult{Case: c.Name}
+				if err != nil {
+					cr.Error = err.Error()
+				} else if task, err := im.Import(c); err != nil {
+					cr.Error = err.Error()
+				} else {
+					cr.Key = task.Key
+					cr.Unjudged = c.Post == nil
+				}
+				res.Cases = append(res.Cases, cr)
+				return nil
+			})
+			if err != nil {
+				fail("invalid archive: " + err.Error())
+				return
+			}
+		}
+		_ = part.Close()
+	}
+	if !archived {
+		SERVER_BAD_INPUT.Report(w, "no archive was specified")
+		return
+	}
+	writeImportResult(w, int(SERVER_OK), &res)
+}
+
+func writeImportResult(w http.ResponseWriter, code int, res *ImportResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(res); err != nil {
+		log.Printf("failed to encode import response to JSON: %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/protolambda/muskoka-server/auth"
+	"github.com/protolambda/muskoka-server/blobs"
+	"github.com/protolambda/muskoka-server/events"
+	"github.com/protolambda/muskoka-server/specs"
+	"github.com/protolambda/muskoka-server/tasks"
+	"github.com/protolambda/muskoka-server/upload"
+	"github.com/protolambda/zssz"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// encodeInput encodes a zeroed object of the v0.9.0 minimal type with the given slot.
+func encodeInput(t *testing.T, objType specs.ObjType, slot uint64) string {
+	spec, err := specs.NewDefaultRegistry().Get("v0.9.0", "minimal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := spec.Obj(objType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := def.Alloc()
+	reflect.ValueOf(obj).Elem().FieldByName("Slot").SetUint(slot)
+	var buf bytes.Buffer
+	if _, err := zssz.Encode(&buf, obj, def.SSZ); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// newTestHandler creates an import handler with the stores in a temporary directory, which is removed after the test.
+// The admin token is "secret".
+func newTestHandler(t *testing.T) *Handler {
+	dir, err := ioutil.TempDir("", "muskoka-importer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	taskStore, err := tasks.OpenBoltStore(filepath.Join(dir, "tasks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = taskStore.Close() })
+	inputs, err := blobs.NewLocalStore(filepath.Join(dir, "inputs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewLocalBus()
+	bus.CreateTopic(events.TransitionTopic("v0.9.0", "minimal"))
+	h := NewHandler(upload.NewHandler(inputs, taskStore, bus, nil))
+	h.CheckToken = auth.TokenChecker("secret")
+	return h
+}
+
+// postImport imports the archives, in one upload, for v0.9.0 minimal.
+func postImport(t *testing.T, h *Handler, archives ...[]byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, kv := range [][2]string{{"spec-version", "v0.9.0"}, {"spec-config", "minimal"}} {
+		if err := mw.WriteField(kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, archive := range archives {
+		w, err := mw.CreateFormFile("archive", "cases.tar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(archive); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlerImport(t *testing.T) {
+	h := newTestHandler(t)
+	pre := encodeInput(t, specs.BeaconState, 1)
+	block := encodeInput(t, specs.BeaconBlock, 2)
+	archive := tarArchive(t, []testFile{
+		{"judged/pre.ssz", pre},
+		{"judged/blocks_0.ssz", block},
+		{"judged/post.ssz", encodeInput(t, specs.BeaconState, 2)},
+		{"unjudged/pre.ssz", pre},
+		{"unjudged/blocks_0.ssz", block},
+		{"invalid/pre.ssz", "not a state"},
+		{"invalid/blocks_0.ssz", block},
+	})
+	rec := postImport(t, h, archive)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	var res ImportResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	type caseSummary struct {
+		name     string
+		imported bool
+		unjudged bool
+	}
+	got := make([]caseSummary, 0, len(res.Cases))
+	for _, c := range res.Cases {
+		got = append(got, caseSummary{c.Case, c.Key != "", c.Unjudged})
+	}
+	want := []caseSummary{{"invalid", false, false}, {"judged", true, false}, {"unjudged", true, true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got cases %+v, expected %+v", got, want)
+	}
+	if res.Error != "" {
+		t.Errorf("got error %q of a complete import", res.Error)
+	}
+}
+
+func TestHandlerImportStopped(t *testing.T) {
+	pre := encodeInput(t, specs.BeaconState, 1)
+	block := encodeInput(t, specs.BeaconBlock, 2)
+	archive := tarArchive(t, []testFile{{"a/pre.ssz", pre}, {"a/blocks_0.ssz", block}})
+	cases := []struct {
+		name     string
+		archives [][]byte
+		// the cases that are reported as imported, before the import stopped
+		imported []string
+		err      string
+	}{
+		{"invalid archive", [][]byte{[]byte("not an archive")}, []string{}, "invalid archive"},
+		{"second archive", [][]byte{archive, archive}, []string{"a"}, "only one archive can be imported at a time"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rec := postImport(t, newTestHandler(t), c.archives...)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, expected %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+			}
+			var res ImportResult
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			imported := make([]string, 0)
+			for _, cr := range res.Cases {
+				if cr.Key != "" {
+					imported = append(imported, cr.Case)
+				}
+			}
+			if !reflect.DeepEqual(imported, c.imported) {
+				t.Errorf("got imported cases %q, expected %q", imported, c.imported)
+			}
+			if !strings.Contains(res.Error, c.err) {
+				t.Errorf("got error %q, expected error %q", res.Error, c.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package importer
+
+import (
+	"github.com/protolambda/muskoka-server/model"
+	"github.com/protolambda/muskoka-server/upload"
+)
+
+// Importer creates tasks from spec test cases.
+type Importer struct {
+	// Uploads checks and stores the inputs, and creates the tasks, like any other upload.
+	Uploads *upload.Handler
+	// the spec the test cases are imported for
+	SpecVersion string
+	SpecConfig  string
+}
+
+// Import creates a task for the case. The root of the post-state of the case, if any, is recorded as expected post-state root.
+func (im *Importer) Import(c *Case) (*model.Task, error) {
+	return im.Uploads.CreateTask(&upload.TaskData{
+		SpecVersion:  im.SpecVersion,
+		SpecConfig:   im.SpecConfig,
+		Pre:          c.Pre,
+		Blocks:       c.Blocks,
+		ExpectedPost: c.Post,
+	})
+}
@@ -0,0 +1,239 @@
+package importer
+
+import (
+	"fmt"
+	"github.com/protolambda/muskoka-server/upload"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Case is a spec test case of the sanity/blocks format: a pre-state, a list of blocks, and optionally a post-state.
+// Cases without a post-state expect the transition to fail. Their tasks have no expected post-state,
+// results of these tasks are not judged.
+type Case struct {
+	// path of the case, relative to the imported directory or archive
+	Name   string
+	Pre    []byte
+	Blocks [][]byte
+	// nil if the case does not have a post-state
+	Post []byte
+}
+
+// the meta.yaml file of a case, only the fields of interest.
+type caseMeta struct {
+	BlocksCount *int `yaml:"blocks_count"`
+}
+
+// the files of a case, by file name
+type caseFiles map[string][]byte
+
+func isCaseFile(name string) bool {
+	return name == "pre.ssz" || name == "post.ssz" || name == "meta.yaml" ||
+		(strings.HasPrefix(name, "blocks_") && strings.HasSuffix(name, ".ssz"))
+}
+
+// toCase converts the files to a case. The returned case is named even if the files are not a valid case.
+func (files caseFiles) toCase(name string) (*Case, error) {
+	c := &Case{Name: name, Pre: files["pre.ssz"], Post: files["post.ssz"]}
+	if c.Pre == nil {
+		return &Case{Name: name}, fmt.Errorf("case %s has no pre-state", name)
+	}
+	blocksCount := -1
+	if metaData, ok := files["meta.yaml"]; ok {
+		var meta caseMeta
+		if err := yaml.Unmarshal(metaData, &meta); err != nil {
+			return &Case{Name: name}, fmt.Errorf("case %s has an invalid meta.yaml: %v", name, err)
+		}
+		if meta.BlocksCount != nil {
+			blocksCount = *meta.BlocksCount
+		}
+	}
+	for i := 0; blocksCount < 0 || i < blocksCount; i++ {
+		block, ok := files[fmt.Sprintf("blocks_%d.ssz", i)]
+		if !ok {
+			if blocksCount < 0 {
+				// without meta, the blocks are all consecutive block files
+				break
+			}
+			return &Case{Name: name}, fmt.Errorf("case %s is missing block %d", name, i)
+		}
+		c.Blocks = append(c.Blocks, block)
+	}
+	return c, nil
+}
+
+// CaseFunc is called for each case that is found. If the case is invalid, or cannot be read, err is set,
+// and the case only has a name. Other cases are still read after an invalid case.
+// Returning an error stops the reading of cases.
+type CaseFunc func(c *Case, err error) error
+
+// ReadDir finds all cases in the directory tree, and calls fn for each, ordered by name.
+// A case is a directory with a "pre.ssz" file.
+// The tree is walked before fn is called: an error walking it is returned before any case.
+func ReadDir(root string, fn CaseFunc) error {
+	var caseDirs []string
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && info.Name() == "pre.ssz" {
+			caseDirs = append(caseDirs, filepath.Dir(p))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not walk %s: %v", root, err)
+	}
+	sort.Strings(caseDirs)
+	for _, dir := range caseDirs {
+		name, err := filepath.Rel(root, dir)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		files, err := readCaseDir(dir)
+		var c *Case
+		if err != nil {
+			c, err = &Case{Name: name}, fmt.Errorf("could not read case %s: %v", name, err)
+		} else {
+			c, err = files.toCase(name)
+		}
+		if err := fn(c, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCaseDir reads the case files in the directory.
+func readCaseDir(dir string) (caseFiles, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(caseFiles)
+	for _, info := range infos {
+		if info.IsDir() || !isCaseFile(info.Name()) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files[info.Name()] = data
+	}
+	return files, nil
+}
+
+// ReadArchive finds all cases in a tar, tar.gz or zip archive, and calls fn for each, ordered by name.
+// The archive and the case files are spooled to temporary files in tempDir, see upload.SpoolInput, and removed before returning.
+// Only the files of one case are held in memory at a time.
+// The sum of the decompressed sizes of the entries is limited by maxSize, and the number of entries by maxEntries.
+// Entries that are not part of a case are read and dropped, so that they count towards the size limit too.
+// The whole archive is read before fn is called: an invalid archive is rejected before any case.
+func ReadArchive(tempDir string, r io.Reader, maxSize int64, maxEntries int, fn CaseFunc) error {
+	// a tar archive adds a header and padding to every entry, a compressed archive adds less than that.
+	archive, err := upload.SpoolInput(tempDir, r, maxSize+int64(maxEntries)*1024+(1<<20))
+	if err != nil {
+		return fmt.Errorf("archive is too large: %v", err)
+	}
+	defer archive.Close()
+	// case dir -> file name -> spooled file
+	cases := make(map[string]map[string]*upload.SpooledInput)
+	defer func() {
+		for _, files := range cases {
+			for _, f := range files {
+				_ = f.Close()
+			}
+		}
+	}()
+	remaining := maxSize
+	entries := 0
+	err = upload.WalkArchive(archive, func(e *upload.ArchiveEntry) error {
+		if entries >= maxEntries {
+			return fmt.Errorf("archive has too many entries, limit is %d", maxEntries)
+		}
+		entries++
+		if e.Contents == nil {
+			// directories, links and other entries without contents
+			return nil
+		}
+		name := path.Clean(strings.TrimPrefix(e.Name, "./"))
+		dir, file := path.Split(name)
+		if !isCaseFile(file) {
+			// the archive reader would decompress the skipped contents all the same, these are charged to the limit.
+			n, err := io.CopyN(ioutil.Discard, e.Contents, remaining+1)
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("could not read archive entry %s: %v", name, err)
+			}
+			if n > remaining {
+				return fmt.Errorf("archive is too large, limit is %d bytes", maxSize)
+			}
+			remaining -= n
+			return nil
+		}
+		if e.Size > remaining {
+			return fmt.Errorf("archive is too large, limit is %d bytes", maxSize)
+		}
+		dir = strings.TrimSuffix(dir, "/")
+		files, ok := cases[dir]
+		if !ok {
+			files = make(map[string]*upload.SpooledInput)
+			cases[dir] = files
+		}
+		// the declared size cannot be trusted, the limit is enforced while reading
+		f, err := upload.SpoolInput(tempDir, e.Contents, remaining)
+		if err != nil {
+			return fmt.Errorf("could not read archive entry %s: %v", name, err)
+		}
+		// like tar, a later entry with the same name replaces the earlier one
+		if prev, ok := files[file]; ok {
+			_ = prev.Close()
+		}
+		files[file] = f
+		remaining -= f.Size
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(cases))
+	for dir, files := range cases {
+		if _, ok := files["pre.ssz"]; ok {
+			names = append(names, dir)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		files, err := readSpooledCase(cases[name])
+		var c *Case
+		if err != nil {
+			c, err = &Case{Name: name}, fmt.Errorf("could not read case %s: %v", name, err)
+		} else {
+			c, err = files.toCase(name)
+		}
+		if err := fn(c, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSpooledCase reads the spooled case files into memory.
+func readSpooledCase(spooled map[string]*upload.SpooledInput) (caseFiles, error) {
+	files := make(caseFiles, len(spooled))
+	for name, f := range spooled {
+		data, err := ioutil.ReadAll(f.Reader())
+		if err != nil {
+			return nil, err
+		}
+		files[name] = data
+	}
+	return files, nil
+}
@@ -0,0 +1,297 @@
+package importer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testFile struct {
+	name string
+	data string
+}
+
+func tarArchive(t *testing.T, files []testFile) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(f.data))}
+		if strings.HasSuffix(f.name, "/") {
+			hdr = &tar.Header{Name: f.name, Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T, files []testFile) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// collectCases is a CaseFunc that summarizes every case, or its error, as a line.
+func collectCases(out *[]string) CaseFunc {
+	return func(c *Case, err error) error {
+		if err != nil {
+			*out = append(*out, fmt.Sprintf("%s: error", c.Name))
+			return nil
+		}
+		blocks := make([]string, 0, len(c.Blocks))
+		for _, b := range c.Blocks {
+			blocks = append(blocks, string(b))
+		}
+		*out = append(*out, fmt.Sprintf("%s: pre=%s blocks=%s post=%s", c.Name, c.Pre, strings.Join(blocks, ","), c.Post))
+		return nil
+	}
+}
+
+// the files of a test case directory
+var testCaseFiles = map[string][]testFile{
+	"valid": {
+		{"meta.yaml", "blocks_count: 2\n"},
+		{"pre.ssz", "p"},
+		{"blocks_0.ssz", "b0"},
+		{"blocks_1.ssz", "b1"},
+		{"post.ssz", "q"},
+	},
+	"no_meta": {
+		{"pre.ssz", "p"},
+		{"blocks_0.ssz", "b0"},
+		{"blocks_2.ssz", "b2"},
+	},
+	"one_block": {
+		{"pre.ssz", "p"},
+		{"blocks_0.ssz", "b0"},
+	},
+	"missing_block": {
+		{"meta.yaml", "blocks_count: 2\n"},
+		{"pre.ssz", "p"},
+		{"blocks_0.ssz", "b0"},
+	},
+	"invalid_meta": {
+		{"meta.yaml", "blocks_count: [\n"},
+		{"pre.ssz", "p"},
+	},
+	"no_pre": {
+		{"blocks_0.ssz", "b0"},
+	},
+}
+
+func caseFilesIn(dir string, names ...string) []testFile {
+	var out []testFile
+	for _, name := range names {
+		for _, f := range testCaseFiles[name] {
+			out = append(out, testFile{name: dir + name + "/" + f.name, data: f.data})
+		}
+	}
+	return out
+}
+
+func TestReadArchive(t *testing.T) {
+	all := caseFilesIn("tests/", "valid", "no_meta", "one_block", "missing_block", "invalid_meta", "no_pre")
+	cases := []struct {
+		name       string
+		archive    func(t *testing.T) []byte
+		maxSize    int64
+		maxEntries int
+		want       []string
+		err        string
+	}{
+		{
+			name:       "cases",
+			archive:    func(t *testing.T) []byte { return tarArchive(t, all) },
+			maxSize:    1000,
+			maxEntries: 100,
+			want: []string{
+				"tests/invalid_meta: error",
+				"tests/missing_block: error",
+				"tests/no_meta: pre=p blocks=b0 post=",
+				"tests/one_block: pre=p blocks=b0 post=",
+				"tests/valid: pre=p blocks=b0,b1 post=q",
+			},
+		},
+		{
+			name:       "gzip",
+			archive:    func(t *testing.T) []byte { return gzipData(t, tarArchive(t, caseFilesIn("", "valid"))) },
+			maxSize:    1000,
+			maxEntries: 100,
+			want:       []string{"valid: pre=p blocks=b0,b1 post=q"},
+		},
+		{
+			name:       "zip",
+			archive:    func(t *testing.T) []byte { return zipArchive(t, caseFilesIn("tests/", "valid", "missing_block")) },
+			maxSize:    1000,
+			maxEntries: 100,
+			want:       []string{"tests/missing_block: error", "tests/valid: pre=p blocks=b0,b1 post=q"},
+		},
+		{
+			name: "dot prefix and directories",
+			archive: func(t *testing.T) []byte {
+				return tarArchive(t, append([]testFile{{"./", ""}, {"./valid/", ""}}, caseFilesIn("./", "valid")...))
+			},
+			maxSize:    1000,
+			maxEntries: 100,
+			want:       []string{"valid: pre=p blocks=b0,b1 post=q"},
+		},
+		{
+			name: "other files are skipped",
+			archive: func(t *testing.T) []byte {
+				return tarArchive(t, append(caseFilesIn("", "valid"), testFile{"valid/README.md", strings.Repeat("x", 200)}))
+			},
+			maxSize:    1000,
+			maxEntries: 100,
+			want:       []string{"valid: pre=p blocks=b0,b1 post=q"},
+		},
+		{
+			name: "other files count towards the size limit",
+			archive: func(t *testing.T) []byte {
+				return gzipData(t, tarArchive(t, append(caseFilesIn("", "valid"), testFile{"valid/README.md", strings.Repeat("x", 2000)})))
+			},
+			maxSize:    1000,
+			maxEntries: 100,
+			err:        "archive is too large",
+		},
+		{
+			name:       "size limit",
+			archive:    func(t *testing.T) []byte { return tarArchive(t, caseFilesIn("", "valid")) },
+			maxSize:    8,
+			maxEntries: 100,
+			err:        "archive is too large",
+		},
+		{
+			name:       "entry limit",
+			archive:    func(t *testing.T) []byte { return tarArchive(t, caseFilesIn("", "valid")) },
+			maxSize:    1000,
+			maxEntries: 4,
+			err:        "too many entries",
+		},
+		{
+			name:       "not an archive",
+			archive:    func(t *testing.T) []byte { return []byte(strings.Repeat("not an archive ", 100)) },
+			maxSize:    1000,
+			maxEntries: 100,
+			err:        "invalid tar archive",
+		},
+		{
+			name:       "invalid gzip",
+			archive:    func(t *testing.T) []byte { return []byte{0x1f, 0x8b, 0x00} },
+			maxSize:    1000,
+			maxEntries: 100,
+			err:        "invalid gzip archive",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "muskoka-importer-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			var got []string
+			err = ReadArchive(dir, bytes.NewReader(c.archive(t)), c.maxSize, c.maxEntries, collectCases(&got))
+			// the archive and the case files are removed, whether the archive was accepted or not
+			left, readErr := ioutil.ReadDir(dir)
+			if readErr != nil {
+				t.Fatal(readErr)
+			}
+			if len(left) != 0 {
+				t.Errorf("%d temporary files were not removed", len(left))
+			}
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("got error %v, expected error %q", err, c.err)
+				}
+				// an invalid archive is rejected before any case
+				if len(got) != 0 {
+					t.Errorf("got cases %v of an invalid archive", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got cases %q, expected %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "muskoka-importer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, f := range caseFilesIn("tests/", "valid", "missing_block", "no_pre") {
+		p := filepath.Join(root, filepath.FromSlash(f.name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(f.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	if err := ReadDir(root, collectCases(&got)); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"tests/missing_block: error",
+		"tests/valid: pre=p blocks=b0,b1 post=q",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got cases %q, expected %q", got, want)
+	}
+
+	stop := fmt.Errorf("stop")
+	calls := 0
+	err = ReadDir(root, func(c *Case, err error) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("got error %v after %d cases, expected the error of the first case", err, calls)
+	}
+}
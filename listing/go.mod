@@ -4,8 +4,13 @@ go 1.11
 
 require (
 	cloud.google.com/go v0.46.2 // indirect
-	cloud.google.com/go/firestore v1.0.0
 	github.com/protolambda/httphelpers v0.2.0
+	github.com/protolambda/muskoka-server/model v0.0.0
+	github.com/protolambda/muskoka-server/tasks v0.0.0
 	google.golang.org/api v0.10.0
-	google.golang.org/grpc v1.23.1 // indirect
+	google.golang.org/grpc v1.23.1
 )
+
+replace github.com/protolambda/muskoka-server/model => ../model
+
+replace github.com/protolambda/muskoka-server/tasks => ../tasks
@@ -0,0 +1,126 @@
+package listing
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/protolambda/muskoka-server/model"
+	"github.com/protolambda/muskoka-server/tasks"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestHandler creates a listing handler of a bolt store in a temporary directory, which is removed after the test.
+// The store has tasks "a" to "f", at index 0 to 5, created a month before the current time of the handler.
+// At most 2 tasks are listed, so that listings of the older tasks can be cached.
+func newTestHandler(t *testing.T) *Handler {
+	dir, err := ioutil.TempDir("", "muskoka-listing-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	store, err := tasks.OpenBoltStore(filepath.Join(dir, "tasks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	ok := func(client string, postHash string) *model.ResultEntry {
+		return &model.ResultEntry{Success: true, Outcome: model.OutcomeOK, ClientName: client, ClientVersion: "v1",
+			PostHash: postHash, StateRoot: "0x06"}
+	}
+	results := map[string][]*model.ResultEntry{
+		// disagreement on the post-hash
+		"a": {ok("zrnt", "0x01"), ok("prysm", "0x02")},
+		"b": {ok("zrnt", "0x01")},
+		"c": {{Outcome: model.OutcomeCrash, ClientName: "zrnt", ClientVersion: "v1"}},
+		// mismatches the expected post-state, see below
+		"d": {ok("zrnt", "0x01")},
+		"e": {ok("zrnt", "0x01"), ok("prysm", "0x02"), ok("lighthouse", "0x03")},
+		"f": nil,
+	}
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		if err := store.CreateTask(ctx, key, model.NewTask("v0.9.0", "minimal", 1, now.Add(-time.Hour*24*30))); err != nil {
+			t.Fatal(err)
+		}
+		for _, result := range results[key] {
+			if _, err := store.MergeResult(ctx, key, result.ClientName, result, tasks.KeepFirst); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := store.SetExpectedPostRoot(ctx, "d", "0x05"); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(store)
+	h.DefaultLimit = 2
+	h.MaxLimit = 2
+	h.Now = func() time.Time {
+		return now
+	}
+	return h
+}
+
+func TestHandler(t *testing.T) {
+	h := newTestHandler(t)
+	cases := []struct {
+		name string
+		url  string
+		code int
+		// the keys of the listed tasks, in order
+		keys         []string
+		cacheControl string
+	}{
+		{"latest", "/", http.StatusOK, []string{"f", "e"}, "no-cache"},
+		{"older tasks are cached", "/?after=3", http.StatusOK, []string{"c", "b"}, "max-age=86400"},
+		{"has-fail", "/?has-fail=true", http.StatusOK, []string{"c"}, "max-age=86400"},
+		{"mismatch-expected", "/?mismatch-expected=true", http.StatusOK, []string{"d"}, "no-cache"},
+		{"has-disagreement", "/?has-disagreement=true", http.StatusOK, []string{"e", "a"}, "no-cache"},
+		{"has-disagreement of older tasks", "/?has-disagreement=true&after=1", http.StatusOK, []string{"a"}, "no-cache"},
+		{"outcome of older tasks", "/?outcome-zrnt=crash&after=3", http.StatusOK, []string{"c"}, "no-cache"},
+		{"disagreement order", "/?order=disagreement", http.StatusOK, []string{"e", "a"}, "no-cache"},
+		{"disagreement order with offset", "/?order=disagreement&offset=1", http.StatusOK, []string{"a", "d"}, "no-cache"},
+		{"latest order", "/?order=latest&after=3", http.StatusOK, []string{"c", "b"}, "max-age=86400"},
+		{"disagreement order after an index", "/?order=disagreement&after=1", http.StatusBadRequest, nil, ""},
+		{"unknown order", "/?order=oldest", http.StatusBadRequest, nil, ""},
+		{"invalid offset", "/?offset=-1", http.StatusBadRequest, nil, ""},
+		{"invalid outcome", "/?outcome-zrnt=broken", http.StatusBadRequest, nil, ""},
+		{"limit too high", "/?limit=3", http.StatusBadRequest, nil, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", c.url, nil))
+			if rec.Code != c.code {
+				t.Fatalf("got status %d, expected %d: %s", rec.Code, c.code, rec.Body.String())
+			}
+			if c.code != http.StatusOK {
+				return
+			}
+			var res ListingResult
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			keys := make([]string, 0, len(res.Tasks))
+			for _, task := range res.Tasks {
+				keys = append(keys, task.Key)
+			}
+			if !reflect.DeepEqual(keys, c.keys) {
+				t.Errorf("got tasks %q, expected %q", keys, c.keys)
+			}
+			if res.TotalTaskCount != 6 {
+				t.Errorf("got total task count %d, expected 6", res.TotalTaskCount)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != c.cacheControl {
+				t.Errorf("got cache control %q, expected %q", got, c.cacheControl)
+			}
+		})
+	}
+}
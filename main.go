@@ -1,37 +1,138 @@
 package main
 
 import (
-	"cloud.google.com/go/pubsub"
 	"context"
+	"flag"
+	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/protolambda/muskoka-server/admin"
+	"github.com/protolambda/muskoka-server/auth"
+	"github.com/protolambda/muskoka-server/blobs"
+	"github.com/protolambda/muskoka-server/clientkeys"
+	"github.com/protolambda/muskoka-server/events"
 	"github.com/protolambda/muskoka-server/get_task"
+	"github.com/protolambda/muskoka-server/importer"
 	"github.com/protolambda/muskoka-server/listing"
+	"github.com/protolambda/muskoka-server/model"
 	"github.com/protolambda/muskoka-server/results"
+	"github.com/protolambda/muskoka-server/specs"
+	"github.com/protolambda/muskoka-server/tasks"
 	"github.com/protolambda/muskoka-server/upload"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
-var pubsubClient *pubsub.Client
+var bus events.Bus
 
 func main() {
-	// Setup pubsub client
-	{
-		cl, err := pubsub.NewClient(context.Background(), os.Getenv("GCP_PROJECT"))
+	local := flag.Bool("local", false,
+		"run without a cloud account: local inputs storage, local task database and an in-process event bus")
+	localDir := flag.String("local-dir", "muskoka-local",
+		"directory to keep all data in when running with --local")
+	inputsDir := flag.String("inputs-dir", os.Getenv("TRANSITIONS_DIR"),
+		"local directory to store and serve transition inputs from, instead of the cloud storage bucket")
+	tasksDB := flag.String("tasks-db", os.Getenv("MUSKOKA_TASKS_DB"),
+		"local database file to store tasks and results in, instead of firestore")
+	duplicateResults := flag.String("duplicate-results", os.Getenv("MUSKOKA_DUPLICATE_RESULTS"),
+		"what to do with repeated result messages: keep-first (default), keep-latest or keep-all")
+	clientKeys := flag.String("client-keys", os.Getenv("MUSKOKA_CLIENT_KEYS"),
+		"JSON file with the public keys of clients, to only accept results signed by their client")
+	clientTokens := flag.String("client-tokens", os.Getenv("MUSKOKA_CLIENT_TOKENS"),
+		"JSON file with the tokens of clients, to authenticate results submitted over HTTP")
+	verifyResults := flag.String("verify-results", os.Getenv("MUSKOKA_VERIFY_RESULTS"),
+		"verify the post-state files of results: off (default), inline or background")
+	flag.Parse()
+
+	if *local {
+		if *inputsDir == "" {
+			*inputsDir = filepath.Join(*localDir, "inputs")
+		}
+		if *tasksDB == "" {
+			*tasksDB = filepath.Join(*localDir, "tasks.db")
+		}
+		if err := os.MkdirAll(*localDir, 0755); err != nil {
+			log.Fatalf("Failed to create local data dir: %v", err)
+		}
+	}
+
+	var inputs blobs.Store
+	if *inputsDir != "" {
+		store, err := blobs.NewLocalStore(*inputsDir)
+		if err != nil {
+			log.Fatalf("Failed to create local inputs store: %v", err)
+		}
+		inputs = store
+	} else {
+		store, err := blobs.FromEnv(context.Background())
 		if err != nil {
-			log.Fatalf("Failed to create pubsub client: %v", err)
+			log.Fatalf("Failed to create inputs store: %v", err)
 		}
-		pubsubClient = cl
+		inputs = store
+	}
+	var taskStore tasks.Store
+	if *tasksDB != "" {
+		store, err := tasks.OpenBoltStore(*tasksDB)
+		if err != nil {
+			log.Fatalf("Failed to open local task store: %v", err)
+		}
+		taskStore = store
+	} else {
+		store, err := tasks.FromEnv(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to create task store: %v", err)
+		}
+		taskStore = store
 	}
 
+	specRegistry := specs.NewDefaultRegistry()
 	clients := []string{
 		"zrnt",
 	}
+
+	// Setup event bus
+	if *local {
+		localBus := events.NewLocalBus()
+		for _, spec := range specRegistry.List() {
+			topic := events.TransitionTopic(spec.Version, spec.Config)
+			localBus.CreateTopic(topic)
+			// log new tasks, there are no workers to pick them up.
+			sub := events.WorkerSubscription(spec.Version, spec.Config, "local", "log")
+			if err := localBus.CreateSubscription(sub, topic); err != nil {
+				log.Fatalf("Failed to create local subscription: %v", err)
+			}
+		}
+		for _, c := range clients {
+			// like the results cloud function, consume from the results topic of the client.
+			topic := events.ResultsTopic(c)
+			localBus.CreateTopic(topic)
+			if err := localBus.CreateSubscription(topic, topic); err != nil {
+				log.Fatalf("Failed to create local subscription: %v", err)
+			}
+		}
+		// verify results in-process, instead of the verify cloud function.
+		localBus.CreateTopic(events.VerifyTopic)
+		if err := localBus.CreateSubscription(events.VerifyTopic, events.VerifyTopic); err != nil {
+			log.Fatalf("Failed to create local subscription: %v", err)
+		}
+		bus = localBus
+	} else {
+		b, err := events.FromEnv(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to create event bus: %v", err)
+		}
+		bus = b
+	}
+
+	resultsHandler := results.NewHandler(taskStore)
 	// this is not an authenticated cloud func, but a dev environment. Just accept any client we are listening for.
-	results.CheckClient = func(name string) bool {
+	resultsHandler.CheckClient = func(name string) bool {
 		for _, c := range clients {
 			if c == name {
 				return true
@@ -39,21 +140,124 @@ func main() {
 		}
 		return false
 	}
+	if policy, err := tasks.ParseDuplicatePolicy(*duplicateResults); err != nil {
+		log.Fatalf("Invalid duplicate results policy: %v", err)
+	} else {
+		resultsHandler.Duplicates = policy
+	}
+	if *clientKeys != "" {
+		keys, err := clientkeys.LoadFile(*clientKeys)
+		if err != nil {
+			log.Fatalf("Failed to load client keys: %v", err)
+		}
+		resultsHandler.Keys = keys
+		// like the results cloud function, accept the signed results of all clients with keys.
+		resultsHandler.CheckClient = keys.HasClient
+	}
+	if *clientTokens != "" {
+		tokens, err := results.LoadTokensFile(*clientTokens)
+		if err != nil {
+			log.Fatalf("Failed to load client tokens: %v", err)
+		}
+		resultsHandler.CheckToken = tokens.Check
+	} else if *local {
+		// like the clients, any token is accepted in a dev environment.
+		resultsHandler.CheckToken = func(client string, token string) bool {
+			return true
+		}
+	}
+	verifyMode, err := results.ParseVerifyMode(*verifyResults)
+	if err != nil {
+		log.Fatalf("Invalid verify results mode: %v", err)
+	}
+	resultsHandler.Verify = verifyMode
+	resultsHandler.Events = bus
+	// the stores of result files, by URL prefix, to verify results with and to upload result files to.
+	var outputs blobs.Sources
+	if *local {
+		// local workers can put their result files in the outputs dir, and link them in their results.
+		outputsDir := filepath.Join(*localDir, "outputs")
+		if err := os.MkdirAll(outputsDir, 0755); err != nil {
+			log.Fatalf("Failed to create local outputs dir: %v", err)
+		}
+		store, err := blobs.NewLocalStore(outputsDir)
+		if err != nil {
+			log.Fatalf("Failed to create local outputs store: %v", err)
+		}
+		outputs = append(outputs, blobs.Source{URLPrefix: "http://localhost:8080/outputs/", Store: store})
+	} else if bucketName := os.Getenv("RESULTS_BUCKET"); bucketName != "" {
+		store, err := blobs.NewGCSStore(context.Background(), bucketName)
+		if err != nil {
+			log.Fatalf("Failed to create results store: %v", err)
+		}
+		outputs = append(outputs, blobs.Source{URLPrefix: "https://storage.googleapis.com/" + bucketName + "/", Store: store})
+	}
+	verifier := results.NewVerifier(taskStore, outputs)
+	verifier.Specs = specRegistry
+	resultsHandler.Verifier = verifier
 
-	// for local dev, when pubsub results changes need to be tested locally.
-	//for _, c := range clients {
-	//	startPubsubListener(fmt.Sprintf("results~%s", c), results.Results)
-	//}
+	// tasks are chained from the post-states in the stores of result files, the same as results are verified.
+	uploadHandler := upload.NewHandler(inputs, taskStore, bus, outputs)
+	uploadHandler.Specs = specRegistry
+	uploadHandler.LegacyInputs = upload.LegacyInputsFromEnv()
+
+	// import spec tests, instead of serving
+	if flag.Arg(0) == "import" {
+		importTests(uploadHandler, flag.Args()[1:])
+		return
+	}
+
+	if *local {
+		for _, spec := range specRegistry.List() {
+			go startListener(events.WorkerSubscription(spec.Version, spec.Config, "local", "log"), logTransition)
+		}
+		// process results in-process, instead of the results cloud function.
+		for _, c := range clients {
+			go startListener(events.ResultsTopic(c), resultsHandler.HandleResult)
+		}
+		go startListener(events.VerifyTopic, verifier.HandleVerify)
+	}
 
 	fs := http.FileServer(http.Dir("static"))
 
 	r := mux.NewRouter()
 	r.Use(loggingMiddleware)
 	r.Use(corsMiddleware)
-	r.HandleFunc("/upload", upload.Upload)
-	r.HandleFunc("/listing", listing.Listing)
-	r.HandleFunc("/task", get_task.GetTask)
-	r.HandleFunc("/task/{key}", get_task.GetTask)
+	taskHandler := get_task.NewHandler(taskStore)
+	r.Handle("/upload", uploadHandler)
+	r.Handle("/specs", specs.NewHandler(specRegistry))
+	importHandler := importer.NewHandler(uploadHandler)
+	importHandler.CheckToken = auth.TokenChecker(os.Getenv("MUSKOKA_ADMIN_TOKEN"))
+	r.Handle("/import", importHandler)
+	r.Handle("/listing", listing.NewHandler(taskStore))
+	r.Handle("/results", resultsHandler).Methods("POST")
+	r.Handle("/task", taskHandler)
+	r.Handle("/task/{key}", taskHandler)
+	diffHandler := get_task.NewDiffHandler(taskStore, outputs)
+	diffHandler.Specs = specRegistry
+	r.Handle("/task/{key}/diff", diffHandler)
+	inputHandler := get_task.NewInputHandler(taskStore, inputs)
+	inputHandler.Specs = specRegistry
+	r.Handle("/task/{key}/pre", inputHandler)
+	r.Handle("/task/{key}/block/{block}", inputHandler)
+	expectedHandler := admin.NewExpectedHandler(taskStore)
+	expectedHandler.CheckToken = auth.TokenChecker(os.Getenv("MUSKOKA_ADMIN_TOKEN"))
+	r.Handle("/task/{key}/expected-post-root", expectedHandler).Methods("POST")
+	if *inputsDir != "" {
+		r.PathPrefix("/inputs/").Handler(http.StripPrefix("/inputs/", http.FileServer(http.Dir(*inputsDir))))
+	}
+	if *local {
+		// local workers can put their result files here, and link them in their results.
+		r.PathPrefix("/outputs/").Handler(http.StripPrefix("/outputs/", http.FileServer(http.Dir(filepath.Join(*localDir, "outputs")))))
+		// local workers (or curl) can publish results here, instead of to a pubsub topic.
+		r.HandleFunc("/publish/{topic}", publishLocal).Methods("POST")
+	}
+	if len(outputs) > 0 {
+		// local workers (or curl) can upload their result files, these are stored with the other result files.
+		filesHandler := results.NewFilesHandler(resultsHandler, outputs[0].Store, outputs[0].URLPrefix)
+		filesHandler.Specs = specRegistry
+		r.Handle("/results/upload", filesHandler).Methods("POST")
+	}
 	r.Handle("/", fs)
 	// Add routes as needed
 
@@ -84,6 +288,43 @@ func main() {
 	os.Exit(0)
 }
 
+// importTests creates tasks from the spec test cases in a directory.
+// Usage: import --spec-version=<version> --spec-config=<config> <dir>
+func importTests(uploads *upload.Handler, args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	specVersion := fs.String("spec-version", "", "spec version of the test cases")
+	specConfig := fs.String("spec-config", "", "spec config of the test cases")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("expected a single directory with test cases to import")
+	}
+	im := &importer.Importer{Uploads: uploads, SpecVersion: *specVersion, SpecConfig: *specConfig}
+	imported, unjudged, failed := 0, 0, 0
+	err := importer.ReadDir(fs.Arg(0), func(c *importer.Case, err error) error {
+		var task *model.Task
+		if err == nil {
+			task, err = im.Import(c)
+		}
+		if err != nil {
+			log.Printf("could not import %s: %v", c.Name, err)
+			failed++
+			return nil
+		}
+		imported++
+		if c.Post == nil {
+			log.Printf("imported %s as task %s, without post-state: results are not judged", c.Name, task.Key)
+			unjudged++
+			return nil
+		}
+		log.Printf("imported %s as task %s", c.Name, task.Key)
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("import failed: %v", err)
+	}
+	log.Printf("imported %d test cases (%d without post-state), %d failed", imported, unjudged, failed)
+}
+
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -101,33 +342,43 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func startPubsubListener(subId string, pubsubHandler func(ctx context.Context, m *pubsub.Message) error) {
-	sub := pubsubClient.Subscription(subId)
+func logTransition(ctx context.Context, m *events.Message) error {
+	log.Printf("new transition task: %s", strings.TrimSpace(string(m.Data)))
+	return nil
+}
+
+func publishLocal(w http.ResponseWriter, r *http.Request) {
+	topic := mux.Vars(r)["topic"]
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "could not read message", http.StatusBadRequest)
+		return
+	}
+	id, err := bus.Publish(r.Context(), topic, data)
+	if err == events.ErrTopicNotFound {
+		http.Error(w, "unknown topic", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = fmt.Fprintln(w, id)
+}
+
+func startListener(subId string, handler events.Handler) {
 	// check if the subscription exists
 	{
-		ctx, _ := context.WithTimeout(context.Background(), time.Second*15)
-		if exists, err := sub.Exists(ctx); err != nil {
-			log.Fatalf("could not check if pubsub subscription exists: %v\n", err)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+		defer cancel()
+		if exists, err := bus.SubscriptionExists(ctx, subId); err != nil {
+			log.Fatalf("could not check if subscription exists: %v\n", err)
 		} else if !exists {
 			log.Fatalf("subscription %s does not exist. Either the worker was misconfigured (try --sub-id) or a new subscription needs to be created and permissioned.", subId)
 		}
 	}
-	// configure pubsub receiver
-	sub.ReceiveSettings = pubsub.ReceiveSettings{
-		MaxExtension:           -1,
-		MaxOutstandingMessages: 20,
-		MaxOutstandingBytes:    1 << 10,
-		NumGoroutines:          4,
-		Synchronous:            true,
-	}
 	// try receiving messages
-	{
-		if err := sub.Receive(context.Background(), func(ctx context.Context, message *pubsub.Message) {
-			if err := pubsubHandler(ctx, message); err != nil {
-				log.Printf("failed pubsub function call: %v", err)
-			}
-		}); err != nil {
-			log.Fatalf("could not receive pubsub messages: %v", err)
-		}
+	if err := bus.Receive(context.Background(), subId, handler); err != nil {
+		log.Fatalf("could not receive messages: %v", err)
 	}
 }
@@ -0,0 +1,82 @@
+package model
+
+import "sort"
+
+// Consensus summarizes the post-states of the successful results of a task, to find clients that disagree.
+type Consensus struct {
+	// post-hash -> the clients that produced it, sorted by name, then version
+	PostHashes map[string][]ClientVersion `firestore:"post-hashes" json:"post-hashes"`
+	// the post-hash produced by the most clients. Ties are broken by the most client versions, then the lowest post-hash.
+	// Empty if there are no successful results.
+	Majority string `firestore:"majority" json:"majority"`
+	// the number of distinct post-hashes
+	Distinct int `firestore:"distinct" json:"distinct"`
+	// true if there is more than one distinct post-hash
+	HasDisagreement bool `firestore:"has-disagreement" json:"has-disagreement"`
+}
+
+type ClientVersion struct {
+	ClientName    string `firestore:"client-name" json:"client-name"`
+	ClientVersion string `firestore:"client-version" json:"client-version"`
+}
+
+// UpdateConsensus computes the consensus summary from the results of the task.
+// Results that were not a success are not part of the consensus, their post-state is not meaningful.
+func (t *Task) UpdateConsensus() {
+	producers := make(map[string]map[ClientVersion]struct{})
+	for _, result := range t.Results {
+		if !result.Success || result.PostHash == "" {
+			continue
+		}
+		p, ok := producers[result.PostHash]
+		if !ok {
+			p = make(map[ClientVersion]struct{})
+			producers[result.PostHash] = p
+		}
+		p[ClientVersion{ClientName: result.ClientName, ClientVersion: result.ClientVersion}] = struct{}{}
+	}
+	c := Consensus{
+		PostHashes: make(map[string][]ClientVersion, len(producers)),
+		Distinct:   len(producers),
+	}
+	// the number of distinct client names and versions of the majority post-hash
+	majorityClients, majorityVersions := 0, 0
+	for postHash, p := range producers {
+		list := sortedClientVersions(p)
+		names := make(map[string]struct{})
+		for _, cv := range list {
+			names[cv.ClientName] = struct{}{}
+		}
+		c.PostHashes[postHash] = list
+		if len(names) != majorityClients {
+			if len(names) < majorityClients {
+				continue
+			}
+		} else if len(list) != majorityVersions {
+			if len(list) < majorityVersions {
+				continue
+			}
+		} else if postHash > c.Majority {
+			continue
+		}
+		c.Majority = postHash
+		majorityClients, majorityVersions = len(names), len(list)
+	}
+	c.HasDisagreement = c.Distinct > 1
+	t.Consensus = c
+}
+
+// sortedClientVersions lists the set of clients, sorted by name, then version.
+func sortedClientVersions(set map[ClientVersion]struct{}) []ClientVersion {
+	list := make([]ClientVersion, 0, len(set))
+	for cv := range set {
+		list = append(list, cv)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ClientName != list[j].ClientName {
+			return list[i].ClientName < list[j].ClientName
+		}
+		return list[i].ClientVersion < list[j].ClientVersion
+	})
+	return list
+}
@@ -0,0 +1,137 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func okResult(client string, version string, postHash string) ResultEntry {
+	return ResultEntry{Success: true, Outcome: OutcomeOK, ClientName: client, ClientVersion: version, PostHash: postHash}
+}
+
+func TestUpdateConsensus(t *testing.T) {
+	cases := []struct {
+		name    string
+		results map[string]ResultEntry
+		want    Consensus
+	}{
+		{
+			name:    "no results",
+			results: nil,
+			want:    Consensus{PostHashes: map[string][]ClientVersion{}},
+		},
+		{
+			name: "only failures",
+			results: map[string]ResultEntry{
+				"a": {Outcome: OutcomeCrash, ClientName: "zrnt", ClientVersion: "v1", PostHash: "0x01"},
+			},
+			want: Consensus{PostHashes: map[string][]ClientVersion{}},
+		},
+		{
+			name: "agreement",
+			results: map[string]ResultEntry{
+				"a": okResult("zrnt", "v1", "0x01"),
+				"b": okResult("lighthouse", "v1", "0x01"),
+			},
+			want: Consensus{
+				PostHashes: map[string][]ClientVersion{
+					"0x01": {{"lighthouse", "v1"}, {"zrnt", "v1"}},
+				},
+				Majority: "0x01",
+				Distinct: 1,
+			},
+		},
+		{
+			name: "repeated results of a client version count once",
+			results: map[string]ResultEntry{
+				"a":   okResult("zrnt", "v1", "0x01"),
+				"a-1": okResult("zrnt", "v1", "0x01"),
+			},
+			want: Consensus{
+				PostHashes: map[string][]ClientVersion{
+					"0x01": {{"zrnt", "v1"}},
+				},
+				Majority: "0x01",
+				Distinct: 1,
+			},
+		},
+		{
+			name: "most clients wins",
+			results: map[string]ResultEntry{
+				"a": okResult("zrnt", "v1", "0x02"),
+				"b": okResult("lighthouse", "v1", "0x02"),
+				"c": okResult("prysm", "v1", "0x01"),
+				"d": okResult("prysm", "v2", "0x01"),
+				"e": okResult("prysm", "v3", "0x01"),
+			},
+			want: Consensus{
+				PostHashes: map[string][]ClientVersion{
+					"0x01": {{"prysm", "v1"}, {"prysm", "v2"}, {"prysm", "v3"}},
+					"0x02": {{"lighthouse", "v1"}, {"zrnt", "v1"}},
+				},
+				Majority:        "0x02",
+				Distinct:        2,
+				HasDisagreement: true,
+			},
+		},
+		{
+			name: "tie on clients, most versions wins",
+			results: map[string]ResultEntry{
+				"a": okResult("zrnt", "v1", "0x01"),
+				"b": okResult("lighthouse", "v1", "0x02"),
+				"c": okResult("lighthouse", "v2", "0x02"),
+			},
+			want: Consensus{
+				PostHashes: map[string][]ClientVersion{
+					"0x01": {{"zrnt", "v1"}},
+					"0x02": {{"lighthouse", "v1"}, {"lighthouse", "v2"}},
+				},
+				Majority:        "0x02",
+				Distinct:        2,
+				HasDisagreement: true,
+			},
+		},
+		{
+			name: "full tie, lowest post-hash wins",
+			results: map[string]ResultEntry{
+				"a": okResult("zrnt", "v1", "0x03"),
+				"b": okResult("lighthouse", "v1", "0x01"),
+				"c": okResult("prysm", "v1", "0x02"),
+			},
+			want: Consensus{
+				PostHashes: map[string][]ClientVersion{
+					"0x01": {{"lighthouse", "v1"}},
+					"0x02": {{"prysm", "v1"}},
+					"0x03": {{"zrnt", "v1"}},
+				},
+				Majority:        "0x01",
+				Distinct:        3,
+				HasDisagreement: true,
+			},
+		},
+		{
+			name: "failures and missing post-hashes are ignored",
+			results: map[string]ResultEntry{
+				"a": okResult("zrnt", "v1", "0x02"),
+				"b": {Outcome: OutcomeInvalidTransition, ClientName: "lighthouse", ClientVersion: "v1", PostHash: "0x01"},
+				"c": okResult("prysm", "v1", ""),
+			},
+			want: Consensus{
+				PostHashes: map[string][]ClientVersion{
+					"0x02": {{"zrnt", "v1"}},
+				},
+				Majority: "0x02",
+				Distinct: 1,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			task := &Task{Results: c.results}
+			task.UpdateConsensus()
+			if !reflect.DeepEqual(task.Consensus, c.want) {
+				t.Errorf("got consensus %+v, expected %+v", task.Consensus, c.want)
+			}
+		})
+	}
+}
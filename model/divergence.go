@@ -0,0 +1,81 @@
+package model
+
+// Divergence locates where the successful results of a task first disagree, by the intermediate state roots they reported.
+type Divergence struct {
+	// index of the first block after which the results report different state roots. -1 if they agree on every block.
+	Block int `json:"block"`
+	// state root -> the clients that reported it after the first divergent block. Empty if the results agree.
+	BlockRoots map[string][]ClientVersion `json:"block-roots"`
+	// index of the first slot state root the results report differently. -1 if they agree on every slot.
+	SlotIndex int `json:"slot-index"`
+	// the slot of SlotIndex, -1 if the results agree, or if the slot of the pre-state is unknown.
+	Slot int64 `json:"slot"`
+	// state root -> the clients that reported it after the first divergent slot. Empty if the results agree.
+	SlotRoots map[string][]ClientVersion `json:"slot-roots"`
+}
+
+// Divergence compares the per-block and per-slot state roots of the successful results of the task.
+// Results that did not report the roots are not compared.
+// Returns nil if no successful result reported block or slot state roots.
+func (t *Task) Divergence() *Divergence {
+	var blockRoots, slotRoots [][]string
+	var blockClients, slotClients []ClientVersion
+	for _, result := range t.Results {
+		if !result.Success {
+			continue
+		}
+		cv := ClientVersion{ClientName: result.ClientName, ClientVersion: result.ClientVersion}
+		if len(result.BlockStateRoots) > 0 {
+			blockRoots = append(blockRoots, result.BlockStateRoots)
+			blockClients = append(blockClients, cv)
+		}
+		if len(result.SlotStateRoots) > 0 {
+			slotRoots = append(slotRoots, result.SlotStateRoots)
+			slotClients = append(slotClients, cv)
+		}
+	}
+	if len(blockRoots) == 0 && len(slotRoots) == 0 {
+		return nil
+	}
+	d := &Divergence{Slot: -1}
+	d.Block, d.BlockRoots = firstDivergence(blockRoots, blockClients)
+	d.SlotIndex, d.SlotRoots = firstDivergence(slotRoots, slotClients)
+	// tasks uploaded before the inputs were summarized do not know the slot of the pre-state
+	if d.SlotIndex >= 0 && t.PreRoot != "" {
+		d.Slot = t.PreSlot + 1 + int64(d.SlotIndex)
+	}
+	return d
+}
+
+// firstDivergence finds the first index at which the lists of roots differ, and who reported which root at that index.
+// Lists that are shorter than the index are not compared. Returns -1 and an empty map if the lists agree.
+func firstDivergence(lists [][]string, clients []ClientVersion) (int, map[string][]ClientVersion) {
+	maxLen := 0
+	for _, l := range lists {
+		if len(l) > maxLen {
+			maxLen = len(l)
+		}
+	}
+	for i := 0; i < maxLen; i++ {
+		reporters := make(map[string]map[ClientVersion]struct{})
+		for j, l := range lists {
+			if i >= len(l) {
+				continue
+			}
+			r, ok := reporters[l[i]]
+			if !ok {
+				r = make(map[ClientVersion]struct{})
+				reporters[l[i]] = r
+			}
+			r[clients[j]] = struct{}{}
+		}
+		if len(reporters) > 1 {
+			out := make(map[string][]ClientVersion, len(reporters))
+			for root, r := range reporters {
+				out[root] = sortedClientVersions(r)
+			}
+			return i, out
+		}
+	}
+	return -1, map[string][]ClientVersion{}
+}
@@ -0,0 +1,109 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func rootsResult(client string, success bool, blockRoots []string, slotRoots []string) ResultEntry {
+	return ResultEntry{Success: success, ClientName: client, ClientVersion: "v1", BlockStateRoots: blockRoots, SlotStateRoots: slotRoots}
+}
+
+func TestDivergence(t *testing.T) {
+	cases := []struct {
+		name    string
+		preRoot string
+		preSlot int64
+		results map[string]ResultEntry
+		want    *Divergence
+	}{
+		{
+			name: "no roots reported",
+			results: map[string]ResultEntry{
+				"a": rootsResult("zrnt", true, nil, nil),
+			},
+			want: nil,
+		},
+		{
+			name: "agreement",
+			results: map[string]ResultEntry{
+				"a": rootsResult("zrnt", true, []string{"0x01", "0x02"}, []string{"0x0a"}),
+				"b": rootsResult("prysm", true, []string{"0x01", "0x02"}, []string{"0x0a"}),
+			},
+			want: &Divergence{
+				Block: -1, BlockRoots: map[string][]ClientVersion{},
+				SlotIndex: -1, Slot: -1, SlotRoots: map[string][]ClientVersion{},
+			},
+		},
+		{
+			name:    "diverge on second block and first slot",
+			preRoot: "0xff",
+			preSlot: 10,
+			results: map[string]ResultEntry{
+				"a": rootsResult("zrnt", true, []string{"0x01", "0x02"}, []string{"0x0a", "0x0b"}),
+				"b": rootsResult("prysm", true, []string{"0x01", "0x03"}, []string{"0x0c", "0x0b"}),
+			},
+			want: &Divergence{
+				Block: 1,
+				BlockRoots: map[string][]ClientVersion{
+					"0x02": {{"zrnt", "v1"}},
+					"0x03": {{"prysm", "v1"}},
+				},
+				SlotIndex: 0,
+				Slot:      11,
+				SlotRoots: map[string][]ClientVersion{
+					"0x0a": {{"zrnt", "v1"}},
+					"0x0c": {{"prysm", "v1"}},
+				},
+			},
+		},
+		{
+			name:    "unknown pre-state slot",
+			preSlot: 10,
+			results: map[string]ResultEntry{
+				"a": rootsResult("zrnt", true, nil, []string{"0x0a", "0x0b"}),
+				"b": rootsResult("prysm", true, nil, []string{"0x0a", "0x0c"}),
+			},
+			want: &Divergence{
+				Block: -1, BlockRoots: map[string][]ClientVersion{},
+				SlotIndex: 1,
+				Slot:      -1,
+				SlotRoots: map[string][]ClientVersion{
+					"0x0b": {{"zrnt", "v1"}},
+					"0x0c": {{"prysm", "v1"}},
+				},
+			},
+		},
+		{
+			name: "shorter lists are not compared past their end",
+			results: map[string]ResultEntry{
+				"a": rootsResult("zrnt", true, []string{"0x01"}, nil),
+				"b": rootsResult("prysm", true, []string{"0x01", "0x02"}, nil),
+			},
+			want: &Divergence{
+				Block: -1, BlockRoots: map[string][]ClientVersion{},
+				SlotIndex: -1, Slot: -1, SlotRoots: map[string][]ClientVersion{},
+			},
+		},
+		{
+			name: "results that were not a success are ignored",
+			results: map[string]ResultEntry{
+				"a": rootsResult("zrnt", true, []string{"0x01"}, nil),
+				"b": rootsResult("prysm", false, []string{"0x02"}, nil),
+			},
+			want: &Divergence{
+				Block: -1, BlockRoots: map[string][]ClientVersion{},
+				SlotIndex: -1, Slot: -1, SlotRoots: map[string][]ClientVersion{},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			task := &Task{PreRoot: c.preRoot, PreSlot: c.preSlot, Results: c.results}
+			got := task.Divergence()
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got divergence %+v, expected %+v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/protolambda/muskoka-server/model
+
+go 1.11
@@ -0,0 +1,12 @@
+package model
+
+import "regexp"
+
+// KeyRegex matches task keys. Keys are firestore document IDs: make sure keys don't start with `__`, or underscores at all.
+var KeyRegex, _ = regexp.Compile("^[-0-9a-zA-Z=][-_0-9a-zA-Z=]{0,128}$")
+
+// ResultKeyRegex matches the keys of the results of a task. Result keys are unpadded base64url, and may start with an underscore.
+var ResultKeyRegex, _ = regexp.Compile("^[-_0-9a-zA-Z]{1,128}$")
+
+// RootRegex matches hash tree roots and post-hashes: hex encoded bytes32, with 0x prefix.
+var RootRegex, _ = regexp.Compile("^0x[0-9a-f]{64}$")
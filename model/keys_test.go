@@ -0,0 +1,38 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestKeyRegexes(t *testing.T) {
+	cases := []struct {
+		name  string
+		regex *regexp.Regexp
+		value string
+		want  bool
+	}{
+		{"task key", KeyRegex, "aB3xY9zQ0pL7mN2kR5tW", true},
+		{"task key with underscore", KeyRegex, "a_b", true},
+		{"task key starting with underscore", KeyRegex, "_ab", false},
+		{"empty task key", KeyRegex, "", false},
+		{"task key with slash", KeyRegex, "a/b", false},
+		{"result key", ResultKeyRegex, "Zoa_0QqKUXuLswzTovjtw2-7DFBv8ytpXicjmR-CkL0", true},
+		{"result key starting with underscore", ResultKeyRegex, "_oa0QqKUXuLswzTovjtw2", true},
+		{"result key attempt", ResultKeyRegex, "Zoa0QqKUXuLswzTovjtw2-1", true},
+		{"empty result key", ResultKeyRegex, "", false},
+		{"result key too long", ResultKeyRegex, strings.Repeat("a", 129), false},
+		{"result key with dot", ResultKeyRegex, "a.b", false},
+		{"root", RootRegex, "0x" + strings.Repeat("ab", 32), true},
+		{"root with upper case", RootRegex, "0x" + strings.Repeat("AB", 32), false},
+		{"short root", RootRegex, "0x" + strings.Repeat("ab", 31), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.regex.MatchString(c.value); got != c.want {
+				t.Errorf("match %q: got %v, expected %v", c.value, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package model
+
+import "time"
+
+type TransitionMsg struct {
+	Blocks      int    `json:"blocks"`
+	SpecVersion string `json:"spec-version"`
+	SpecConfig  string `json:"spec-config"`
+	Key         string `json:"key"`
+	// storage keys of the inputs of the task
+	Inputs TaskInputs `json:"inputs"`
+}
+
+// TransitionMsgOf creates the event announcing the given task to workers.
+func TransitionMsgOf(task *Task) *TransitionMsg {
+	return &TransitionMsg{
+		Blocks:      task.Blocks,
+		SpecVersion: task.SpecVersion,
+		SpecConfig:  task.SpecConfig,
+		Key:         task.Key,
+		Inputs:      task.Inputs,
+	}
+}
+
+type ResultMsg struct {
+	// if the transition was successful (i.e. no err log). Implied by outcome "ok".
+	Success bool `json:"success"`
+	// optional, how the transition ended, see Outcome. Without an outcome, a successful result has outcome "ok".
+	// A successful result must not have another outcome.
+	Outcome Outcome `json:"outcome"`
+	// optional, for results that were not a success: a short identifier of the error, chosen by the client,
+	// and a human readable description of it.
+	ErrorCode    string `json:"error-code"`
+	ErrorMessage string `json:"error-message"`
+	// the flat-hash of the post-state SSZ bytes, for quickly finding different results.
+	PostHash string `json:"post-hash"`
+	// optional, the hash tree root of the post-state. Used to judge the result against the expected post-state of the task.
+	StateRoot string `json:"state-root"`
+	// optional, the hash tree root of the state after each block, in block order. The last root is the post-state root.
+	BlockStateRoots []string `json:"block-state-roots"`
+	// optional, the hash tree root of the state after processing each slot, before the block of the slot is applied:
+	// from the slot after the pre-state, up to and including the slot of the last block.
+	SlotStateRoots []string `json:"slot-state-roots"`
+	// the name of the client; 'zrnt', 'lighthouse', etc.
+	ClientName string `json:"client-name"`
+	// the version number of the client, may contain a git commit hash
+	ClientVersion string `json:"client-version"`
+	// identifies the transition task
+	Key string `json:"key"`
+	// optional, the worker that produced the result, e.g. the worker ID of its subscription
+	WorkerID string `json:"worker-id"`
+	// optional, chosen by the worker to identify the result message. Repeated messages with the same ID are recognized,
+	// see results.ResultKey.
+	MessageID string `json:"message-id"`
+	// Result files
+	Files ResultFilesData `json:"files"`
+	// the key of the client that signed the message, and the base64 encoded ed25519 signature
+	// of the canonical form of the message, see clientkeys.Canonical. Required if the results consumer checks signatures.
+	KeyID     string `json:"key-id"`
+	Signature string `json:"signature"`
+}
+
+type ResultFilesData struct {
+	// urls to the files
+	PostState string `json:"post-state"`
+	ErrLog    string `json:"err-log"`
+	OutLog    string `json:"out-log"`
+}
+
+// Succeeded is true if the result is a success, with or without outcome "ok".
+func (m *ResultMsg) Succeeded() bool {
+	return m.Success || m.Outcome == OutcomeOK
+}
+
+// Entry converts the result message into the entry to store in the task.
+func (m *ResultMsg) Entry(created time.Time) *ResultEntry {
+	outcome := m.Outcome
+	if outcome == "" && m.Success {
+		outcome = OutcomeOK
+	}
+	return &ResultEntry{
+		Success:         m.Succeeded(),
+		Outcome:         outcome,
+		ErrorCode:       m.ErrorCode,
+		ErrorMessage:    m.ErrorMessage,
+		Created:         created,
+		ClientName:      m.ClientName,
+		ClientVersion:   m.ClientVersion,
+		WorkerID:        m.WorkerID,
+		MessageID:       m.MessageID,
+		PostHash:        m.PostHash,
+		StateRoot:       m.StateRoot,
+		BlockStateRoots: m.BlockStateRoots,
+		SlotStateRoots:  m.SlotStateRoots,
+		Files: ResultFilesRef{
+			PostState: m.Files.PostState,
+			ErrLog:    m.Files.ErrLog,
+			OutLog:    m.Files.OutLog,
+		},
+	}
+}
+
+// VerifyMsg is the message to verify a stored result in the background.
+type VerifyMsg struct {
+	// the key of the task
+	Key string `json:"key"`
+	// the key of the result in the task
+	ResultKey string `json:"result-key"`
+}
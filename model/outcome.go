@@ -0,0 +1,37 @@
+package model
+
+// Outcome is how the transition of a task ended for a client, more specific than the success of a result.
+type Outcome string
+
+const (
+	// the transition was processed, the post-state is the outcome
+	OutcomeOK Outcome = "ok"
+	// the client rejected the transition as invalid, e.g. a block with an invalid signature
+	OutcomeInvalidTransition Outcome = "invalid-transition"
+	// the client crashed while processing the transition
+	OutcomeCrash Outcome = "crash"
+	// the client did not finish processing the transition in time
+	OutcomeTimeout Outcome = "timeout"
+	// the client does not support the task, e.g. the spec version or config of the task
+	OutcomeUnsupported Outcome = "unsupported"
+	// the worker could not run the client, e.g. the inputs could not be fetched
+	OutcomeInfraError Outcome = "infra-error"
+)
+
+// Valid is true if the outcome is one of the known outcomes.
+func (o Outcome) Valid() bool {
+	switch o {
+	case OutcomeOK, OutcomeInvalidTransition, OutcomeCrash, OutcomeTimeout, OutcomeUnsupported, OutcomeInfraError:
+		return true
+	default:
+		return false
+	}
+}
+
+// Meaningful is true if the outcome says something about how the client handles the transition.
+// Unsupported tasks and infrastructure errors do not.
+// The empty outcome, of results that were not a success and did not report an outcome, is meaningful,
+// like every result that was not a success was before outcomes were reported.
+func (o Outcome) Meaningful() bool {
+	return o != OutcomeUnsupported && o != OutcomeInfraError
+}
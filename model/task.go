@@ -0,0 +1,261 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// SchemaVersion is the version of the task document schema written by this server.
+// Increment it when changing the meaning of existing fields, and handle the older versions in Task.Migrate.
+const SchemaVersion = 4
+
+type TaskIndexDoc struct {
+	NextIndex int `firestore:"next-index"`
+}
+
+type Task struct {
+	SchemaVersion int       `firestore:"schema-version" json:"schema-version"`
+	Index         int       `firestore:"index" json:"index"`
+	Blocks        int       `firestore:"blocks" json:"blocks"`
+	SpecVersion   string    `firestore:"spec-version" json:"spec-version"`
+	SpecConfig    string    `firestore:"spec-config" json:"spec-config"`
+	Created       time.Time `firestore:"created" json:"created"`
+	// hash tree roots (0x-prefixed hex) and slots of the inputs, computed at upload time.
+	// Empty for tasks that were uploaded before the inputs were summarized.
+	PreRoot    string   `firestore:"pre-root" json:"pre-root"`
+	PreSlot    int64    `firestore:"pre-slot" json:"pre-slot"`
+	BlockRoots []string `firestore:"block-roots" json:"block-roots"`
+	BlockSlots []int64  `firestore:"block-slots" json:"block-slots"`
+	// hash tree root of the expected post-state, if known. E.g. for tasks imported from spec tests.
+	ExpectedPostRoot string `firestore:"expected-post-root" json:"expected-post-root"`
+	// storage keys of the inputs, resolved for this task. Inputs may be shared with other tasks.
+	Inputs TaskInputs `firestore:"inputs" json:"inputs"`
+	// the key of the task this task was forked from, empty if it was not forked.
+	// The pre-state and the first ParentBlocks blocks are the same as those of the parent task.
+	Parent       string `firestore:"parent" json:"parent"`
+	ParentBlocks int    `firestore:"parent-blocks" json:"parent-blocks"`
+	// the key of the result of the parent task this task was chained from, empty if it was not chained.
+	// The pre-state is the post-state of that result.
+	ParentResult string                 `firestore:"parent-result" json:"parent-result"`
+	Results      map[string]ResultEntry `firestore:"results" json:"results"`
+	// summary of the post-states of the results, updated with every result. See UpdateConsensus.
+	Consensus Consensus `firestore:"consensus" json:"consensus"`
+	// helper fields for querying, not part of the API output.
+	WorkersVersioned map[string]string `firestore:"workers-versioned" json:"-"`
+	Workers          map[string]bool   `firestore:"workers" json:"-"`
+	// client name -> outcome -> true, for the outcomes of the results of each client.
+	WorkerOutcomes map[string]map[string]bool `firestore:"worker-outcomes" json:"-"`
+	// true if a result failed with a meaningful outcome, see ResultEntry.Failed.
+	HasFail bool `firestore:"has-fail" json:"-"`
+	// true if a result did not match the expected post-state, see Judge.
+	MismatchExpected bool `firestore:"mismatch-expected" json:"-"`
+	// ignored by firestore. But used to uniquely identify the task, and fetch its contents from storage.
+	Key string `firestore:"-" json:"key"`
+}
+
+// NewTask creates a task document of the current schema version.
+// Results and workers are not set, only added later when workers make results available.
+func NewTask(specVersion string, specConfig string, blocks int, created time.Time) *Task {
+	return &Task{
+		SchemaVersion: SchemaVersion,
+		Blocks:        blocks,
+		SpecVersion:   specVersion,
+		SpecConfig:    specConfig,
+		Created:       created,
+	}
+}
+
+// Migrate upgrades a task document, as read from storage, to the current schema version.
+func (t *Task) Migrate() error {
+	if t.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("task %s has schema version %d, newer than supported version %d",
+			t.Key, t.SchemaVersion, SchemaVersion)
+	}
+	// version 0: documents from before the schema was versioned, the fields are the same as in version 1.
+	if t.SchemaVersion == 0 {
+		t.SchemaVersion = 1
+	}
+	// version 1: inputs were stored per task, the storage keys were derived from the task key.
+	if t.SchemaVersion == 1 {
+		t.Inputs = LegacyTaskInputs(t.SpecVersion, t.SpecConfig, t.Key, t.Blocks)
+		t.SchemaVersion = 2
+	}
+	// version 2: there was no consensus summary, it is computed from the results.
+	if t.SchemaVersion == 2 {
+		t.UpdateConsensus()
+		t.SchemaVersion = 3
+	}
+	// version 3: results had no outcome, and every result that was not a success was a failure.
+	// Successful results have outcome "ok", the outcome of the others is unknown and stays empty.
+	if t.SchemaVersion == 3 {
+		for k, result := range t.Results {
+			if result.Success && result.Outcome == "" {
+				result.Outcome = OutcomeOK
+				t.Results[k] = result
+			}
+		}
+		t.updateSummary()
+		t.SchemaVersion = 4
+	}
+	return nil
+}
+
+// TaskInputs refers to the input objects of a task in storage.
+type TaskInputs struct {
+	// storage key of the pre-state
+	Pre string `firestore:"pre" json:"pre"`
+	// storage keys of the blocks, in block order
+	Blocks []string `firestore:"blocks" json:"blocks"`
+}
+
+// LegacyTaskInputs returns the per-task storage keys of the inputs, as used before inputs were deduplicated.
+func LegacyTaskInputs(specVersion string, specConfig string, key string, blocks int) TaskInputs {
+	prefix := specVersion + "/" + specConfig + "/" + key
+	out := TaskInputs{
+		Pre:    prefix + "/pre.ssz",
+		Blocks: make([]string, blocks, blocks),
+	}
+	for i := 0; i < blocks; i++ {
+		out.Blocks[i] = fmt.Sprintf("%s/block_%d.ssz", prefix, i)
+	}
+	return out
+}
+
+type ResultEntry struct {
+	// true if the outcome is "ok"
+	Success bool `firestore:"success" json:"success"`
+	// how the transition ended. Empty for results that were not a success, and did not report an outcome.
+	Outcome Outcome `firestore:"outcome" json:"outcome"`
+	// optional, the error of a result that was not a success, as reported by the client.
+	ErrorCode     string    `firestore:"error-code" json:"error-code"`
+	ErrorMessage  string    `firestore:"error-message" json:"error-message"`
+	Created       time.Time `firestore:"created" json:"created"`
+	ClientName    string    `firestore:"client-name" json:"client-name"`
+	ClientVersion string    `firestore:"client-version" json:"client-version"`
+	// the worker that produced the result, and the ID of the result message. Empty if unknown.
+	WorkerID  string `firestore:"worker-id" json:"worker-id"`
+	MessageID string `firestore:"message-id" json:"message-id"`
+	PostHash  string `firestore:"post-hash" json:"post-hash"`
+	// hash tree root of the post-state, 0x-prefixed hex. Empty if the client did not report it.
+	StateRoot string `firestore:"state-root" json:"state-root"`
+	// optional, the state roots after each block, and after each slot, to localize where results diverge.
+	// See ResultMsg for the format, and Task.Divergence.
+	BlockStateRoots []string       `firestore:"block-state-roots" json:"block-state-roots"`
+	SlotStateRoots  []string       `firestore:"slot-state-roots" json:"slot-state-roots"`
+	Files           ResultFilesRef `firestore:"files" json:"files"`
+	// if the result matches the expected post-state of the task, see Task.Judge.
+	Verdict Verdict `firestore:"verdict" json:"verdict"`
+	// the outcome of checking the post-state file against the post-hash and state root. Nil if it was not checked.
+	Verification *Verification `firestore:"verification" json:"verification"`
+}
+
+// Failed is true if the result was not a success, with an outcome that is meaningful for the client, see Outcome.Meaningful.
+func (r *ResultEntry) Failed() bool {
+	return !r.Success && r.Outcome.Meaningful()
+}
+
+// Verdict is the judgement of a result against the expected post-state of a task.
+// Empty if the result cannot be judged.
+type Verdict string
+
+const (
+	VerdictCorrect   Verdict = "correct"
+	VerdictIncorrect Verdict = "incorrect"
+)
+
+// AddResult adds the result to the task, or replaces the result with the same key.
+// The stored result is judged against the expected post-state of the task, see Judge.
+// The workers and their outcomes, the has-fail and mismatch-expected flags, and the consensus are updated.
+func (t *Task) AddResult(key string, result *ResultEntry) {
+	if t.Results == nil {
+		t.Results = make(map[string]ResultEntry)
+	}
+	entry := *result
+	entry.Verdict = t.Judge(&entry)
+	t.Results[key] = entry
+	if t.WorkersVersioned == nil {
+		t.WorkersVersioned = make(map[string]string)
+	}
+	t.WorkersVersioned[result.ClientName] = result.ClientVersion
+	if t.Workers == nil {
+		t.Workers = make(map[string]bool)
+	}
+	t.Workers[result.ClientName] = true
+	t.updateSummary()
+}
+
+// ReplaceResult replaces an existing result of the task, e.g. after it was verified.
+// The worker outcomes, the has-fail and mismatch-expected flags, and the consensus are updated. The workers are not changed.
+func (t *Task) ReplaceResult(key string, result *ResultEntry) {
+	t.Results[key] = *result
+	t.updateSummary()
+}
+
+// updateSummary updates the worker outcomes, the has-fail and mismatch-expected flags, and the consensus, from all results.
+func (t *Task) updateSummary() {
+	// a replaced result may have been the only failure or mismatch, or the only result with its outcome
+	t.WorkerOutcomes = nil
+	t.HasFail = false
+	t.MismatchExpected = false
+	for _, r := range t.Results {
+		if r.Outcome != "" {
+			if t.WorkerOutcomes == nil {
+				t.WorkerOutcomes = make(map[string]map[string]bool)
+			}
+			if t.WorkerOutcomes[r.ClientName] == nil {
+				t.WorkerOutcomes[r.ClientName] = make(map[string]bool)
+			}
+			t.WorkerOutcomes[r.ClientName][string(r.Outcome)] = true
+		}
+		if r.Failed() {
+			t.HasFail = true
+		}
+		if r.Verdict == VerdictIncorrect {
+			t.MismatchExpected = true
+		}
+	}
+	t.UpdateConsensus()
+}
+
+// Judge decides if the result matches the expected post-state of the task.
+// Returns an empty verdict if the task has no expected post-state, if the result was a success without a state root,
+// or if the result was not a success but its outcome is not meaningful, e.g. an infrastructure error.
+// A failed result never matches.
+func (t *Task) Judge(result *ResultEntry) Verdict {
+	if t.ExpectedPostRoot == "" {
+		return ""
+	}
+	if !result.Success {
+		if result.Failed() {
+			return VerdictIncorrect
+		}
+		return ""
+	}
+	if result.StateRoot == "" {
+		return ""
+	}
+	if result.StateRoot == t.ExpectedPostRoot {
+		return VerdictCorrect
+	}
+	return VerdictIncorrect
+}
+
+// SetExpectedPostRoot changes the expected post-state of the task, and judges all results again.
+// An empty root removes the expectation.
+func (t *Task) SetExpectedPostRoot(root string) {
+	t.ExpectedPostRoot = root
+	t.MismatchExpected = false
+	for k, result := range t.Results {
+		result.Verdict = t.Judge(&result)
+		if result.Verdict == VerdictIncorrect {
+			t.MismatchExpected = true
+		}
+		t.Results[k] = result
+	}
+}
+
+type ResultFilesRef struct {
+	PostState string `firestore:"post-state" json:"post-state"`
+	ErrLog    string `firestore:"err-log" json:"err-log"`
+	OutLog    string `firestore:"out-log" json:"out-log"`
+}
@@ -0,0 +1,229 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJudge(t *testing.T) {
+	const expected = "0x01"
+	cases := []struct {
+		name     string
+		expected string
+		result   ResultEntry
+		want     Verdict
+	}{
+		{"no expectation", "", ResultEntry{Success: true, Outcome: OutcomeOK, StateRoot: expected}, ""},
+		{"matching root", expected, ResultEntry{Success: true, Outcome: OutcomeOK, StateRoot: expected}, VerdictCorrect},
+		{"other root", expected, ResultEntry{Success: true, Outcome: OutcomeOK, StateRoot: "0x02"}, VerdictIncorrect},
+		{"success without root", expected, ResultEntry{Success: true, Outcome: OutcomeOK}, ""},
+		{"invalid transition", expected, ResultEntry{Outcome: OutcomeInvalidTransition}, VerdictIncorrect},
+		{"failure without outcome", expected, ResultEntry{}, VerdictIncorrect},
+		{"unsupported", expected, ResultEntry{Outcome: OutcomeUnsupported}, ""},
+		{"infra error", expected, ResultEntry{Outcome: OutcomeInfraError}, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			task := &Task{ExpectedPostRoot: c.expected}
+			if got := task.Judge(&c.result); got != c.want {
+				t.Errorf("got verdict %q, expected %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestAddResultSummary(t *testing.T) {
+	cases := []struct {
+		name             string
+		expectedPostRoot string
+		results          []ResultEntry
+		// the expected verdicts of the stored results, if any
+		verdicts         []Verdict
+		hasFail          bool
+		mismatchExpected bool
+		outcomes         map[string]map[string]bool
+	}{
+		{
+			name:     "success",
+			results:  []ResultEntry{{Success: true, Outcome: OutcomeOK, ClientName: "zrnt"}},
+			outcomes: map[string]map[string]bool{"zrnt": {"ok": true}},
+		},
+		{
+			name:     "crash",
+			results:  []ResultEntry{{Outcome: OutcomeCrash, ClientName: "zrnt"}},
+			hasFail:  true,
+			outcomes: map[string]map[string]bool{"zrnt": {"crash": true}},
+		},
+		{
+			name:     "unsupported is not a failure",
+			results:  []ResultEntry{{Outcome: OutcomeUnsupported, ClientName: "zrnt"}},
+			outcomes: map[string]map[string]bool{"zrnt": {"unsupported": true}},
+		},
+		{
+			name:    "failure without outcome",
+			results: []ResultEntry{{ClientName: "zrnt"}},
+			hasFail: true,
+		},
+		{
+			name:             "incorrect verdict",
+			expectedPostRoot: "0x01",
+			results: []ResultEntry{
+				{Success: true, Outcome: OutcomeOK, ClientName: "zrnt", StateRoot: "0x02"},
+				{Outcome: OutcomeTimeout, ClientName: "prysm"},
+			},
+			verdicts:         []Verdict{VerdictIncorrect, VerdictIncorrect},
+			hasFail:          true,
+			mismatchExpected: true,
+			outcomes:         map[string]map[string]bool{"zrnt": {"ok": true}, "prysm": {"timeout": true}},
+		},
+		{
+			name:             "correct verdict",
+			expectedPostRoot: "0x01",
+			results:          []ResultEntry{{Success: true, Outcome: OutcomeOK, ClientName: "zrnt", StateRoot: "0x01"}},
+			verdicts:         []Verdict{VerdictCorrect},
+			outcomes:         map[string]map[string]bool{"zrnt": {"ok": true}},
+		},
+		{
+			name:     "verdict without expected post-state",
+			results:  []ResultEntry{{Success: true, Outcome: OutcomeOK, ClientName: "zrnt", StateRoot: "0x02", Verdict: VerdictIncorrect}},
+			outcomes: map[string]map[string]bool{"zrnt": {"ok": true}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			task := &Task{ExpectedPostRoot: c.expectedPostRoot}
+			for i := range c.results {
+				task.AddResult(string('a'+rune(i)), &c.results[i])
+			}
+			for i := range c.results {
+				var want Verdict
+				if c.verdicts != nil {
+					want = c.verdicts[i]
+				}
+				if got := task.Results[string('a'+rune(i))].Verdict; got != want {
+					t.Errorf("got verdict %q of result %d, expected %q", got, i, want)
+				}
+			}
+			if task.HasFail != c.hasFail {
+				t.Errorf("got has-fail %v, expected %v", task.HasFail, c.hasFail)
+			}
+			if task.MismatchExpected != c.mismatchExpected {
+				t.Errorf("got mismatch-expected %v, expected %v", task.MismatchExpected, c.mismatchExpected)
+			}
+			if !reflect.DeepEqual(task.WorkerOutcomes, c.outcomes) {
+				t.Errorf("got worker outcomes %v, expected %v", task.WorkerOutcomes, c.outcomes)
+			}
+		})
+	}
+}
+
+func TestReplaceResultClearsFlags(t *testing.T) {
+	task := &Task{}
+	task.AddResult("a", &ResultEntry{Outcome: OutcomeCrash, ClientName: "zrnt", Verdict: VerdictIncorrect})
+	task.ReplaceResult("a", &ResultEntry{Success: true, Outcome: OutcomeOK, ClientName: "zrnt", PostHash: "0x01", Verdict: VerdictCorrect})
+	if task.HasFail || task.MismatchExpected {
+		t.Errorf("flags of the replaced result are still set: has-fail %v, mismatch-expected %v", task.HasFail, task.MismatchExpected)
+	}
+	if !reflect.DeepEqual(task.WorkerOutcomes, map[string]map[string]bool{"zrnt": {"ok": true}}) {
+		t.Errorf("outcome of the replaced result is still set: %v", task.WorkerOutcomes)
+	}
+	if task.Consensus.Majority != "0x01" {
+		t.Errorf("consensus was not updated: %+v", task.Consensus)
+	}
+}
+
+func TestSetExpectedPostRoot(t *testing.T) {
+	task := &Task{}
+	task.AddResult("a", &ResultEntry{Success: true, Outcome: OutcomeOK, ClientName: "zrnt", StateRoot: "0x01"})
+	task.AddResult("b", &ResultEntry{Success: true, Outcome: OutcomeOK, ClientName: "prysm", StateRoot: "0x02"})
+	task.SetExpectedPostRoot("0x01")
+	if task.Results["a"].Verdict != VerdictCorrect || task.Results["b"].Verdict != VerdictIncorrect || !task.MismatchExpected {
+		t.Errorf("results were not judged: %+v, mismatch-expected %v", task.Results, task.MismatchExpected)
+	}
+	task.SetExpectedPostRoot("")
+	if task.Results["a"].Verdict != "" || task.Results["b"].Verdict != "" || task.MismatchExpected {
+		t.Errorf("verdicts were not removed: %+v, mismatch-expected %v", task.Results, task.MismatchExpected)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	cases := []struct {
+		name  string
+		task  Task
+		check func(t *testing.T, task *Task)
+		err   bool
+	}{
+		{
+			name: "unversioned task gets legacy inputs",
+			task: Task{Key: "abc", SpecVersion: "v0.9.0", SpecConfig: "minimal", Blocks: 2},
+			check: func(t *testing.T, task *Task) {
+				want := TaskInputs{
+					Pre:    "v0.9.0/minimal/abc/pre.ssz",
+					Blocks: []string{"v0.9.0/minimal/abc/block_0.ssz", "v0.9.0/minimal/abc/block_1.ssz"},
+				}
+				if !reflect.DeepEqual(task.Inputs, want) {
+					t.Errorf("got inputs %+v, expected %+v", task.Inputs, want)
+				}
+			},
+		},
+		{
+			name: "version 2 gets a consensus",
+			task: Task{SchemaVersion: 2, Results: map[string]ResultEntry{
+				"a": {Success: true, ClientName: "zrnt", ClientVersion: "v1", PostHash: "0x01"},
+			}},
+			check: func(t *testing.T, task *Task) {
+				if task.Consensus.Majority != "0x01" || task.Consensus.Distinct != 1 {
+					t.Errorf("consensus was not computed: %+v", task.Consensus)
+				}
+			},
+		},
+		{
+			name: "version 3 successes get outcome ok",
+			task: Task{SchemaVersion: 3, Results: map[string]ResultEntry{
+				"a": {Success: true, ClientName: "zrnt"},
+				"b": {ClientName: "prysm"},
+			}},
+			check: func(t *testing.T, task *Task) {
+				if task.Results["a"].Outcome != OutcomeOK || task.Results["b"].Outcome != "" {
+					t.Errorf("outcomes were not migrated: %+v", task.Results)
+				}
+				if !task.HasFail {
+					t.Error("failure without outcome is not a failure after migration")
+				}
+			},
+		},
+		{
+			name: "current version is unchanged",
+			task: Task{SchemaVersion: SchemaVersion, Inputs: TaskInputs{Pre: "x"}},
+			check: func(t *testing.T, task *Task) {
+				if task.Inputs.Pre != "x" {
+					t.Errorf("inputs were changed: %+v", task.Inputs)
+				}
+			},
+		},
+		{
+			name: "newer version",
+			task: Task{SchemaVersion: SchemaVersion + 1},
+			err:  true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			task := c.task
+			err := task.Migrate()
+			if c.err {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if task.SchemaVersion != SchemaVersion {
+				t.Errorf("got schema version %d, expected %d", task.SchemaVersion, SchemaVersion)
+			}
+			c.check(t, &task)
+		})
+	}
+}
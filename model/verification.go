@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+// Verification is the outcome of checking the post-state file of a result,
+// against the post-hash and state root reported by the client.
+type Verification struct {
+	Status VerificationStatus `firestore:"status" json:"status"`
+	// the post-hash and state root computed from the post-state file.
+	// Empty if the file could not be read, or the state root could not be computed.
+	PostHash  string `firestore:"post-hash" json:"post-hash"`
+	StateRoot string `firestore:"state-root" json:"state-root"`
+	// what did not match, or why the post-state could not be checked. Empty if the status is ok.
+	Detail string `firestore:"detail" json:"detail"`
+	// when the post-state was checked
+	Time time.Time `firestore:"time" json:"time"`
+}
+
+type VerificationStatus string
+
+const (
+	// the post-state file matches the post-hash, and the state root if the client reported it.
+	VerificationOK VerificationStatus = "ok"
+	// the post-state file does not match the post-hash or the state root.
+	VerificationMismatch VerificationStatus = "mismatch"
+	// the post-state file does not exist.
+	VerificationMissing VerificationStatus = "missing"
+	// the post-state file is not a valid state, or is too large to check.
+	VerificationInvalid VerificationStatus = "invalid"
+	// the post-state file could not be fetched, e.g. it is not in a known store. It may be checked again later.
+	VerificationUnavailable VerificationStatus = "unavailable"
+)
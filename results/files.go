@@ -0,0 +1,286 @@
+package results
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	. "github.com/protolambda/httphelpers/codes"
+	"github.com/protolambda/muskoka-server/blobs"
+	"github.com/protolambda/muskoka-server/model"
+	"github.com/protolambda/muskoka-server/specs"
+	"github.com/protolambda/muskoka-server/tasks"
+	"github.com/protolambda/muskoka-server/upload"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultMaxPostStateSize = 64 << 20
+const defaultMaxLogSize = 4 << 20
+
+// the result files, by form name, and the name of each in storage.
+var resultFileNames = map[string]string{
+	"post":    "post.ssz",
+	"out-log": "out_log.txt",
+	"err-log": "err_log.txt",
+}
+
+// FilesHandler accepts results together with their files, and stores the files for the worker.
+type FilesHandler struct {
+	// Results processes the results, and authenticates the clients.
+	Results *Handler
+	// Outputs stores the result files.
+	Outputs blobs.Store
+	// the URL prefix of the objects in Outputs, to link the files in the result
+	OutputsURL string
+	// Specs has the state type to compute the state root of the post-state with.
+	Specs *specs.Registry
+	// Size limits of the result files, in bytes.
+	MaxPostStateSize int64
+	MaxLogSize       int64
+	// TempDir is the directory the result files are spooled to while they are checked and stored, see upload.SpoolInput.
+	// Empty for the default directory for temporary files.
+	TempDir string
+}
+
+func NewFilesHandler(results *Handler, outputs blobs.Store, outputsURL string) *FilesHandler {
+	return &FilesHandler{
+		Results:          results,
+		Outputs:          outputs,
+		OutputsURL:       outputsURL,
+		Specs:            specs.NewDefaultRegistry(),
+		MaxPostStateSize: defaultMaxPostStateSize,
+		MaxLogSize:       defaultMaxLogSize,
+	}
+}
+
+var defaultFilesHandler *FilesHandler
+var defaultFilesHandlerOnce sync.Once
+
+// the handler for the cloud function, with its dependencies configured by the environment.
+func getDefaultFilesHandler() *FilesHandler {
+	defaultFilesHandlerOnce.Do(func() {
+		bucketName := os.Getenv("RESULTS_BUCKET")
+		if bucketName == "" {
+			log.Fatalf("RESULTS_BUCKET must be set to store result files")
+		}
+		outputs, err := blobs.NewGCSStore(context.Background(), bucketName)
+		if err != nil {
+			log.Fatalf("Failed to create results store: %v", err)
+		}
+		defaultFilesHandler = NewFilesHandler(getDefaultHandler(), outputs, "https://storage.googleapis.com/"+bucketName+"/")
+	})
+	return defaultFilesHandler
+}
+
+// UploadResult is the cloud function entry point for results with files, see FilesHandler.
+func UploadResult(w http.ResponseWriter, r *http.Request) {
+	getDefaultFilesHandler().ServeHTTP(w, r)
+}
+
+// ResultFilesPrefix is the storage path of the files of a result.
+func ResultFilesPrefix(specVersion string, taskKey string, clientName string, clientVersion string, resultKey string) string {
+	return fmt.Sprintf("%s/%s/results/%s/%s/%s/", specVersion, taskKey, clientName, clientVersion, resultKey)
+}
+
+// ServeHTTP accepts a multipart upload of a result message, as form value "result", and its files:
+// "post" (the post-state), "out-log" and "err-log". All are optional, except the result message.
+// The uploaded files are not covered by the signature of a signed result: an uploaded post-state must match the post-hash
+// of the result message, so that a replayed message cannot attach another post-state.
+// The state root is computed from the uploaded post-state if the spec of the task is known, a claimed state root must match.
+func (h *FilesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	mr, err := r.MultipartReader()
+	if SERVER_BAD_INPUT.Check(w, err, "expected a multipart upload") {
+		return
+	}
+	var data []byte
+	// form name -> contents, spooled to temporary files, not held in memory
+	files := make(map[string]*upload.SpooledInput)
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if SERVER_BAD_INPUT.Check(w, err, "cannot parse multipart upload") {
+			return
+		}
+		name := part.FormName()
+		var limit int64
+		switch name {
+		case "result":
+			limit = maxResultMsgSize
+		case "post":
+			limit = h.MaxPostStateSize
+		case "out-log", "err-log":
+			limit = h.MaxLogSize
+		default:
+			SERVER_BAD_INPUT.Report(w, "unexpected form value "+name)
+			return
+		}
+		_, isFile := files[name]
+		if isFile || (name == "result" && data != nil) {
+			SERVER_BAD_INPUT.Report(w, "form value "+name+" is specified more than once")
+			return
+		}
+		if name == "result" {
+			data, err = readLimited(part, limit, name)
+			if SERVER_BAD_INPUT.Check(w, err, "cannot read form value "+name) {
+				return
+			}
+			continue
+		}
+		f, err := upload.SpoolInput(h.TempDir, part, limit)
+		if SERVER_BAD_INPUT.Check(w, err, "cannot read form value "+name) {
+			return
+		}
+		files[name] = f
+	}
+	if data == nil {
+		SERVER_BAD_INPUT.Report(w, "no result was specified")
+		return
+	}
+	result, err := decodeResult(data)
+	if reportResultErr(w, err) {
+		return
+	}
+	if !h.Results.authorized(r, result.ClientName) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if reportResultErr(w, h.Results.checkReplay(result)) {
+		return
+	}
+
+	post, hasPost := files["post"]
+	// the uploaded post-state is verified here, it does not have to be verified again after it is stored.
+	var verified *model.Verification
+	if hasPost {
+		hasher := sha256.New()
+		if _, err := io.Copy(hasher, post.Reader()); SERVER_ERR.Check(w, err, "could not read the post-state") {
+			return
+		}
+		postHashStr := "0x" + hex.EncodeToString(hasher.Sum(nil))
+		if result.PostHash == "" {
+			SERVER_BAD_INPUT.Report(w, "result with an uploaded post-state must have the post hash of the post-state")
+			return
+		}
+		if result.PostHash != postHashStr {
+			SERVER_BAD_INPUT.Report(w, "post hash of the result does not match the uploaded post-state")
+			return
+		}
+		verified = &model.Verification{Status: model.VerificationOK, PostHash: postHashStr, Time: h.Results.Now()}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	task, err := h.Results.checkResult(ctx, result, data)
+	if reportResultErr(w, err) {
+		return
+	}
+
+	if hasPost {
+		// tasks of specs that are not supported anymore can still get results, without a computed state root.
+		if spec, err := h.Specs.Get(task.SpecVersion, task.SpecConfig); err == nil {
+			def, err := spec.Obj(specs.BeaconState)
+			if SERVER_ERR.Check(w, err, "spec has no state type") {
+				return
+			}
+			if obj, err := def.DecodeFrom(bufio.NewReader(post.Reader()), uint64(post.Size)); err == nil {
+				stateRoot := def.HashTreeRoot(obj)
+				if result.StateRoot != "" && result.StateRoot != stateRoot {
+					SERVER_BAD_INPUT.Report(w, "claimed state root does not match the uploaded post-state")
+					return
+				}
+				if n := len(result.BlockStateRoots); n > 0 && result.BlockStateRoots[n-1] != stateRoot {
+					SERVER_BAD_INPUT.Report(w, "state root of the last block does not match the uploaded post-state")
+					return
+				}
+				result.StateRoot = stateRoot
+				verified.StateRoot = stateRoot
+			} else if result.Succeeded() {
+				SERVER_BAD_INPUT.Report(w, fmt.Sprintf("post-state of successful result is invalid: %v", err))
+				return
+			}
+		}
+	}
+
+	resultKey := h.Results.resultKey(result, "")
+	// the files of repeated results that are known to be ignored are not stored.
+	if tasks.ResultKeyFor(task.Results, resultKey, h.Results.Duplicates) == "" {
+		writeSubmitResult(w, resultKey, false)
+		return
+	}
+	// The result is merged before its files are stored, to reserve its key: a concurrent repeated result
+	// is then ignored (or stored under another key) before it stores any files, and cannot overwrite the files of this result.
+	// The uploaded files are linked, and the uploaded post-state is recorded as verified, once the files are stored.
+	entry := result.Entry(h.Results.Now())
+	for name := range files {
+		setFileLink(&entry.Files, name, "")
+	}
+	storedKey, stored, err := h.Results.mergeResult(ctx, task, entry, resultKey)
+	if reportResultErr(w, err) {
+		return
+	}
+	if !stored {
+		writeSubmitResult(w, storedKey, false)
+		return
+	}
+	// If a file cannot be stored, the result stays stored without links to the uploaded files.
+	links := entry.Files
+	prefix := ResultFilesPrefix(task.SpecVersion, task.Key, result.ClientName, result.ClientVersion, storedKey)
+	for name, f := range files {
+		objKey := prefix + resultFileNames[name]
+		if SERVER_ERR.Check(w, h.Outputs.Put(ctx, objKey, f.Reader()), "could not store "+name) {
+			return
+		}
+		setFileLink(&links, name, h.OutputsURL+objKey)
+	}
+	err = h.Results.Tasks.UpdateResult(ctx, task.Key, storedKey, func(task *model.Task, current *model.ResultEntry) error {
+		// a repeated result may have replaced the result in the meantime, see tasks.KeepLatest. It links its own files.
+		if !sameAttempt(current, entry) {
+			return errResultChanged
+		}
+		current.Files = links
+		current.Verification = verified
+		return nil
+	})
+	if err == errResultChanged {
+		log.Printf("result %s of task %s was replaced before its files were linked", storedKey, task.Key)
+	} else if SERVER_ERR.Check(w, err, "could not link the result files") {
+		return
+	}
+	writeSubmitResult(w, storedKey, true)
+}
+
+// setFileLink sets the link to the result file with the given form name.
+func setFileLink(files *model.ResultFilesRef, name string, url string) {
+	switch name {
+	case "post":
+		files.PostState = url
+	case "out-log":
+		files.OutLog = url
+	case "err-log":
+		files.ErrLog = url
+	}
+}
+
+// sameAttempt checks if the stored result is the given result, and not a repeated result that replaced it.
+// Firestore stores times with microsecond precision.
+func sameAttempt(stored *model.ResultEntry, result *model.ResultEntry) bool {
+	return stored.PostHash == result.PostHash &&
+		stored.Created.Truncate(time.Microsecond).Equal(result.Created.Truncate(time.Microsecond))
+}
@@ -0,0 +1,245 @@
+package results
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/protolambda/muskoka-server/blobs"
+	"github.com/protolambda/muskoka-server/model"
+	"github.com/protolambda/muskoka-server/specs"
+	"github.com/protolambda/muskoka-server/tasks"
+	"github.com/protolambda/zssz"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// encodeState encodes a zeroed v0.9.0 minimal state with the given slot.
+func encodeState(t *testing.T, slot uint64) []byte {
+	spec, err := specs.NewDefaultRegistry().Get("v0.9.0", "minimal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := spec.Obj(specs.BeaconState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := def.Alloc()
+	reflect.ValueOf(obj).Elem().FieldByName("Slot").SetUint(slot)
+	var buf bytes.Buffer
+	if _, err := zssz.Encode(&buf, obj, def.SSZ); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func postHashOf(data []byte) string {
+	h := sha256.Sum256(data)
+	return "0x" + hex.EncodeToString(h[:])
+}
+
+// newTestFilesHandler creates a files handler on top of newTestHandler, with the files stored in a temporary directory,
+// and spooled to another temporary directory. Every token is accepted.
+func newTestFilesHandler(t *testing.T) *FilesHandler {
+	h := newTestHandler(t)
+	h.CheckToken = func(client string, token string) bool {
+		return true
+	}
+	outputs, err := blobs.NewLocalStore(testDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh := NewFilesHandler(h, outputs, "outputs://")
+	fh.TempDir = testDir(t)
+	return fh
+}
+
+// submitFiles submits the result with the post-state, if any.
+func submitFiles(t *testing.T, h *FilesHandler, msg *model.ResultMsg, post []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := mw.WriteField("result", string(resultData(t, msg))); err != nil {
+		t.Fatal(err)
+	}
+	if post != nil {
+		w, err := mw.CreateFormFile("post", "post.ssz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/results/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// readPostState reads the post-state file of the stored result.
+func readPostState(t *testing.T, h *FilesHandler, result *model.ResultEntry) []byte {
+	rc, err := h.Outputs.Get(context.Background(), strings.TrimPrefix(result.Files.PostState, h.OutputsURL))
+	if err != nil {
+		t.Fatalf("could not get post-state %q: %v", result.Files.PostState, err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestFilesHandlerPostHash(t *testing.T) {
+	post := encodeState(t, 3)
+	cases := []struct {
+		name      string
+		claimed   string
+		wantCode  int
+		wantStore bool
+	}{
+		{"matches", postHashOf(post), http.StatusOK, true},
+		{"mismatch", postHashOf([]byte("other")), http.StatusBadRequest, false},
+		{"no post hash", "", http.StatusBadRequest, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := newTestFilesHandler(t)
+			msg := &model.ResultMsg{Success: true, PostHash: c.claimed, ClientName: "zrnt", ClientVersion: "v1", Key: "a", MessageID: "m1"}
+			rec := submitFiles(t, h, msg, post)
+			if rec.Code != c.wantCode {
+				t.Fatalf("got status %d, expected %d: %s", rec.Code, c.wantCode, rec.Body.String())
+			}
+			// the spooled files are removed, whether the result was accepted or not
+			if left, err := ioutil.ReadDir(h.TempDir); err != nil || len(left) != 0 {
+				t.Errorf("got %d temporary files (%v), expected them to be removed", len(left), err)
+			}
+			task, err := h.Results.Tasks.GetTask(context.Background(), "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !c.wantStore {
+				if len(task.Results) != 0 {
+					t.Errorf("rejected result was stored: %+v", task.Results)
+				}
+				return
+			}
+			var res SubmitResult
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			result, ok := task.Results[res.ResultKey]
+			if !ok {
+				t.Fatalf("result %s was not stored", res.ResultKey)
+			}
+			if result.PostHash != postHashOf(post) || result.StateRoot == "" {
+				t.Errorf("got post hash %s and state root %q, expected the post hash %s and a computed state root",
+					result.PostHash, result.StateRoot, postHashOf(post))
+			}
+			if result.Verification == nil || result.Verification.Status != model.VerificationOK {
+				t.Errorf("uploaded post-state is not verified: %+v", result.Verification)
+			}
+			if want := h.OutputsURL + ResultFilesPrefix("v0.9.0", "a", "zrnt", "v1", res.ResultKey) + "post.ssz"; result.Files.PostState != want {
+				t.Errorf("got post-state link %s, expected %s", result.Files.PostState, want)
+			}
+			if !bytes.Equal(readPostState(t, h, &result), post) {
+				t.Error("stored post-state differs from the upload")
+			}
+		})
+	}
+}
+
+// staleTasks returns a fixed copy of the task, as read before a concurrent result was merged.
+type staleTasks struct {
+	tasks.Store
+	stale *model.Task
+}
+
+func (s *staleTasks) GetTask(ctx context.Context, key string) (*model.Task, error) {
+	task := *s.stale
+	return &task, nil
+}
+
+func TestFilesHandlerConcurrentDuplicate(t *testing.T) {
+	h := newTestFilesHandler(t)
+	ctx := context.Background()
+	before, err := h.Results.Tasks.GetTask(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := encodeState(t, 3)
+	msg := &model.ResultMsg{Success: true, PostHash: postHashOf(first), ClientName: "zrnt", ClientVersion: "v1", Key: "a", MessageID: "m1"}
+	if rec := submitFiles(t, h, msg, first); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	// the repeated result is checked against the task as it was before the first result was merged
+	h.Results.Tasks = &staleTasks{Store: h.Results.Tasks, stale: before}
+	second := encodeState(t, 4)
+	repeated := *msg
+	repeated.PostHash = postHashOf(second)
+	rec := submitFiles(t, h, &repeated, second)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	var res SubmitResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Stored {
+		t.Error("repeated result was stored, the first result is kept")
+	}
+	task, err := h.Results.Tasks.(*staleTasks).Store.GetTask(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(task.Results) != 1 {
+		t.Fatalf("got %d results, expected 1", len(task.Results))
+	}
+	for _, result := range task.Results {
+		if result.PostHash != postHashOf(first) {
+			t.Errorf("got post hash %s, expected the post hash of the first result", result.PostHash)
+		}
+		if !bytes.Equal(readPostState(t, h, &result), first) {
+			t.Error("the post-state of the first result was overwritten")
+		}
+	}
+}
+
+func TestFilesHandlerReplacedResult(t *testing.T) {
+	h := newTestFilesHandler(t)
+	h.Results.Duplicates = tasks.KeepLatest
+	replacement := &model.ResultEntry{Success: true, Outcome: model.OutcomeOK, ClientName: "zrnt", ClientVersion: "v1",
+		PostHash: postHashOf([]byte("other"))}
+	store := h.Results.Tasks
+	// the result is replaced by a repeated result after it is stored, before its files are linked
+	h.Results.Tasks = &replacingTasks{Store: store, replacement: replacement}
+	post := encodeState(t, 3)
+	msg := &model.ResultMsg{Success: true, PostHash: postHashOf(post), ClientName: "zrnt", ClientVersion: "v1", Key: "a", MessageID: "m1"}
+	rec := submitFiles(t, h, msg, post)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	var res SubmitResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	task, err := store.GetTask(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := task.Results[res.ResultKey]
+	if result.PostHash != replacement.PostHash || result.Files.PostState != "" || result.Verification != nil {
+		t.Errorf("the files of the replaced result were linked to the repeated result: %+v", result)
+	}
+}
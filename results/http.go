@@ -0,0 +1,155 @@
+package results
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	. "github.com/protolambda/httphelpers/codes"
+	"github.com/protolambda/muskoka-server/auth"
+	"github.com/protolambda/muskoka-server/model"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// result messages are small, the result files are linked.
+const maxResultMsgSize = 1 << 20
+
+// SubmitResult is the response to a result submitted over HTTP.
+type SubmitResult struct {
+	// the key of the result in the task
+	ResultKey string `json:"result-key"`
+	// false if the result was a repeated result, and ignored
+	Stored bool `json:"stored"`
+}
+
+// ResultsHTTP is the cloud function entry point for results submitted over HTTP, see Handler.ServeHTTP.
+func ResultsHTTP(w http.ResponseWriter, r *http.Request) {
+	getDefaultHandler().ServeHTTP(w, r)
+}
+
+// ServeHTTP accepts a result message as JSON body, and processes it the same as a result from the event bus.
+// The request must have the bearer token of the client, unless the result is signed, see Handler.Keys.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := readLimited(r.Body, maxResultMsgSize, "result")
+	if SERVER_BAD_INPUT.Check(w, err, "could not read result") {
+		return
+	}
+	// the client is authenticated first, the message is checked in full when it is processed.
+	var result model.ResultMsg
+	if SERVER_BAD_INPUT.Check(w, json.Unmarshal(data, &result), "could not decode result input") {
+		return
+	}
+	if !h.authorized(r, result.ClientName) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if reportResultErr(w, h.checkReplay(&result)) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+	resultKey, stored, err := h.ProcessResult(ctx, data, "")
+	if reportResultErr(w, err) {
+		return
+	}
+	writeSubmitResult(w, resultKey, stored)
+}
+
+// reads all data, up to the limit. Returns an error if there is more data.
+func readLimited(r io.Reader, limit int64, name string) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s is too large, limit is %d bytes", name, limit)
+	}
+	return data, nil
+}
+
+// authorized checks the bearer token of the request, for results of the named client.
+// Always true if results are signed, the signature is checked when the result is processed.
+func (h *Handler) authorized(r *http.Request, client string) bool {
+	if h.Keys != nil {
+		return true
+	}
+	token, ok := auth.BearerToken(r)
+	return ok && h.CheckToken(client, token)
+}
+
+// checkReplay checks that a signed result has a message id. A signed result can be submitted again by anyone who has seen it,
+// the message id keys the result, to make a replayed result a repeated result instead of a new result.
+// Results from the event bus are keyed by the ID of the bus message when they have no message id.
+func (h *Handler) checkReplay(result *model.ResultMsg) error {
+	if h.Keys != nil && result.MessageID == "" {
+		return invalidResult("signed results submitted over HTTP must have a message id")
+	}
+	return nil
+}
+
+// reports the error of processing a result, if any. Returns true if there was an error.
+func reportResultErr(w http.ResponseWriter, err error) bool {
+	if invalid, ok := err.(*InvalidResultError); ok {
+		if invalid.Forbidden {
+			http.Error(w, invalid.Reason, http.StatusForbidden)
+			return true
+		}
+		SERVER_BAD_INPUT.Report(w, invalid.Reason)
+		return true
+	}
+	return SERVER_ERR.Check(w, err, "could not process result")
+}
+
+func writeSubmitResult(w http.ResponseWriter, resultKey string, stored bool) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(int(SERVER_OK))
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&SubmitResult{ResultKey: resultKey, Stored: stored}); err != nil {
+		log.Printf("failed to encode result response to JSON: %v", err)
+	}
+}
+
+// ClientTokens holds the bearer tokens of clients: client name -> tokens.
+// Multiple tokens of a client are accepted, to rotate tokens.
+type ClientTokens map[string][]string
+
+// LoadTokensFile loads the bearer tokens of clients from a JSON file, an object of client name -> list of tokens.
+func LoadTokensFile(path string) (ClientTokens, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var tokens ClientTokens
+	if err := json.NewDecoder(f).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// HasClient checks if any tokens are registered for the client.
+func (ct ClientTokens) HasClient(client string) bool {
+	return len(ct[client]) > 0
+}
+
+// Check checks if the token belongs to the client, see Handler.CheckToken.
+func (ct ClientTokens) Check(client string, token string) bool {
+	valid := false
+	for _, t := range ct[client] {
+		// compare every token, to not leak which token matched
+		if t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
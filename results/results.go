@@ -2,98 +2,122 @@ package results
 
 import (
 	"bytes"
-	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/pubsub"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"github.com/protolambda/muskoka-server/clientkeys"
+	"github.com/protolambda/muskoka-server/events"
+	"github.com/protolambda/muskoka-server/model"
+	"github.com/protolambda/muskoka-server/tasks"
 	"log"
 	"os"
 	"regexp"
+	"sync"
 	"time"
 )
 
-// default: every client is denied.
-var CheckClient = func(name string) bool {
-	return false
+// Handler processes results of transition tasks, and merges them into the tasks.
+type Handler struct {
+	// Tasks stores the transition tasks the results are merged into.
+	Tasks tasks.Store
+	// CheckClient decides if results of the named client are accepted.
+	CheckClient func(name string) bool
+	// Keys, if not nil, are the public keys of the clients. Results must then be signed by an active key of their client.
+	Keys *clientkeys.Registry
+	// CheckToken decides if the bearer token of a result submitted over HTTP belongs to the named client.
+	// Not used if results are signed, see Keys.
+	CheckToken func(client string, token string) bool
+	// Duplicates decides what happens to a repeated result message, see ResultKey.
+	Duplicates tasks.DuplicatePolicy
+	// Verify decides if the post-states of stored results are verified, see Verifier. Off by default.
+	Verify VerifyMode
+	// Verifier verifies the results, if they are verified inline.
+	Verifier *Verifier
+	// Events is the bus results to verify are published to, if they are verified in the background.
+	Events events.Bus
+	// the time to register new results with
+	Now func() time.Time
 }
 
-var fsTransitionsCollection *firestore.CollectionRef
+// NewHandler creates a results handler. By default, every client is denied, and repeated results are ignored.
+func NewHandler(taskStore tasks.Store) *Handler {
+	return &Handler{
+		Tasks: taskStore,
+		CheckClient: func(name string) bool {
+			return false
+		},
+		CheckToken: func(client string, token string) bool {
+			return false
+		},
+		Duplicates: tasks.KeepFirst,
+		Verify:     VerifyOff,
+		Now:        time.Now,
+	}
+}
 
-func init() {
-	projectID := os.Getenv("GCP_PROJECT")
-	ctx := context.Background()
+var defaultHandler *Handler
+var defaultHandlerOnce sync.Once
 
-	// database
-	{
-		firestoreClient, err := firestore.NewClient(ctx, projectID)
+// the handler for the cloud function, with its dependencies configured by the environment.
+func getDefaultHandler() *Handler {
+	defaultHandlerOnce.Do(func() {
+		taskStore, err := tasks.FromEnv(context.Background())
 		if err != nil {
-			log.Fatalf("Failed to create firestore client: %v", err)
+			log.Fatalf("Failed to create task store: %v", err)
+		}
+		defaultHandler = NewHandler(taskStore)
+		if keysPath := os.Getenv("MUSKOKA_CLIENT_KEYS"); keysPath != "" {
+			keys, err := clientkeys.LoadFile(keysPath)
+			if err != nil {
+				log.Fatalf("Failed to load client keys: %v", err)
+			}
+			defaultHandler.Keys = keys
+			// with signed results, a single function can accept the results of all clients with keys.
+			defaultHandler.CheckClient = keys.HasClient
+		}
+		if tokensPath := os.Getenv("MUSKOKA_CLIENT_TOKENS"); tokensPath != "" {
+			tokens, err := LoadTokensFile(tokensPath)
+			if err != nil {
+				log.Fatalf("Failed to load client tokens: %v", err)
+			}
+			defaultHandler.CheckToken = tokens.Check
+			if defaultHandler.Keys == nil {
+				// clients with tokens can submit results over HTTP
+				defaultHandler.CheckClient = tokens.HasClient
+			}
+		}
+		policy, err := tasks.ParseDuplicatePolicy(os.Getenv("MUSKOKA_DUPLICATE_RESULTS"))
+		if err != nil {
+			log.Fatalf("Invalid duplicate results policy: %v", err)
+		}
+		defaultHandler.Duplicates = policy
+		mode, err := ParseVerifyMode(os.Getenv("MUSKOKA_VERIFY_RESULTS"))
+		if err != nil {
+			log.Fatalf("Invalid verify results mode: %v", err)
+		}
+		defaultHandler.Verify = mode
+		switch mode {
+		case VerifyInline:
+			defaultHandler.Verifier = getDefaultVerifier()
+		case VerifyBackground:
+			bus, err := events.FromEnv(context.Background())
+			if err != nil {
+				log.Fatalf("Failed to create event bus: %v", err)
+			}
+			defaultHandler.Events = bus
 		}
-		fsTransitionsCollection = firestoreClient.Collection("transitions")
-	}
-
-	{
 		if envName := os.Getenv("MUSKOKA_CLIENT_NAME"); envName != "" {
-			CheckClient = func(name string) bool {
+			defaultHandler.CheckClient = func(name string) bool {
 				return name == envName
 			}
 		}
-	}
-}
-
-type Task struct {
-	Index            int                    `firestore:"index"`
-	Blocks           int                    `firestore:"blocks"`
-	SpecVersion      string                 `firestore:"spec-version"`
-	SpecConfig       string                 `firestore:"spec-config"`
-	Created          time.Time              `firestore:"created"`
-	Results          map[string]ResultEntry `firestore:"results"`
-	WorkersVersioned map[string]string      `firestore:"workers-versioned"`
-	Workers          map[string]bool        `firestore:"workers"`
-	HasFail          bool                   `firestore:"has-fail"`
-}
-
-type ResultEntry struct {
-	Success       bool           `firestore:"success"`
-	Created       time.Time      `firestore:"created"`
-	ClientName    string         `firestore:"client-name"`
-	ClientVersion string         `firestore:"client-version"`
-	PostHash      string         `firestore:"post-hash"`
-	Files         ResultFilesRef `firestore:"files"`
-}
-
-type ResultFilesRef struct {
-	PostState string `firestore:"post-state"`
-	ErrLog    string `firestore:"err-log"`
-	OutLog    string `firestore:"out-log"`
-}
-
-type ResultMsg struct {
-	// if the transition was successful (i.e. no err log)
-	Success bool `json:"success"`
-	// the flat-hash of the post-state SSZ bytes, for quickly finding different results.
-	PostHash string `json:"post-hash"`
-	// the name of the client; 'zrnt', 'lighthouse', etc.
-	ClientName string `json:"client-name"`
-	// the version number of the client, may contain a git commit hash
-	ClientVersion string `json:"client-version"`
-	// identifies the transition task
-	Key string `json:"key"`
-	// Result files
-	Files ResultFilesData `json:"files"`
-}
-
-type ResultFilesData struct {
-	// urls to the files
-	PostState string `json:"post-state"`
-	ErrLog    string `json:"err-log"`
-	OutLog    string `json:"out-log"`
+	})
+	return defaultHandler
 }
 
 // PubSubMessage is the payload of a Pub/Sub event.
@@ -104,86 +128,270 @@ type PubSubMessage struct {
 // versions are not used as keys in firestore, and may contain dots.
 var VersionRegex, _ = regexp.Compile("^[0-9a-zA-Z][-_.0-9a-zA-Z]{0,128}$")
 
-// make sure keys don't start with `__`, or underscores at all
-var KeyRegex, _ = regexp.Compile("^[-0-9a-zA-Z=][-_0-9a-zA-Z=]{0,128}$")
+// worker and message IDs, chosen by the worker
+var IDRegex, _ = regexp.Compile("^[-_.:0-9a-zA-Z]{1,128}$")
+
+// error codes are chosen by clients, and are short identifiers.
+var ErrorCodeRegex, _ = regexp.Compile("^[-_.:0-9a-zA-Z]{1,64}$")
 
-// hex encoded bytes32, with 0x prefix
-var RootRegex, _ = regexp.Compile("^0x[0-9a-f]{64}$")
+// the maximum length of the error message of a result, in bytes.
+const maxErrorMessageLength = 1024
 
 // Client auth is checked by configuring the cloud function
 // to only consume messages from a topic specific to the client.
-// And setting the ETH2_CLIENT_NAME environment var.
+// And setting the MUSKOKA_CLIENT_NAME environment var.
+// Alternatively, set MUSKOKA_CLIENT_KEYS to a keys file, to accept results signed by any client with keys,
+// see clientkeys.KeysFile. Both can be combined.
+// A rejected result is logged and not retried, other errors are returned to retry the message.
 func Results(ctx context.Context, m *pubsub.Message) error {
-	dec := json.NewDecoder(bytes.NewReader(m.Data))
-	var result ResultMsg
+	err := getDefaultHandler().HandleResult(ctx, &events.Message{ID: m.ID, Data: m.Data})
+	if events.IsBadMessage(err) {
+		log.Printf("dropping result message %s: %v", m.ID, err)
+		return nil
+	}
+	return err
+}
+
+// HandleResult processes a result message received from the event bus.
+// A rejected result is returned as a bad message: processing it again does not change the outcome.
+func (h *Handler) HandleResult(ctx context.Context, m *events.Message) error {
+	_, _, err := h.ProcessResult(ctx, m.Data, m.ID)
+	if _, ok := err.(*InvalidResultError); ok {
+		return events.BadMessage(err)
+	}
+	return err
+}
+
+// InvalidResultError is the error for a result that is rejected, as opposed to a result that could not be processed.
+type InvalidResultError struct {
+	Reason string
+	// true if the client is not allowed to submit the result
+	Forbidden bool
+}
+
+func (e *InvalidResultError) Error() string {
+	return e.Reason
+}
+
+func invalidResult(reason string) error {
+	return &InvalidResultError{Reason: reason}
+}
+
+// ProcessResult checks the result message data, and merges the result into its task.
+// The busID is the ID of the message assigned by the event bus, empty if the message did not come from the event bus.
+// Returns the key of the result, and if it was stored: a repeated result may be ignored, see Handler.Duplicates.
+// Returns an InvalidResultError if the result is rejected.
+func (h *Handler) ProcessResult(ctx context.Context, data []byte, busID string) (resultKey string, stored bool, err error) {
+	result, err := decodeResult(data)
+	if err != nil {
+		return "", false, err
+	}
+	task, err := h.checkResult(ctx, result, data)
+	if err != nil {
+		return "", false, err
+	}
+	return h.mergeResult(ctx, task, result.Entry(h.Now()), h.resultKey(result, busID))
+}
+
+func decodeResult(data []byte) (*model.ResultMsg, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var result model.ResultMsg
 	if err := dec.Decode(&result); err != nil {
-		return fmt.Errorf("could not decode result input: %v", err)
+		return nil, invalidResult(fmt.Sprintf("could not decode result input: %v", err))
+	}
+	return &result, nil
+}
+
+// checkResult checks the decoded result message, and the signature of the message data, and looks up the task of the result.
+func (h *Handler) checkResult(ctx context.Context, result *model.ResultMsg, data []byte) (*model.Task, error) {
+	if !model.RootRegex.Match([]byte(result.PostHash)) {
+		return nil, invalidResult("post hash has invalid format")
 	}
-	if !RootRegex.Match([]byte(result.PostHash)) {
-		return errors.New("post hash has invalid format")
+	if result.StateRoot != "" && !model.RootRegex.Match([]byte(result.StateRoot)) {
+		return nil, invalidResult("state root has invalid format")
+	}
+	if err := checkOutcome(result); err != nil {
+		return nil, err
 	}
 
 	if !VersionRegex.Match([]byte(result.ClientVersion)) {
-		return errors.New("client version is invalid")
+		return nil, invalidResult("client version is invalid")
+	}
+	if !h.CheckClient(result.ClientName) {
+		return nil, &InvalidResultError{Reason: "client name is invalid", Forbidden: true}
+	}
+	if h.Keys != nil {
+		if err := h.Keys.VerifyMessage(result.ClientName, result.KeyID, data, result.Signature, h.Now()); err != nil {
+			return nil, &InvalidResultError{
+				Reason:    fmt.Sprintf("result is not signed by client %s: %v", result.ClientName, err),
+				Forbidden: true,
+			}
+		}
+	}
+	if !model.KeyRegex.Match([]byte(result.Key)) {
+		return nil, invalidResult("task key is invalid")
 	}
-	if !CheckClient(result.ClientName) {
-		return errors.New("client name is invalid")
+	if result.WorkerID != "" && !IDRegex.Match([]byte(result.WorkerID)) {
+		return nil, invalidResult("worker id is invalid")
 	}
-	if !KeyRegex.Match([]byte(result.Key)) {
-		return errors.New("task key is invalid")
+	if result.MessageID != "" && !IDRegex.Match([]byte(result.MessageID)) {
+		return nil, invalidResult("message id is invalid")
 	}
 
-	// checks if the task key exists, and retrieves the task information
-	var task Task
+	// checks if the task key exists
+	var task *model.Task
 	{
-		ctx, _ := context.WithTimeout(ctx, time.Second*5)
-		taskDoc, err := fsTransitionsCollection.Doc(result.Key).Get(ctx)
-		if status.Code(err) == codes.NotFound || (err == nil && !taskDoc.Exists()) {
-			return errors.New("task does not exist, cannot process result")
+		ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+		defer cancel()
+		t, err := h.Tasks.GetTask(ctx, result.Key)
+		if err == tasks.ErrNotFound {
+			return nil, invalidResult("task does not exist, cannot process result")
 		}
 		if err != nil {
-			return fmt.Errorf("failed to lookup task: %v", err)
+			return nil, fmt.Errorf("failed to lookup task: %v", err)
 		}
-		if err := taskDoc.DataTo(&task); err != nil {
-			return fmt.Errorf("failed to parse task: %v", err)
+		task = t
+	}
+	if err := checkStateRoots(result, task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// checkOutcome checks that the outcome is known, and agrees with the success of the result.
+// Only results that were not a success can have an error.
+func checkOutcome(result *model.ResultMsg) error {
+	if result.Outcome != "" {
+		if !result.Outcome.Valid() {
+			return invalidResult(fmt.Sprintf("unknown outcome: %q", result.Outcome))
+		}
+		if result.Success && result.Outcome != model.OutcomeOK {
+			return invalidResult("a successful result must have outcome ok")
 		}
 	}
+	if result.Succeeded() && (result.ErrorCode != "" || result.ErrorMessage != "") {
+		return invalidResult("a successful result cannot have an error")
+	}
+	if result.ErrorCode != "" && !ErrorCodeRegex.Match([]byte(result.ErrorCode)) {
+		return invalidResult("error code is invalid")
+	}
+	if len(result.ErrorMessage) > maxErrorMessageLength {
+		return invalidResult(fmt.Sprintf("error message is too long, limit is %d bytes", maxErrorMessageLength))
+	}
+	return nil
+}
 
-	keyStr := uniqueID()
-	{
-		ctx, _ := context.WithTimeout(ctx, time.Second*5)
-		mergeData := map[string]interface{}{
-			"results": map[string]ResultEntry{
-				keyStr: {
-					Success:       result.Success,
-					Created:       time.Now(),
-					ClientName:    result.ClientName,
-					ClientVersion: result.ClientVersion,
-					PostHash:      result.PostHash,
-					Files: ResultFilesRef{
-						PostState: result.Files.PostState,
-						OutLog:    result.Files.OutLog,
-						ErrLog:    result.Files.ErrLog,
-					},
-				},
-			},
-			"workers-versioned": map[string]string{
-				result.ClientName: result.ClientVersion,
-			},
-			"workers": map[string]bool{
-				result.ClientName: true,
-			},
-		}
-		if !result.Success {
-			mergeData["has-fail"] = true
-		}
-		if _, err := fsTransitionsCollection.Doc(result.Key).Set(ctx, mergeData, firestore.MergeAll); err != nil {
-			return fmt.Errorf("failed to register result: %v", err)
+// the maximum number of slot state roots of a result, for tasks that do not know the slots of their inputs.
+const maxSlotStateRoots = 1 << 13
+
+// checkStateRoots checks the per-block and per-slot state roots of the result against the blocks and slots of the task.
+func checkStateRoots(result *model.ResultMsg, task *model.Task) error {
+	if n := len(result.BlockStateRoots); n > 0 {
+		if n != task.Blocks {
+			return invalidResult(fmt.Sprintf("expected %d block state roots, got %d", task.Blocks, n))
+		}
+		if result.StateRoot != "" && result.BlockStateRoots[n-1] != result.StateRoot {
+			return invalidResult("state root of the last block does not match the state root")
+		}
+	}
+	if n := len(result.SlotStateRoots); n > 0 {
+		// tasks uploaded before the inputs were summarized do not know the slots
+		if task.PreRoot != "" && len(task.BlockSlots) == task.Blocks && task.Blocks > 0 {
+			expected := task.BlockSlots[task.Blocks-1] - task.PreSlot
+			if int64(n) != expected {
+				return invalidResult(fmt.Sprintf("expected %d slot state roots, got %d", expected, n))
+			}
+		} else if n > maxSlotStateRoots {
+			return invalidResult(fmt.Sprintf("too many slot state roots, limit is %d", maxSlotStateRoots))
+		}
+	}
+	for _, roots := range [][]string{result.BlockStateRoots, result.SlotStateRoots} {
+		for _, root := range roots {
+			if !model.RootRegex.Match([]byte(root)) {
+				return invalidResult("intermediate state root has invalid format")
+			}
 		}
 	}
 	return nil
 }
 
+// resultKey decides the key of the result, before the duplicate policy is applied.
+// The ID of the message is chosen by the worker, or else assigned by the event bus.
+// A message that is delivered again by the event bus has the same ID.
+func (h *Handler) resultKey(result *model.ResultMsg, busID string) string {
+	messageID := result.MessageID
+	if messageID == "" {
+		messageID = busID
+	}
+	if messageID == "" {
+		return uniqueID()
+	}
+	return ResultKey(result.ClientName, result.ClientVersion, result.WorkerID, messageID)
+}
+
+// mergeResult merges the result into the task, see ProcessResult.
+// The store judges the result, against the expected post-state of the task when the result is merged.
+// If the result links a post-state file, the stored result is verified, see Handler.Verify.
+func (h *Handler) mergeResult(ctx context.Context, task *model.Task, entry *model.ResultEntry, keyStr string) (string, bool, error) {
+	var storedKey string
+	{
+		ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+		defer cancel()
+		k, err := h.Tasks.MergeResult(ctx, task.Key, keyStr, entry, h.Duplicates)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to register result: %v", err)
+		}
+		storedKey = k
+	}
+	if storedKey == "" {
+		log.Printf("ignored repeated result %s of task %s", keyStr, task.Key)
+		return keyStr, false, nil
+	}
+	if entry.Files.PostState != "" {
+		h.verify(ctx, task.Key, storedKey)
+	}
+	return storedKey, true, nil
+}
+
+// verify verifies the stored result inline, or publishes it to be verified in the background, see Handler.Verify.
+// The result is stored already, errors are logged.
+func (h *Handler) verify(ctx context.Context, taskKey string, resultKey string) {
+	switch h.Verify {
+	case VerifyInline:
+		ver, err := h.Verifier.VerifyResult(ctx, taskKey, resultKey)
+		if err != nil {
+			log.Printf("could not verify result %s of task %s: %v", resultKey, taskKey, err)
+		} else if ver.Status != model.VerificationOK {
+			log.Printf("result %s of task %s is not verified: %s: %s", resultKey, taskKey, ver.Status, ver.Detail)
+		}
+	case VerifyBackground:
+		data, err := json.Marshal(&model.VerifyMsg{Key: taskKey, ResultKey: resultKey})
+		if err != nil {
+			log.Printf("could not encode verify message: %v", err)
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+		defer cancel()
+		if _, err := h.Events.Publish(ctx, events.VerifyTopic, data); err != nil {
+			log.Printf("could not publish result %s of task %s to verify: %v", resultKey, taskKey, err)
+		}
+	}
+}
+
+// ResultKey derives the key of a result from its origin, so that a repeated result message gets the same key.
+// The key has the same format as a random result key: 32 bytes, base64 URL encoded.
+func ResultKey(clientName string, clientVersion string, workerID string, messageID string) string {
+	h := sha256.New()
+	for _, v := range []string{clientName, clientVersion, workerID, messageID} {
+		// length-prefixed, to not confuse the boundaries of the values
+		var l [8]byte
+		binary.BigEndian.PutUint64(l[:], uint64(len(v)))
+		h.Write(l[:])
+		h.Write([]byte(v))
+	}
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
 func uniqueID() string {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
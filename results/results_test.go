@@ -0,0 +1,207 @@
+package results
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/protolambda/muskoka-server/clientkeys"
+	"github.com/protolambda/muskoka-server/model"
+	"github.com/protolambda/muskoka-server/tasks"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResultKey(t *testing.T) {
+	base := ResultKey("zrnt", "v1", "w1", "m1")
+	cases := []struct {
+		name    string
+		key     string
+		sameKey bool
+	}{
+		{"same origin", ResultKey("zrnt", "v1", "w1", "m1"), true},
+		{"other client", ResultKey("prysm", "v1", "w1", "m1"), false},
+		{"other version", ResultKey("zrnt", "v2", "w1", "m1"), false},
+		{"other worker", ResultKey("zrnt", "v1", "w2", "m1"), false},
+		{"other message", ResultKey("zrnt", "v1", "w1", "m2"), false},
+		{"moved boundary", ResultKey("zrnt", "v1w", "1", "m1"), false},
+		{"no worker", ResultKey("zrnt", "v1", "", "w1m1"), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if !model.ResultKeyRegex.MatchString(c.key) {
+				t.Errorf("invalid result key %q", c.key)
+			}
+			if (c.key == base) != c.sameKey {
+				t.Errorf("got key %q for base key %q, expected the same key: %v", c.key, base, c.sameKey)
+			}
+		})
+	}
+}
+
+func TestHandlerResultKey(t *testing.T) {
+	h := NewHandler(nil)
+	withID := &model.ResultMsg{ClientName: "zrnt", ClientVersion: "v1", WorkerID: "w1", MessageID: "m1"}
+	withoutID := &model.ResultMsg{ClientName: "zrnt", ClientVersion: "v1", WorkerID: "w1"}
+	cases := []struct {
+		name string
+		a    string
+		b    string
+		same bool
+	}{
+		{"message id", h.resultKey(withID, ""), h.resultKey(withID, ""), true},
+		{"message id is preferred over the bus id", h.resultKey(withID, "1"), h.resultKey(withID, "2"), true},
+		{"repeated bus message", h.resultKey(withoutID, "1"), h.resultKey(withoutID, "1"), true},
+		{"other bus message", h.resultKey(withoutID, "1"), h.resultKey(withoutID, "2"), false},
+		{"no ids", h.resultKey(withoutID, ""), h.resultKey(withoutID, ""), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if (c.a == c.b) != c.same {
+				t.Errorf("got keys %q and %q, expected the same key: %v", c.a, c.b, c.same)
+			}
+		})
+	}
+}
+
+// testDir creates a temporary directory, which is removed after the test.
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "muskoka-results-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+// newTestHandler creates a handler with a task store in a temporary directory, with a task "a".
+// The store is closed after the test.
+func newTestHandler(t *testing.T) *Handler {
+	store, err := tasks.OpenBoltStore(filepath.Join(testDir(t), "tasks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	if err := store.CreateTask(context.Background(), "a", model.NewTask("v0.9.0", "minimal", 1, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(store)
+	h.CheckClient = func(name string) bool {
+		return name == "zrnt"
+	}
+	return h
+}
+
+func resultData(t *testing.T, msg *model.ResultMsg) []byte {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestProcessResultDuplicates(t *testing.T) {
+	cases := []struct {
+		name      string
+		policy    tasks.DuplicatePolicy
+		messageID string
+		busIDs    []string
+		stored    []bool
+		results   int
+	}{
+		{"keep first", tasks.KeepFirst, "m1", []string{"", ""}, []bool{true, false}, 1},
+		{"keep latest", tasks.KeepLatest, "m1", []string{"", ""}, []bool{true, true}, 1},
+		{"keep all", tasks.KeepAll, "m1", []string{"", ""}, []bool{true, true}, 2},
+		{"redelivered bus message", tasks.KeepFirst, "", []string{"1", "1"}, []bool{true, false}, 1},
+		{"other bus messages", tasks.KeepFirst, "", []string{"1", "2"}, []bool{true, true}, 2},
+		{"no ids", tasks.KeepFirst, "", []string{"", ""}, []bool{true, true}, 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			h.Duplicates = c.policy
+			data := resultData(t, &model.ResultMsg{
+				Success: true, PostHash: "0x" + strings.Repeat("ab", 32),
+				ClientName: "zrnt", ClientVersion: "v1", Key: "a", MessageID: c.messageID,
+			})
+			for i, busID := range c.busIDs {
+				_, stored, err := h.ProcessResult(context.Background(), data, busID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored != c.stored[i] {
+					t.Errorf("result %d: got stored %v, expected %v", i, stored, c.stored[i])
+				}
+			}
+			task, err := h.Tasks.GetTask(context.Background(), "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(task.Results) != c.results {
+				t.Errorf("got %d results, expected %d", len(task.Results), c.results)
+			}
+		})
+	}
+}
+
+func TestProcessResultInvalid(t *testing.T) {
+	h := newTestHandler(t)
+	root := "0x" + strings.Repeat("ab", 32)
+	valid := func() *model.ResultMsg {
+		return &model.ResultMsg{Success: true, PostHash: root, ClientName: "zrnt", ClientVersion: "v1", Key: "a"}
+	}
+	cases := []struct {
+		name      string
+		modify    func(msg *model.ResultMsg)
+		forbidden bool
+	}{
+		{"invalid post hash", func(msg *model.ResultMsg) { msg.PostHash = "0x01" }, false},
+		{"invalid state root", func(msg *model.ResultMsg) { msg.StateRoot = "abc" }, false},
+		{"unknown outcome", func(msg *model.ResultMsg) { msg.Outcome = "exploded" }, false},
+		{"success with other outcome", func(msg *model.ResultMsg) { msg.Outcome = model.OutcomeCrash }, false},
+		{"invalid client version", func(msg *model.ResultMsg) { msg.ClientVersion = "" }, false},
+		{"client not accepted", func(msg *model.ResultMsg) { msg.ClientName = "prysm" }, true},
+		{"invalid task key", func(msg *model.ResultMsg) { msg.Key = "a/b" }, false},
+		{"unknown task", func(msg *model.ResultMsg) { msg.Key = "b" }, false},
+		{"invalid message id", func(msg *model.ResultMsg) { msg.MessageID = "a b" }, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msg := valid()
+			c.modify(msg)
+			_, _, err := h.ProcessResult(context.Background(), resultData(t, msg), "")
+			invalid, ok := err.(*InvalidResultError)
+			if !ok {
+				t.Fatalf("got error %v, expected an invalid result", err)
+			}
+			if invalid.Forbidden != c.forbidden {
+				t.Errorf("got forbidden %v, expected %v", invalid.Forbidden, c.forbidden)
+			}
+		})
+	}
+}
+
+func TestCheckReplay(t *testing.T) {
+	cases := []struct {
+		name      string
+		keys      *clientkeys.Registry
+		messageID string
+		err       bool
+	}{
+		{"not signed", nil, "", false},
+		{"signed with message id", clientkeys.NewRegistry(), "m1", false},
+		{"signed without message id", clientkeys.NewRegistry(), "", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := NewHandler(nil)
+			h.Keys = c.keys
+			err := h.checkReplay(&model.ResultMsg{MessageID: c.messageID})
+			if (err != nil) != c.err {
+				t.Errorf("got error %v, expected error: %v", err, c.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package results
+
+import (
+	"cloud.google.com/go/pubsub"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/protolambda/muskoka-server/blobs"
+	"github.com/protolambda/muskoka-server/events"
+	"github.com/protolambda/muskoka-server/model"
+	"github.com/protolambda/muskoka-server/specs"
+	"github.com/protolambda/muskoka-server/tasks"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+)
+
+// VerifyMode decides if, and when, the post-state files of stored results are verified. See Verifier.
+type VerifyMode string
+
+const (
+	// results are not verified
+	VerifyOff VerifyMode = "off"
+	// results are verified before the result is acknowledged
+	VerifyInline VerifyMode = "inline"
+	// results are published to the verify topic, and verified by a consumer of the topic. See events.VerifyTopic.
+	VerifyBackground VerifyMode = "background"
+)
+
+// ParseVerifyMode parses the name of a verify mode. An empty name is VerifyOff.
+func ParseVerifyMode(name string) (VerifyMode, error) {
+	switch m := VerifyMode(name); m {
+	case "":
+		return VerifyOff, nil
+	case VerifyOff, VerifyInline, VerifyBackground:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown verify mode: %q", name)
+	}
+}
+
+// Verifier checks the post-state files of stored results against the post-hash and state root reported by the client,
+// and records the outcome on the result. See model.Verification.
+type Verifier struct {
+	// Tasks stores the results to verify.
+	Tasks tasks.Store
+	// Specs has the state type to compute the state root of the post-state with.
+	Specs *specs.Registry
+	// Sources are the stores the post-states are fetched from. Post-states in other places cannot be verified.
+	Sources blobs.Sources
+	// the size limit of post-states, in bytes. The post-state is held in memory.
+	MaxPostStateSize int64
+	// the time to register verifications with
+	Now func() time.Time
+}
+
+func NewVerifier(taskStore tasks.Store, sources blobs.Sources) *Verifier {
+	return &Verifier{
+		Tasks:            taskStore,
+		Specs:            specs.NewDefaultRegistry(),
+		Sources:          sources,
+		MaxPostStateSize: defaultMaxPostStateSize,
+		Now:              time.Now,
+	}
+}
+
+var defaultVerifier *Verifier
+var defaultVerifierOnce sync.Once
+
+// the verifier for the cloud function, with its dependencies configured by the environment.
+// Post-states are fetched from the buckets with result files, see blobs.ResultSourcesFromEnv.
+func getDefaultVerifier() *Verifier {
+	defaultVerifierOnce.Do(func() {
+		ctx := context.Background()
+		taskStore, err := tasks.FromEnv(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create task store: %v", err)
+		}
+		sources, err := blobs.ResultSourcesFromEnv(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create results stores: %v", err)
+		}
+		defaultVerifier = NewVerifier(taskStore, sources)
+	})
+	return defaultVerifier
+}
+
+// VerifyResults is the cloud function entry point to verify results in the background, consuming the verify topic.
+// A bad message is logged and not retried, other errors are returned to retry the message.
+func VerifyResults(ctx context.Context, m *pubsub.Message) error {
+	err := getDefaultVerifier().HandleVerify(ctx, &events.Message{ID: m.ID, Data: m.Data})
+	if events.IsBadMessage(err) {
+		log.Printf("dropping verify message %s: %v", m.ID, err)
+		return nil
+	}
+	return err
+}
+
+// HandleVerify verifies the result of a verify message received from the event bus. See model.VerifyMsg.
+// Messages that cannot be decoded, or that refer to an unknown task or result, are returned as bad messages.
+func (v *Verifier) HandleVerify(ctx context.Context, m *events.Message) error {
+	var msg model.VerifyMsg
+	if err := json.Unmarshal(m.Data, &msg); err != nil {
+		return events.BadMessage(fmt.Errorf("could not decode verify message: %v", err))
+	}
+	if !model.KeyRegex.Match([]byte(msg.Key)) || !model.ResultKeyRegex.Match([]byte(msg.ResultKey)) {
+		return events.BadMessage(errors.New("verify message has an invalid key"))
+	}
+	ver, err := v.VerifyResult(ctx, msg.Key, msg.ResultKey)
+	if err == tasks.ErrNotFound {
+		return events.BadMessage(fmt.Errorf("result %s of task %s does not exist", msg.ResultKey, msg.Key))
+	}
+	if err != nil {
+		return err
+	}
+	if ver.Status != model.VerificationOK {
+		log.Printf("result %s of task %s is not verified: %s: %s", msg.ResultKey, msg.Key, ver.Status, ver.Detail)
+	}
+	return nil
+}
+
+// the result changed while it was verified, the verification does not apply anymore.
+var errResultChanged = errors.New("result changed while it was verified")
+
+// VerifyResult verifies the post-state of a stored result, and records the verification on the result.
+// If the client did not report the state root, and the post-state matches the post-hash,
+// the computed state root is recorded as the state root of the result, and the result is judged again.
+// Returns tasks.ErrNotFound if the task or the result does not exist.
+func (v *Verifier) VerifyResult(ctx context.Context, taskKey string, resultKey string) (*model.Verification, error) {
+	task, err := v.getTask(ctx, taskKey)
+	if err != nil {
+		return nil, err
+	}
+	result, ok := task.Results[resultKey]
+	if !ok {
+		return nil, tasks.ErrNotFound
+	}
+	ver := v.Verify(ctx, task, &result)
+	{
+		ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+		defer cancel()
+		err = v.Tasks.UpdateResult(ctx, taskKey, resultKey, func(task *model.Task, current *model.ResultEntry) error {
+			// a repeated result may have replaced the result, see tasks.KeepLatest.
+			if current.Files.PostState != result.Files.PostState || current.PostHash != result.PostHash {
+				return errResultChanged
+			}
+			current.Verification = ver
+			if current.StateRoot == "" && ver.Status == model.VerificationOK && ver.StateRoot != "" {
+				current.StateRoot = ver.StateRoot
+				current.Verdict = task.Judge(current)
+			}
+			return nil
+		})
+	}
+	if err == errResultChanged {
+		log.Printf("result %s of task %s changed while it was verified, verification is not recorded", resultKey, taskKey)
+		return ver, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record verification: %v", err)
+	}
+	return ver, nil
+}
+
+func (v *Verifier) getTask(ctx context.Context, key string) (*model.Task, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	return v.Tasks.GetTask(ctx, key)
+}
+
+// Verify fetches the post-state of the result, and checks it against the post-hash and state root of the result.
+// The state root is computed with the state type of the spec of the task, if the spec is supported.
+func (v *Verifier) Verify(ctx context.Context, task *model.Task, result *model.ResultEntry) *model.Verification {
+	ver := &model.Verification{Time: v.Now()}
+	if result.Files.PostState == "" {
+		ver.Status = model.VerificationUnavailable
+		ver.Detail = "result has no post-state file"
+		return ver
+	}
+	data, err := v.fetchPostState(ctx, result.Files.PostState)
+	if err == blobs.ErrNotFound {
+		ver.Status = model.VerificationMissing
+		ver.Detail = "post-state file does not exist"
+		return ver
+	}
+	if err == blobs.ErrTooLarge {
+		ver.Status = model.VerificationInvalid
+		ver.Detail = fmt.Sprintf("post-state is too large to verify, limit is %d bytes", v.MaxPostStateSize)
+		return ver
+	}
+	if err != nil {
+		ver.Status = model.VerificationUnavailable
+		ver.Detail = fmt.Sprintf("could not fetch post-state: %v", err)
+		return ver
+	}
+	postHash := sha256.Sum256(data)
+	ver.PostHash = "0x" + hex.EncodeToString(postHash[:])
+
+	// tasks of specs that are not supported anymore can still be checked against the post-hash.
+	spec, err := v.Specs.Get(task.SpecVersion, task.SpecConfig)
+	if err == nil {
+		def, err := spec.Obj(specs.BeaconState)
+		if err != nil {
+			ver.Status = model.VerificationUnavailable
+			ver.Detail = fmt.Sprintf("spec has no state type: %v", err)
+			return ver
+		}
+		// the post-state of a result that was not a success does not have to be a valid state.
+		if obj, err := def.Decode(data); err == nil {
+			ver.StateRoot = def.HashTreeRoot(obj)
+		} else if result.Success {
+			ver.Status = model.VerificationInvalid
+			ver.Detail = fmt.Sprintf("post-state of successful result is not a valid state: %v", err)
+			return ver
+		}
+	}
+
+	if ver.PostHash != result.PostHash {
+		ver.Status = model.VerificationMismatch
+		ver.Detail = fmt.Sprintf("post-hash of the post-state is %s, result claims %s", ver.PostHash, result.PostHash)
+		return ver
+	}
+	if result.StateRoot != "" && ver.StateRoot != "" && ver.StateRoot != result.StateRoot {
+		ver.Status = model.VerificationMismatch
+		ver.Detail = fmt.Sprintf("state root of the post-state is %s, result claims %s", ver.StateRoot, result.StateRoot)
+		return ver
+	}
+	ver.Status = model.VerificationOK
+	return ver
+}
+
+// fetchPostState reads the post-state from the store its URL refers to.
+func (v *Verifier) fetchPostState(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+	r, err := v.Sources.GetLimited(ctx, url, v.MaxPostStateSize)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,177 @@
+package results
+
+import (
+	"bytes"
+	"context"
+	"github.com/protolambda/muskoka-server/blobs"
+	"github.com/protolambda/muskoka-server/model"
+	"github.com/protolambda/muskoka-server/specs"
+	"github.com/protolambda/muskoka-server/tasks"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestVerifier creates a verifier of the results of task "a" of newTestHandler,
+// that fetches post-states from a store in a temporary directory, as "outputs://<key>".
+func newTestVerifier(t *testing.T) (*Verifier, blobs.Store) {
+	h := newTestHandler(t)
+	outputs, err := blobs.NewLocalStore(testDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewVerifier(h.Tasks, blobs.Sources{{URLPrefix: "outputs://", Store: outputs}})
+	return v, outputs
+}
+
+func putFile(t *testing.T, store blobs.Store, key string, data []byte) {
+	if err := store.Put(context.Background(), key, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// stateRootOf computes the state root of a v0.9.0 minimal state.
+func stateRootOf(t *testing.T, data []byte) string {
+	spec, err := specs.NewDefaultRegistry().Get("v0.9.0", "minimal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := spec.Obj(specs.BeaconState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := def.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return def.HashTreeRoot(obj)
+}
+
+func TestVerify(t *testing.T) {
+	v, outputs := newTestVerifier(t)
+	state := encodeState(t, 3)
+	junk := []byte("not a state")
+	putFile(t, outputs, "state.ssz", state)
+	putFile(t, outputs, "junk.ssz", junk)
+	root := stateRootOf(t, state)
+	otherRoot := "0x" + strings.Repeat("ab", 32)
+	task := model.NewTask("v0.9.0", "minimal", 1, time.Now())
+	cases := []struct {
+		name   string
+		result model.ResultEntry
+		status model.VerificationStatus
+		// the expected computed state root
+		stateRoot string
+	}{
+		{"ok", model.ResultEntry{Success: true, PostHash: postHashOf(state), StateRoot: root,
+			Files: model.ResultFilesRef{PostState: "outputs://state.ssz"}}, model.VerificationOK, root},
+		{"ok without state root", model.ResultEntry{Success: true, PostHash: postHashOf(state),
+			Files: model.ResultFilesRef{PostState: "outputs://state.ssz"}}, model.VerificationOK, root},
+		{"post-hash mismatch", model.ResultEntry{Success: true, PostHash: postHashOf(junk),
+			Files: model.ResultFilesRef{PostState: "outputs://state.ssz"}}, model.VerificationMismatch, root},
+		{"state root mismatch", model.ResultEntry{Success: true, PostHash: postHashOf(state), StateRoot: otherRoot,
+			Files: model.ResultFilesRef{PostState: "outputs://state.ssz"}}, model.VerificationMismatch, root},
+		{"missing file", model.ResultEntry{Success: true, PostHash: postHashOf(state),
+			Files: model.ResultFilesRef{PostState: "outputs://missing.ssz"}}, model.VerificationMissing, ""},
+		{"no post-state file", model.ResultEntry{Success: true, PostHash: postHashOf(state)}, model.VerificationUnavailable, ""},
+		{"unknown store", model.ResultEntry{Success: true, PostHash: postHashOf(state),
+			Files: model.ResultFilesRef{PostState: "https://example.com/state.ssz"}}, model.VerificationUnavailable, ""},
+		{"invalid state of a successful result", model.ResultEntry{Success: true, PostHash: postHashOf(junk),
+			Files: model.ResultFilesRef{PostState: "outputs://junk.ssz"}}, model.VerificationInvalid, ""},
+		{"invalid state of a failed result", model.ResultEntry{Outcome: model.OutcomeCrash, PostHash: postHashOf(junk),
+			Files: model.ResultFilesRef{PostState: "outputs://junk.ssz"}}, model.VerificationOK, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ver := v.Verify(context.Background(), task, &c.result)
+			if ver.Status != c.status {
+				t.Errorf("got status %s (%s), expected %s", ver.Status, ver.Detail, c.status)
+			}
+			if ver.StateRoot != c.stateRoot {
+				t.Errorf("got state root %q, expected %q", ver.StateRoot, c.stateRoot)
+			}
+		})
+	}
+}
+
+func TestVerifyResult(t *testing.T) {
+	v, outputs := newTestVerifier(t)
+	ctx := context.Background()
+	state := encodeState(t, 3)
+	putFile(t, outputs, "state.ssz", state)
+	root := stateRootOf(t, state)
+	if err := v.Tasks.SetExpectedPostRoot(ctx, "a", root); err != nil {
+		t.Fatal(err)
+	}
+	// the client did not report a state root, the result cannot be judged yet
+	result := &model.ResultEntry{Success: true, Outcome: model.OutcomeOK, ClientName: "zrnt", PostHash: postHashOf(state),
+		Files: model.ResultFilesRef{PostState: "outputs://state.ssz"}}
+	if _, err := v.Tasks.MergeResult(ctx, "a", "r", result, tasks.KeepFirst); err != nil {
+		t.Fatal(err)
+	}
+	ver, err := v.VerifyResult(ctx, "a", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ver.Status != model.VerificationOK {
+		t.Fatalf("got status %s: %s", ver.Status, ver.Detail)
+	}
+	task, err := v.Tasks.GetTask(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := task.Results["r"]
+	if stored.Verification == nil || stored.Verification.Status != model.VerificationOK {
+		t.Errorf("verification was not recorded: %+v", stored.Verification)
+	}
+	if stored.StateRoot != root || stored.Verdict != model.VerdictCorrect {
+		t.Errorf("got state root %q and verdict %q, expected the computed state root, and a correct verdict", stored.StateRoot, stored.Verdict)
+	}
+
+	if _, err := v.VerifyResult(ctx, "a", "unknown"); err != tasks.ErrNotFound {
+		t.Errorf("got error %v for an unknown result, expected ErrNotFound", err)
+	}
+}
+
+// replacingTasks replaces the result before it is updated, like a repeated result would under tasks.KeepLatest.
+type replacingTasks struct {
+	tasks.Store
+	replacement *model.ResultEntry
+}
+
+func (s *replacingTasks) UpdateResult(ctx context.Context, key string, resultKey string, update func(task *model.Task, result *model.ResultEntry) error) error {
+	if _, err := s.Store.MergeResult(ctx, key, resultKey, s.replacement, tasks.KeepLatest); err != nil {
+		return err
+	}
+	return s.Store.UpdateResult(ctx, key, resultKey, update)
+}
+
+func TestVerifyResultChanged(t *testing.T) {
+	v, outputs := newTestVerifier(t)
+	ctx := context.Background()
+	state := encodeState(t, 3)
+	putFile(t, outputs, "state.ssz", state)
+	result := &model.ResultEntry{Success: true, Outcome: model.OutcomeOK, ClientName: "zrnt", PostHash: postHashOf(state),
+		Files: model.ResultFilesRef{PostState: "outputs://state.ssz"}}
+	if _, err := v.Tasks.MergeResult(ctx, "a", "r", result, tasks.KeepFirst); err != nil {
+		t.Fatal(err)
+	}
+	replacement := &model.ResultEntry{Success: true, Outcome: model.OutcomeOK, ClientName: "zrnt", PostHash: postHashOf([]byte("other")),
+		Files: model.ResultFilesRef{PostState: "outputs://other.ssz"}}
+	store := v.Tasks
+	v.Tasks = &replacingTasks{Store: store, replacement: replacement}
+	ver, err := v.VerifyResult(ctx, "a", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ver.Status != model.VerificationOK {
+		t.Errorf("got status %s: %s, expected the verification of the replaced result", ver.Status, ver.Detail)
+	}
+	task, err := store.GetTask(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := task.Results["r"]; stored.Verification != nil || stored.PostHash != replacement.PostHash {
+		t.Errorf("verification of the replaced result was recorded on the new result: %+v", stored)
+	}
+}
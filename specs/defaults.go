@@ -0,0 +1,53 @@
+package specs
+
+import (
+	"github.com/protolambda/zssz-spec-history/mainnet_v0_8_4"
+	"github.com/protolambda/zssz-spec-history/mainnet_v0_9_0"
+	"github.com/protolambda/zssz-spec-history/minimal_v0_8_4"
+	"github.com/protolambda/zssz-spec-history/minimal_v0_9_0"
+)
+
+const defaultMaxBlocks = 16
+
+// DefaultSpecs returns the specs supported by this server.
+// Spec versions without changes to the SSZ types re-use the types of the previous version.
+func DefaultSpecs() []*Spec {
+	return []*Spec{
+		// v0.8.3 is the same as v0.8.4
+		{Version: "v0.8.3", Config: "minimal", MaxBlocks: defaultMaxBlocks, Objects: map[ObjType]*ObjDef{
+			BeaconState: {"BeaconState", minimal_v0_8_4.BeaconStateSSZ, func() interface{} { return new(minimal_v0_8_4.BeaconState) }},
+			BeaconBlock: {"BeaconBlock", minimal_v0_8_4.BeaconBlockSSZ, func() interface{} { return new(minimal_v0_8_4.BeaconBlock) }},
+		}},
+		{Version: "v0.8.3", Config: "mainnet", MaxBlocks: defaultMaxBlocks, Objects: map[ObjType]*ObjDef{
+			BeaconState: {"BeaconState", mainnet_v0_8_4.BeaconStateSSZ, func() interface{} { return new(mainnet_v0_8_4.BeaconState) }},
+			BeaconBlock: {"BeaconBlock", mainnet_v0_8_4.BeaconBlockSSZ, func() interface{} { return new(mainnet_v0_8_4.BeaconBlock) }},
+		}},
+		{Version: "v0.8.4", Config: "minimal", MaxBlocks: defaultMaxBlocks, Objects: map[ObjType]*ObjDef{
+			BeaconState: {"BeaconState", minimal_v0_8_4.BeaconStateSSZ, func() interface{} { return new(minimal_v0_8_4.BeaconState) }},
+			BeaconBlock: {"BeaconBlock", minimal_v0_8_4.BeaconBlockSSZ, func() interface{} { return new(minimal_v0_8_4.BeaconBlock) }},
+		}},
+		{Version: "v0.8.4", Config: "mainnet", MaxBlocks: defaultMaxBlocks, Objects: map[ObjType]*ObjDef{
+			BeaconState: {"BeaconState", mainnet_v0_8_4.BeaconStateSSZ, func() interface{} { return new(mainnet_v0_8_4.BeaconState) }},
+			BeaconBlock: {"BeaconBlock", mainnet_v0_8_4.BeaconBlockSSZ, func() interface{} { return new(mainnet_v0_8_4.BeaconBlock) }},
+		}},
+		{Version: "v0.9.0", Config: "minimal", MaxBlocks: defaultMaxBlocks, Objects: map[ObjType]*ObjDef{
+			BeaconState: {"BeaconState", minimal_v0_9_0.BeaconStateSSZ, func() interface{} { return new(minimal_v0_9_0.BeaconState) }},
+			BeaconBlock: {"BeaconBlock", minimal_v0_9_0.BeaconBlockSSZ, func() interface{} { return new(minimal_v0_9_0.BeaconBlock) }},
+		}},
+		{Version: "v0.9.0", Config: "mainnet", MaxBlocks: defaultMaxBlocks, Objects: map[ObjType]*ObjDef{
+			BeaconState: {"BeaconState", mainnet_v0_9_0.BeaconStateSSZ, func() interface{} { return new(mainnet_v0_9_0.BeaconState) }},
+			BeaconBlock: {"BeaconBlock", mainnet_v0_9_0.BeaconBlockSSZ, func() interface{} { return new(mainnet_v0_9_0.BeaconBlock) }},
+		}},
+	}
+}
+
+// NewDefaultRegistry creates a registry with the DefaultSpecs.
+func NewDefaultRegistry() *Registry {
+	r := NewRegistry()
+	for _, spec := range DefaultSpecs() {
+		if err := r.Register(spec); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
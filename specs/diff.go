@@ -0,0 +1,186 @@
+package specs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/protolambda/zssz"
+	"github.com/protolambda/zssz/types"
+	"github.com/protolambda/zssz/util/tags"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// DiffKind is the kind of a difference between two objects.
+type DiffKind string
+
+const (
+	// the values of a field or element differ
+	DiffValue DiffKind = "value"
+	// the lengths of a list differ. The elements beyond the shorter list are not compared.
+	DiffLength DiffKind = "length"
+)
+
+// FieldDiff is a difference between two objects of the same type.
+type FieldDiff struct {
+	// the path of the field or element, e.g. "validators[12].effective_balance". Field names are in snake case.
+	Path string   `json:"path"`
+	Kind DiffKind `json:"kind"`
+	// the values in each object: a number or bool for basic values, 0x-prefixed hex for bytes and bitfields,
+	// and the length for a length difference.
+	A interface{} `json:"a"`
+	B interface{} `json:"b"`
+}
+
+// Diff lists the fields and list elements that differ between two objects of the type, as decoded with Decode.
+// Subtrees with equal hash tree roots are not compared any further, so similar objects are compared quickly.
+// At most limit differences are listed, truncated is true if there are more.
+func (d *ObjDef) Diff(a interface{}, b interface{}, limit int) (diffs []FieldDiff, truncated bool) {
+	df := &differ{types: make(map[reflect.Type]types.SSZ), limit: limit}
+	df.diff("", reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem())
+	return df.out, df.truncated
+}
+
+type differ struct {
+	// SSZ types by Go type, to compute the hash tree roots of subtrees
+	types     map[reflect.Type]types.SSZ
+	limit     int
+	out       []FieldDiff
+	truncated bool
+}
+
+func (df *differ) add(diff FieldDiff) {
+	if len(df.out) >= df.limit {
+		df.truncated = true
+		return
+	}
+	df.out = append(df.out, diff)
+}
+
+// root computes the hash tree root of an addressable value.
+func (df *differ) root(v reflect.Value) [32]byte {
+	typ := v.Type()
+	sszTyp, ok := df.types[typ]
+	if !ok {
+		// the values were decoded with the SSZ type of the object, the types of their parts are known to be valid.
+		t, err := types.SSZFactory(typ)
+		if err != nil {
+			panic(fmt.Sprintf("no SSZ type for decoded value of type %s: %v", typ, err))
+		}
+		sszTyp = t
+		df.types[typ] = sszTyp
+	}
+	return zssz.HashTreeRoot(sha256.Sum256, v.Addr().Interface(), sszTyp)
+}
+
+func (df *differ) diff(path string, a reflect.Value, b reflect.Value) {
+	if df.truncated {
+		return
+	}
+	switch a.Kind() {
+	case reflect.Bool, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if basicValue(a) != basicValue(b) {
+			df.add(FieldDiff{Path: path, Kind: DiffValue, A: basicValue(a), B: basicValue(b)})
+		}
+		return
+	case reflect.Ptr:
+		df.diff(path, a.Elem(), b.Elem())
+		return
+	}
+	if df.root(a) == df.root(b) {
+		return
+	}
+	switch a.Kind() {
+	case reflect.Struct:
+		df.diffFields(path, a, b)
+	case reflect.Array, reflect.Slice:
+		elemKind := a.Type().Elem().Kind()
+		// bytes and bitfields are compared as a whole
+		if elemKind == reflect.Uint8 {
+			df.add(FieldDiff{Path: path, Kind: DiffValue, A: bytesValue(a), B: bytesValue(b)})
+			return
+		}
+		n := a.Len()
+		if b.Len() != n {
+			df.add(FieldDiff{Path: path, Kind: DiffLength, A: a.Len(), B: b.Len()})
+			if b.Len() < n {
+				n = b.Len()
+			}
+		}
+		for i := 0; i < n; i++ {
+			df.diff(fmt.Sprintf("%s[%d]", path, i), a.Index(i), b.Index(i))
+		}
+	default:
+		panic(fmt.Sprintf("cannot diff value of type %s", a.Type()))
+	}
+}
+
+// diffFields compares the fields of two structs, as the fields of an SSZ container.
+func (df *differ) diffFields(path string, a reflect.Value, b reflect.Value) {
+	for _, f := range containerFields(a.Type()) {
+		fieldPath := f.name
+		if path != "" {
+			fieldPath = path + "." + fieldPath
+		}
+		df.diff(fieldPath, a.FieldByIndex(f.index), b.FieldByIndex(f.index))
+	}
+}
+
+// containerField is a field of an SSZ container.
+type containerField struct {
+	// the name of the field, in snake case
+	name string
+	// the index of the Go struct field, see reflect.Value.FieldByIndex. Squashed fields are nested.
+	index []int
+}
+
+// containerFields lists the fields of the SSZ container of a struct type, like zssz types.NewSSZContainer:
+// omitted fields are skipped, and the fields of squashed structs are fields of the container itself.
+func containerFields(typ reflect.Type) []containerField {
+	var out []containerField
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if tags.HasFlag(&f, types.SSZ_TAG, types.OMIT_FLAG) {
+			continue
+		}
+		if (f.Anonymous || tags.HasFlag(&f, types.SSZ_TAG, types.SQUASH_FLAG)) && f.Type.Kind() == reflect.Struct {
+			for _, sq := range containerFields(f.Type) {
+				out = append(out, containerField{name: sq.name, index: append([]int{i}, sq.index...)})
+			}
+			continue
+		}
+		out = append(out, containerField{name: snakeCase(f.Name), index: []int{i}})
+	}
+	return out
+}
+
+func basicValue(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Bool {
+		return v.Bool()
+	}
+	return v.Uint()
+}
+
+func bytesValue(v reflect.Value) string {
+	b := make([]byte, v.Len())
+	reflect.Copy(reflect.ValueOf(b), v)
+	return "0x" + hex.EncodeToString(b)
+}
+
+// snakeCase converts a Go field name to the snake case name of the spec, e.g. "Eth1DepositIndex" to "eth1_deposit_index".
+func snakeCase(name string) string {
+	var out strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// a new word starts after a lower case letter or digit, or at the last letter of an acronym
+			if i > 0 && (!unicode.IsUpper(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				out.WriteRune('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
@@ -0,0 +1,160 @@
+package specs
+
+import (
+	"github.com/protolambda/zssz-spec-history/minimal_v0_9_0"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	spec, err := NewDefaultRegistry().Get("v0.9.0", "minimal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := spec.Obj(BeaconState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validators := func(n int) minimal_v0_9_0.Validators {
+		out := make(minimal_v0_9_0.Validators, n)
+		for i := range out {
+			out[i].EffectiveBalance = 32
+		}
+		return out
+	}
+	cases := []struct {
+		name      string
+		modify    func(a *minimal_v0_9_0.BeaconState, b *minimal_v0_9_0.BeaconState)
+		limit     int
+		want      []FieldDiff
+		truncated bool
+	}{
+		{
+			name:   "equal",
+			modify: func(a *minimal_v0_9_0.BeaconState, b *minimal_v0_9_0.BeaconState) {},
+			limit:  10,
+		},
+		{
+			name: "basic field",
+			modify: func(a *minimal_v0_9_0.BeaconState, b *minimal_v0_9_0.BeaconState) {
+				a.Slot, b.Slot = 1, 2
+			},
+			limit: 10,
+			want:  []FieldDiff{{Path: "slot", Kind: DiffValue, A: uint64(1), B: uint64(2)}},
+		},
+		{
+			name: "nested field",
+			modify: func(a *minimal_v0_9_0.BeaconState, b *minimal_v0_9_0.BeaconState) {
+				b.FinalizedCheckpoint.Epoch = 3
+			},
+			limit: 10,
+			want:  []FieldDiff{{Path: "finalized_checkpoint.epoch", Kind: DiffValue, A: uint64(0), B: uint64(3)}},
+		},
+		{
+			name: "bytes",
+			modify: func(a *minimal_v0_9_0.BeaconState, b *minimal_v0_9_0.BeaconState) {
+				b.Fork.CurrentVersion[0] = 1
+			},
+			limit: 10,
+			want:  []FieldDiff{{Path: "fork.current_version", Kind: DiffValue, A: "0x00000000", B: "0x01000000"}},
+		},
+		{
+			name: "vector of bytes",
+			modify: func(a *minimal_v0_9_0.BeaconState, b *minimal_v0_9_0.BeaconState) {
+				b.RandaoMixes[3][31] = 0xff
+			},
+			limit: 10,
+			want: []FieldDiff{{
+				Path: "randao_mixes[3]", Kind: DiffValue,
+				A: "0x" + strings.Repeat("00", 32), B: "0x" + strings.Repeat("00", 31) + "ff",
+			}},
+		},
+		{
+			name: "list length",
+			modify: func(a *minimal_v0_9_0.BeaconState, b *minimal_v0_9_0.BeaconState) {
+				a.Balances = minimal_v0_9_0.ValidatorBalances{1, 2}
+				b.Balances = minimal_v0_9_0.ValidatorBalances{1, 3, 4}
+			},
+			limit: 10,
+			want: []FieldDiff{
+				{Path: "balances", Kind: DiffLength, A: 2, B: 3},
+				{Path: "balances[1]", Kind: DiffValue, A: uint64(2), B: uint64(3)},
+			},
+		},
+		{
+			name: "list elements",
+			modify: func(a *minimal_v0_9_0.BeaconState, b *minimal_v0_9_0.BeaconState) {
+				a.Validators, b.Validators = validators(3), validators(3)
+				b.Validators[0].Slashed = true
+				b.Validators[2].EffectiveBalance = 31
+			},
+			limit: 10,
+			want: []FieldDiff{
+				{Path: "validators[0].slashed", Kind: DiffValue, A: false, B: true},
+				{Path: "validators[2].effective_balance", Kind: DiffValue, A: uint64(32), B: uint64(31)},
+			},
+		},
+		{
+			name: "truncated",
+			modify: func(a *minimal_v0_9_0.BeaconState, b *minimal_v0_9_0.BeaconState) {
+				b.GenesisTime = 1
+				b.Slot = 2
+				b.Eth1DepositIndex = 3
+			},
+			limit: 2,
+			want: []FieldDiff{
+				{Path: "genesis_time", Kind: DiffValue, A: uint64(0), B: uint64(1)},
+				{Path: "slot", Kind: DiffValue, A: uint64(0), B: uint64(2)},
+			},
+			truncated: true,
+		},
+		{
+			name: "exactly the limit",
+			modify: func(a *minimal_v0_9_0.BeaconState, b *minimal_v0_9_0.BeaconState) {
+				b.GenesisTime = 1
+				b.Eth1DepositIndex = 3
+			},
+			limit: 2,
+			want: []FieldDiff{
+				{Path: "genesis_time", Kind: DiffValue, A: uint64(0), B: uint64(1)},
+				{Path: "eth1_deposit_index", Kind: DiffValue, A: uint64(0), B: uint64(3)},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := def.Alloc().(*minimal_v0_9_0.BeaconState)
+			b := def.Alloc().(*minimal_v0_9_0.BeaconState)
+			c.modify(a, b)
+			diffs, truncated := def.Diff(a, b, c.limit)
+			if !reflect.DeepEqual(diffs, c.want) {
+				t.Errorf("got diffs %+v, expected %+v", diffs, c.want)
+			}
+			if truncated != c.truncated {
+				t.Errorf("got truncated %v, expected %v", truncated, c.truncated)
+			}
+		})
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	cases := []struct {
+		name string
+		want string
+	}{
+		{"Slot", "slot"},
+		{"GenesisTime", "genesis_time"},
+		{"Eth1DepositIndex", "eth1_deposit_index"},
+		{"Eth1Data", "eth1_data"},
+		{"BLSPubkey", "bls_pubkey"},
+		{"ParentRoot", "parent_root"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := snakeCase(c.name); got != c.want {
+				t.Errorf("got %q, expected %q", got, c.want)
+			}
+		})
+	}
+}
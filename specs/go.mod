@@ -0,0 +1,10 @@
+module github.com/protolambda/muskoka-server/specs
+
+go 1.11
+
+require (
+	github.com/protolambda/httphelpers v0.2.0
+	github.com/protolambda/zssz v0.1.4
+	github.com/protolambda/zssz-spec-history v0.1.0
+	gopkg.in/yaml.v2 v2.2.2
+)
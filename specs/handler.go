@@ -0,0 +1,76 @@
+package specs
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/protolambda/httphelpers/codes"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Handler serves the registered specs, for clients to choose from.
+type Handler struct {
+	Specs *Registry
+}
+
+func NewHandler(specs *Registry) *Handler {
+	return &Handler{Specs: specs}
+}
+
+var defaultHandler *Handler
+var defaultHandlerOnce sync.Once
+
+// the handler for the cloud function, serving the default specs.
+func getDefaultHandler() *Handler {
+	defaultHandlerOnce.Do(func() {
+		defaultHandler = NewHandler(NewDefaultRegistry())
+	})
+	return defaultHandler
+}
+
+// Specs is the cloud function entry point, see Handler.
+func Specs(w http.ResponseWriter, r *http.Request) {
+	getDefaultHandler().ServeHTTP(w, r)
+}
+
+type SpecInfo struct {
+	Version   string `json:"version"`
+	Config    string `json:"config"`
+	MaxBlocks int    `json:"max-blocks"`
+	// object type -> name of the SSZ type in the spec
+	Objects map[ObjType]string `json:"objects"`
+}
+
+type SpecsResult struct {
+	Specs []SpecInfo `json:"specs"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	list := h.Specs.List()
+	res := SpecsResult{Specs: make([]SpecInfo, 0, len(list))}
+	for _, spec := range list {
+		info := SpecInfo{
+			Version:   spec.Version,
+			Config:    spec.Config,
+			MaxBlocks: spec.MaxBlocks,
+			Objects:   make(map[ObjType]string, len(spec.Objects)),
+		}
+		for t, def := range spec.Objects {
+			info.Objects[t] = def.Name
+		}
+		res.Specs = append(res.Specs, info)
+	}
+	// encoded before anything is written, so that an encoding error can still be reported
+	var buf bytes.Buffer
+	if SERVER_ERR.Check(w, json.NewEncoder(&buf).Encode(&res), "could not encode specs") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	// specs only change with a deploy of the server
+	w.Header().Set("Cache-Control", "max-age=300") // 5 minutes
+	w.WriteHeader(int(SERVER_OK))
+	if _, err := buf.WriteTo(w); err != nil {
+		log.Printf("failed to write specs response: %v", err)
+	}
+}
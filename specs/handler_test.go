@@ -0,0 +1,35 @@
+package specs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewHandler(NewDefaultRegistry()).ServeHTTP(rec, httptest.NewRequest("GET", "/specs", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("got content type %q, expected application/json", got)
+	}
+	var res SpecsResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, info := range res.Specs {
+		if info.Version == "v0.9.0" && info.Config == "minimal" {
+			found = true
+			if info.MaxBlocks <= 0 || info.Objects[BeaconState] == "" || info.Objects[BeaconBlock] == "" {
+				t.Errorf("got spec info %+v, expected the max blocks, and the state and block types", info)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("got specs %+v, expected v0.9.0 minimal", res.Specs)
+	}
+}
@@ -0,0 +1,29 @@
+package specs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/protolambda/zssz"
+	"io"
+)
+
+// Decode decodes the SSZ data as an object of the type, and checks it. Returns a pointer to the object.
+func (d *ObjDef) Decode(data []byte) (interface{}, error) {
+	return d.DecodeFrom(bytes.NewReader(data), uint64(len(data)))
+}
+
+// DecodeFrom decodes size bytes of SSZ data from the reader, like Decode, without holding the encoded data in memory.
+func (d *ObjDef) DecodeFrom(r io.Reader, size uint64) (interface{}, error) {
+	obj := d.Alloc()
+	if err := zssz.Decode(r, size, obj, d.SSZ); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// HashTreeRoot computes the hash tree root of an object of the type, as 0x-prefixed hex.
+func (d *ObjDef) HashTreeRoot(obj interface{}) string {
+	root := zssz.HashTreeRoot(sha256.Sum256, obj, d.SSZ)
+	return "0x" + hex.EncodeToString(root[:])
+}
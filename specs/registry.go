@@ -0,0 +1,122 @@
+package specs
+
+import (
+	"errors"
+	"fmt"
+	"github.com/protolambda/zssz/types"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+var ErrUnknownSpec = errors.New("unknown spec version and config")
+
+// ObjType is the role of an object in a transition.
+// The name of the SSZ type of the object may differ between spec versions, see ObjDef.
+type ObjType string
+
+const (
+	BeaconState ObjType = "state"
+	BeaconBlock ObjType = "block"
+)
+
+// ObjDef describes the SSZ type of an object, for a given spec version and config.
+type ObjDef struct {
+	// Name is the name of the type in the spec, e.g. "BeaconBlock"
+	Name string
+	SSZ  types.SSZ
+	// Alloc creates a new zeroed object (a pointer) to decode into
+	Alloc func() interface{}
+}
+
+// Spec is a spec version with a config, as supported for transitions.
+type Spec struct {
+	Version string
+	Config  string
+	// maximum number of blocks in a single transition task
+	MaxBlocks int
+	Objects   map[ObjType]*ObjDef
+}
+
+// Obj gets the definition of the object type. Returns an error if the spec does not define the object type.
+func (s *Spec) Obj(t ObjType) (*ObjDef, error) {
+	def, ok := s.Objects[t]
+	if !ok {
+		return nil, fmt.Errorf("spec %s %s does not define object type %s", s.Version, s.Config, t)
+	}
+	return def, nil
+}
+
+// versions and configs are part of storage paths and topic names.
+var VersionRegex, _ = regexp.Compile("^v[0-9][-_.0-9a-zA-Z]{0,8}$")
+
+var ConfigRegex, _ = regexp.Compile("^[0-9a-zA-Z][-_0-9a-zA-Z]{0,99}$")
+
+type specKey struct {
+	version string
+	config  string
+}
+
+// Registry keeps track of the supported specs. It is safe for concurrent use.
+type Registry struct {
+	mu    sync.RWMutex
+	specs map[specKey]*Spec
+}
+
+func NewRegistry() *Registry {
+	return &Registry{specs: make(map[specKey]*Spec)}
+}
+
+// Register adds a spec to the registry. Returns an error if the spec is invalid, or already registered.
+func (r *Registry) Register(spec *Spec) error {
+	if !VersionRegex.MatchString(spec.Version) {
+		return fmt.Errorf("invalid spec version: %q", spec.Version)
+	}
+	if !ConfigRegex.MatchString(spec.Config) {
+		return fmt.Errorf("invalid spec config: %q", spec.Config)
+	}
+	if spec.MaxBlocks <= 0 {
+		return fmt.Errorf("spec %s %s must allow at least one block", spec.Version, spec.Config)
+	}
+	for _, t := range []ObjType{BeaconState, BeaconBlock} {
+		if _, err := spec.Obj(t); err != nil {
+			return err
+		}
+	}
+	k := specKey{version: spec.Version, config: spec.Config}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.specs[k]; ok {
+		return fmt.Errorf("spec %s %s is already registered", spec.Version, spec.Config)
+	}
+	r.specs[k] = spec
+	return nil
+}
+
+// Get looks up a spec. Returns ErrUnknownSpec if the spec is not registered.
+func (r *Registry) Get(version string, config string) (*Spec, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	spec, ok := r.specs[specKey{version: version, config: config}]
+	if !ok {
+		return nil, ErrUnknownSpec
+	}
+	return spec, nil
+}
+
+// List returns all registered specs, sorted by version, then config.
+func (r *Registry) List() []*Spec {
+	r.mu.RLock()
+	out := make([]*Spec, 0, len(r.specs))
+	for _, spec := range r.specs {
+		out = append(out, spec)
+	}
+	r.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Version != out[j].Version {
+			return out[i].Version < out[j].Version
+		}
+		return out[i].Config < out[j].Config
+	})
+	return out
+}
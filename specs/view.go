@@ -0,0 +1,136 @@
+package specs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Fields are the fields of a container, in order. Encoded as a JSON object or YAML mapping with the fields in order.
+type Fields []Field
+
+type Field struct {
+	Name  string
+	Value interface{}
+}
+
+func (fs Fields) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range fs {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (fs Fields) MarshalYAML() (interface{}, error) {
+	out := make(yaml.MapSlice, len(fs))
+	for i, f := range fs {
+		out[i] = yaml.MapItem{Key: f.Name, Value: f.Value}
+	}
+	return out, nil
+}
+
+// Uint64 is a uint64 value of a view. JSON numbers are not precise beyond 2^53 in most decoders,
+// and states have larger values, like the far future epoch 2^64-1, so it is encoded as a decimal string in JSON.
+// YAML encodes it as a number.
+type Uint64 uint64
+
+func (v Uint64) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + strconv.FormatUint(uint64(v), 10) + `"`), nil
+}
+
+// View converts a decoded object, or the part of it at the path, to plain data to encode as JSON or YAML:
+// containers become Fields, with the field names in snake case, lists and vectors become slices,
+// bytes and bitfields 0x-prefixed hex strings, uint64 values Uint64, and other basic values numbers and bools.
+// The path is a dot-separated list of field names and list indices, e.g. "validators.5" or "validators.5.pubkey".
+// Indices may also be written in brackets, like the paths of Diff: "validators[5].pubkey". An empty path is the object itself.
+func (d *ObjDef) View(obj interface{}, path string) (interface{}, error) {
+	v := reflect.ValueOf(obj).Elem()
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+	if path != "" {
+		for _, seg := range strings.Split(path, ".") {
+			next, err := viewSegment(v, seg)
+			if err != nil {
+				return nil, err
+			}
+			v = next
+		}
+	}
+	return view(v), nil
+}
+
+// viewSegment gets the field or element of the value with the given name or index.
+func viewSegment(v reflect.Value, seg string) (reflect.Value, error) {
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		for _, f := range containerFields(v.Type()) {
+			if f.name == seg {
+				return v.FieldByIndex(f.index), nil
+			}
+		}
+		return reflect.Value{}, fmt.Errorf("unknown field %q", seg)
+	case reflect.Array, reflect.Slice:
+		i, err := strconv.ParseUint(seg, 10, 64)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("expected a list index, got %q", seg)
+		}
+		if i >= uint64(v.Len()) {
+			return reflect.Value{}, fmt.Errorf("index %d is out of range, length is %d", i, v.Len())
+		}
+		return v.Index(int(i)), nil
+	default:
+		return reflect.Value{}, fmt.Errorf("cannot get %q of a basic value", seg)
+	}
+}
+
+func view(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Bool, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return basicValue(v)
+	case reflect.Uint64:
+		return Uint64(v.Uint())
+	case reflect.Ptr:
+		return view(v.Elem())
+	case reflect.Struct:
+		fields := containerFields(v.Type())
+		out := make(Fields, len(fields))
+		for i, f := range fields {
+			out[i] = Field{Name: f.name, Value: view(v.FieldByIndex(f.index))}
+		}
+		return out
+	case reflect.Array, reflect.Slice:
+		// bytes and bitfields are viewed as a whole
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return bytesValue(v)
+		}
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = view(v.Index(i))
+		}
+		return out
+	default:
+		panic(fmt.Sprintf("cannot view value of type %s", v.Type()))
+	}
+}
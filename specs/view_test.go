@@ -0,0 +1,97 @@
+package specs
+
+import (
+	"encoding/json"
+	"github.com/protolambda/zssz-spec-history/minimal_v0_9_0"
+	"gopkg.in/yaml.v2"
+	"strings"
+	"testing"
+)
+
+func TestView(t *testing.T) {
+	spec, err := NewDefaultRegistry().Get("v0.9.0", "minimal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := spec.Obj(BeaconState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := def.Alloc().(*minimal_v0_9_0.BeaconState)
+	state.Slot = 3
+	state.Validators = make(minimal_v0_9_0.Validators, 2)
+	state.Validators[1].EffectiveBalance = 32
+	state.Validators[1].ExitEpoch = ^minimal_v0_9_0.Epoch(0)
+	state.Fork.CurrentVersion[0] = 1
+	cases := []struct {
+		name string
+		path string
+		// the JSON encoding of the view, empty if the path is invalid
+		want string
+		err  string
+	}{
+		{name: "basic field", path: "slot", want: `"3"`},
+		{name: "bytes", path: "fork.current_version", want: `"0x01000000"`},
+		{name: "container", path: "fork", want: `{"previous_version":"0x00000000","current_version":"0x01000000","epoch":"0"}`},
+		{name: "list element field", path: "validators.1.effective_balance", want: `"32"`},
+		{name: "bracket index", path: "validators[1].effective_balance", want: `"32"`},
+		{name: "beyond the precision of JSON numbers", path: "validators[1].exit_epoch", want: `"18446744073709551615"`},
+		{name: "bool", path: "validators.1.slashed", want: `false`},
+		{name: "out of range index", path: "validators.2", err: "index 2 is out of range, length is 2"},
+		{name: "unknown field", path: "validators.1.balance", err: `unknown field "balance"`},
+		{name: "field of a list", path: "validators.slot", err: `expected a list index, got "slot"`},
+		{name: "field of a basic value", path: "slot.epoch", err: `cannot get "epoch" of a basic value`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out, err := def.View(state, c.path)
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Fatalf("got error %v, expected %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != c.want {
+				t.Errorf("got %s, expected %s", data, c.want)
+			}
+		})
+	}
+}
+
+func TestViewState(t *testing.T) {
+	spec, err := NewDefaultRegistry().Get("v0.9.0", "minimal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := spec.Obj(BeaconState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := def.Alloc().(*minimal_v0_9_0.BeaconState)
+	state.Slot = 3
+	out, err := def.View(state, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), `{"genesis_time":"0","slot":"3","fork":{`) {
+		t.Errorf("got JSON %.60s..., expected the fields in spec order", data)
+	}
+	data, err = yaml.Marshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "genesis_time: 0\nslot: 3\nfork:\n") {
+		t.Errorf("got YAML %.60q..., expected the fields in spec order, with numbers", data)
+	}
+}
@@ -0,0 +1,291 @@
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"github.com/protolambda/muskoka-server/model"
+	bolt "go.etcd.io/bbolt"
+	"sort"
+	"time"
+)
+
+var (
+	boltTransitionsBucket = []byte("transitions")
+	boltIndexBucket       = []byte("transitions-by-index")
+	boltMetaBucket        = []byte("transitions-meta")
+	boltNextIndexKey      = []byte("next-index")
+)
+
+// BoltStore keeps tasks in an embedded on-disk database, for running without firestore.
+// Tasks are gob-encoded in the "transitions" bucket, and indexed by task index for ordered queries.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second * 5})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open task database %s: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltTransitionsBucket, boltIndexBucket, boltMetaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialize task database %s: %v", path, err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func encodeIndex(index uint64) []byte {
+	var out [8]byte
+	binary.BigEndian.PutUint64(out[:], index)
+	return out[:]
+}
+
+func decodeTask(data []byte) (*model.Task, error) {
+	var task model.Task
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&task); err != nil {
+		return nil, err
+	}
+	if err := task.Migrate(); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func putTask(tx *bolt.Tx, task *model.Task) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(task); err != nil {
+		return err
+	}
+	return tx.Bucket(boltTransitionsBucket).Put([]byte(task.Key), buf.Bytes())
+}
+
+func (s *BoltStore) CreateTask(ctx context.Context, key string, task *model.Task) error {
+	index := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltTransitionsBucket).Get([]byte(key)) != nil {
+			return ErrExists
+		}
+		meta := tx.Bucket(boltMetaBucket)
+		nextIndex := uint64(0)
+		if v := meta.Get(boltNextIndexKey); v != nil {
+			nextIndex = binary.BigEndian.Uint64(v)
+		}
+		if err := meta.Put(boltNextIndexKey, encodeIndex(nextIndex+1)); err != nil {
+			return err
+		}
+		// A copy is stored: the task is left as it is if it cannot be created.
+		created := *task
+		created.Index = int(nextIndex)
+		created.Key = key
+		index = created.Index
+		if err := putTask(tx, &created); err != nil {
+			return err
+		}
+		return tx.Bucket(boltIndexBucket).Put(encodeIndex(nextIndex), []byte(key))
+	})
+	if err != nil {
+		return err
+	}
+	task.Index = index
+	task.Key = key
+	return nil
+}
+
+func (s *BoltStore) GetTask(ctx context.Context, key string) (*model.Task, error) {
+	var task *model.Task
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltTransitionsBucket).Get([]byte(key))
+		if data == nil {
+			return ErrNotFound
+		}
+		t, err := decodeTask(data)
+		if err != nil {
+			return fmt.Errorf("could not parse task %s: %v", key, err)
+		}
+		task = t
+		return nil
+	})
+	return task, err
+}
+
+func (s *BoltStore) MergeResult(ctx context.Context, taskKey string, resultKey string, result *model.ResultEntry, policy DuplicatePolicy) (string, error) {
+	storedKey := ""
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltTransitionsBucket).Get([]byte(taskKey))
+		if data == nil {
+			return ErrNotFound
+		}
+		task, err := decodeTask(data)
+		if err != nil {
+			return fmt.Errorf("could not parse task %s: %v", taskKey, err)
+		}
+		storedKey = ResultKeyFor(task.Results, resultKey, policy)
+		if storedKey == "" {
+			return nil
+		}
+		task.AddResult(storedKey, result)
+		return putTask(tx, task)
+	})
+	if err != nil {
+		return "", err
+	}
+	return storedKey, nil
+}
+
+func (s *BoltStore) UpdateResult(ctx context.Context, taskKey string, resultKey string, update func(task *model.Task, result *model.ResultEntry) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltTransitionsBucket).Get([]byte(taskKey))
+		if data == nil {
+			return ErrNotFound
+		}
+		task, err := decodeTask(data)
+		if err != nil {
+			return fmt.Errorf("could not parse task %s: %v", taskKey, err)
+		}
+		result, ok := task.Results[resultKey]
+		if !ok {
+			return ErrNotFound
+		}
+		if err := update(task, &result); err != nil {
+			return err
+		}
+		task.ReplaceResult(resultKey, &result)
+		return putTask(tx, task)
+	})
+}
+
+func (s *BoltStore) SetExpectedPostRoot(ctx context.Context, key string, root string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltTransitionsBucket).Get([]byte(key))
+		if data == nil {
+			return ErrNotFound
+		}
+		task, err := decodeTask(data)
+		if err != nil {
+			return fmt.Errorf("could not parse task %s: %v", key, err)
+		}
+		task.SetExpectedPostRoot(root)
+		return putTask(tx, task)
+	})
+}
+
+func matchesQuery(task *model.Task, q *Query) bool {
+	if q.HasFail && !task.HasFail {
+		return false
+	}
+	if q.MismatchExpected && !task.MismatchExpected {
+		return false
+	}
+	if q.HasDisagreement && !task.Consensus.HasDisagreement {
+		return false
+	}
+	if q.SpecVersion != "" && task.SpecVersion != q.SpecVersion {
+		return false
+	}
+	if q.SpecConfig != "" && task.SpecConfig != q.SpecConfig {
+		return false
+	}
+	for clientName, clientVersion := range q.Clients {
+		if clientVersion != "" {
+			if task.WorkersVersioned[clientName] != clientVersion {
+				return false
+			}
+		} else if !task.Workers[clientName] {
+			return false
+		}
+	}
+	for clientName, outcome := range q.Outcomes {
+		if !task.WorkerOutcomes[clientName][string(outcome)] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *BoltStore) QueryTasks(ctx context.Context, q *Query) (*QueryResult, error) {
+	if err := q.Check(); err != nil {
+		return nil, err
+	}
+	// tasks in other orders are all collected, and sorted after.
+	byIndex := q.Order == OrderLatest
+	res := &QueryResult{Tasks: make([]*model.Task, 0)}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(boltMetaBucket).Get(boltNextIndexKey); v != nil {
+			res.TotalTaskCount = int(binary.BigEndian.Uint64(v))
+		}
+		transitions := tx.Bucket(boltTransitionsBucket)
+		c := tx.Bucket(boltIndexBucket).Cursor()
+		// latest-first
+		var k, v []byte
+		if q.After != nil {
+			// continue *after* (i.e. excl) the given index
+			k, v = c.Seek(encodeIndex(*q.After))
+			if k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		} else {
+			k, v = c.Last()
+		}
+		skip := q.Offset
+		for ; k != nil && (!byIndex || len(res.Tasks) < q.Limit); k, v = c.Prev() {
+			// stop *before* (i.e. excl) the given index
+			if q.Before != nil && binary.BigEndian.Uint64(k) <= *q.Before {
+				break
+			}
+			task, err := decodeTask(transitions.Get(v))
+			if err != nil {
+				return fmt.Errorf("could not parse task %s: %v", v, err)
+			}
+			if !matchesQuery(task, q) {
+				continue
+			}
+			if byIndex && skip > 0 {
+				skip--
+				continue
+			}
+			// like the firestore query, do not return the "workers", "workers-versioned" or "worker-outcomes" helper fields.
+			task.Workers = nil
+			task.WorkersVersioned = nil
+			task.WorkerOutcomes = nil
+			task.HasFail = false
+			task.MismatchExpected = false
+			res.Tasks = append(res.Tasks, task)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !byIndex {
+		// the tasks are collected latest-first, a stable sort keeps that order for tasks with equal consensus.
+		sort.SliceStable(res.Tasks, func(i, j int) bool {
+			return res.Tasks[i].Consensus.Distinct > res.Tasks[j].Consensus.Distinct
+		})
+		if q.Offset >= len(res.Tasks) {
+			res.Tasks = res.Tasks[:0]
+		} else {
+			res.Tasks = res.Tasks[q.Offset:]
+		}
+		if len(res.Tasks) > q.Limit {
+			res.Tasks = res.Tasks[:q.Limit]
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,334 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"github.com/protolambda/muskoka-server/model"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTestStore opens a store in a new temporary directory. The returned func closes the store, and removes the directory.
+func openTestStore(t *testing.T) (*BoltStore, func()) {
+	dir, err := ioutil.TempDir("", "muskoka-tasks-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenBoltStore(filepath.Join(dir, "tasks.db"))
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, func() {
+		_ = s.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestBoltCreateTask(t *testing.T) {
+	s, closeStore := openTestStore(t)
+	defer closeStore()
+	ctx := context.Background()
+	created := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	if err := s.CreateTask(ctx, "a", model.NewTask("v0.9.0", "minimal", 1, created)); err != nil {
+		t.Fatal(err)
+	}
+	conflict := model.NewTask("v0.9.0", "mainnet", 2, created)
+	if err := s.CreateTask(ctx, "a", conflict); err != ErrExists {
+		t.Fatalf("got error %v, expected ErrExists", err)
+	}
+	if conflict.Key != "" || conflict.Index != 0 {
+		t.Errorf("task that was not created was changed: %+v", conflict)
+	}
+	// the index is assigned, but the task cannot be stored: bolt keys are limited to 32 KiB
+	failed := model.NewTask("v0.9.0", "minimal", 1, created)
+	if err := s.CreateTask(ctx, strings.Repeat("k", 1<<16), failed); err == nil {
+		t.Fatal("expected the task with a too large key to fail")
+	}
+	if failed.Key != "" || failed.Index != 0 {
+		t.Errorf("task that was not stored was changed: got index %d and a key of %d bytes", failed.Index, len(failed.Key))
+	}
+	if err := s.CreateTask(ctx, "b", model.NewTask("v0.9.0", "minimal", 1, created)); err != nil {
+		t.Fatal(err)
+	}
+	a, err := s.GetTask(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.SpecConfig != "minimal" || a.Blocks != 1 || a.Index != 0 || a.Key != "a" {
+		t.Errorf("existing task was changed: %+v", a)
+	}
+	b, err := s.GetTask(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the conflicting task does not use up an index
+	if b.Index != 1 {
+		t.Errorf("got index %d, expected 1", b.Index)
+	}
+	if _, err := s.GetTask(ctx, "c"); err != ErrNotFound {
+		t.Errorf("got error %v, expected ErrNotFound", err)
+	}
+}
+
+func TestBoltMergeResult(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   DuplicatePolicy
+		wantKeys []string
+		want     map[string]string
+	}{
+		{"keep first", KeepFirst, []string{"r", "", ""}, map[string]string{"r": "0x01"}},
+		{"keep latest", KeepLatest, []string{"r", "r", "r"}, map[string]string{"r": "0x03"}},
+		{"keep all", KeepAll, []string{"r", "r-1", "r-2"}, map[string]string{"r": "0x01", "r-1": "0x02", "r-2": "0x03"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, closeStore := openTestStore(t)
+			defer closeStore()
+			ctx := context.Background()
+			if err := s.CreateTask(ctx, "a", model.NewTask("v0.9.0", "minimal", 1, time.Now())); err != nil {
+				t.Fatal(err)
+			}
+			for i, wantKey := range c.wantKeys {
+				result := &model.ResultEntry{Success: true, Outcome: model.OutcomeOK, ClientName: "zrnt", PostHash: fmt.Sprintf("0x%02d", i+1)}
+				key, err := s.MergeResult(ctx, "a", "r", result, c.policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if key != wantKey {
+					t.Errorf("result %d: got key %q, expected %q", i, key, wantKey)
+				}
+			}
+			task, err := s.GetTask(ctx, "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for k, r := range task.Results {
+				got[k] = r.PostHash
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got results %v, expected %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestBoltUpdateResult(t *testing.T) {
+	s, closeStore := openTestStore(t)
+	defer closeStore()
+	ctx := context.Background()
+	if err := s.CreateTask(ctx, "a", model.NewTask("v0.9.0", "minimal", 1, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MergeResult(ctx, "a", "r", &model.ResultEntry{Outcome: model.OutcomeCrash, ClientName: "zrnt"}, KeepFirst); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateResult(ctx, "a", "x", func(task *model.Task, result *model.ResultEntry) error { return nil }); err != ErrNotFound {
+		t.Errorf("got error %v for unknown result, expected ErrNotFound", err)
+	}
+	if err := s.UpdateResult(ctx, "b", "r", func(task *model.Task, result *model.ResultEntry) error { return nil }); err != ErrNotFound {
+		t.Errorf("got error %v for unknown task, expected ErrNotFound", err)
+	}
+	updateErr := fmt.Errorf("update failed")
+	err := s.UpdateResult(ctx, "a", "r", func(task *model.Task, result *model.ResultEntry) error {
+		result.Outcome = model.OutcomeOK
+		return updateErr
+	})
+	if err != updateErr {
+		t.Errorf("got error %v, expected the error of the update", err)
+	}
+	err = s.UpdateResult(ctx, "a", "r", func(task *model.Task, result *model.ResultEntry) error {
+		result.Success = true
+		result.Outcome = model.OutcomeOK
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := s.GetTask(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := task.Results["r"]; !r.Success || task.HasFail {
+		t.Errorf("result was not updated: %+v, has-fail %v", r, task.HasFail)
+	}
+}
+
+// createTestTasks creates n tasks with keys "t0", "t1", ... and indices 0, 1, ...
+// Every task gets a result of client zrnt, the modify func changes the task before its result is added.
+func createTestTasks(t *testing.T, s *BoltStore, n int, modify func(i int, task *model.Task, result *model.ResultEntry)) {
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("t%d", i)
+		task := model.NewTask("v0.9.0", "minimal", 1, time.Now())
+		result := &model.ResultEntry{Success: true, Outcome: model.OutcomeOK, ClientName: "zrnt", ClientVersion: "v1", PostHash: "0x01"}
+		if modify != nil {
+			modify(i, task, result)
+		}
+		if err := s.CreateTask(ctx, key, task); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.MergeResult(ctx, key, "r", result, KeepFirst); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func taskKeys(tasks []*model.Task) []string {
+	keys := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		keys = append(keys, task.Key)
+	}
+	return keys
+}
+
+func indexPtr(i uint64) *uint64 {
+	return &i
+}
+
+func TestBoltQueryPagination(t *testing.T) {
+	s, closeStore := openTestStore(t)
+	defer closeStore()
+	createTestTasks(t, s, 5, nil)
+	cases := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"latest first", Query{Limit: 3}, []string{"t4", "t3", "t2"}},
+		{"after", Query{Limit: 3, After: indexPtr(3)}, []string{"t2", "t1", "t0"}},
+		{"after the last index", Query{Limit: 2, After: indexPtr(10)}, []string{"t4", "t3"}},
+		{"before", Query{Limit: 3, Before: indexPtr(2)}, []string{"t4", "t3"}},
+		{"after and before", Query{Limit: 5, After: indexPtr(4), Before: indexPtr(0)}, []string{"t3", "t2", "t1"}},
+		{"offset", Query{Limit: 2, Offset: 1}, []string{"t3", "t2"}},
+		{"offset past the end", Query{Limit: 2, Offset: 5}, []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := s.QueryTasks(context.Background(), &c.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := taskKeys(res.Tasks); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got tasks %v, expected %v", got, c.want)
+			}
+			if res.TotalTaskCount != 5 {
+				t.Errorf("got total task count %d, expected 5", res.TotalTaskCount)
+			}
+		})
+	}
+}
+
+func TestBoltQueryFilters(t *testing.T) {
+	s, closeStore := openTestStore(t)
+	defer closeStore()
+	createTestTasks(t, s, 6, func(i int, task *model.Task, result *model.ResultEntry) {
+		switch i {
+		case 1:
+			task.SpecConfig = "mainnet"
+		case 2:
+			result.ClientVersion = "v2"
+		case 3:
+			result.Success = false
+			result.Outcome = model.OutcomeCrash
+		case 4:
+			result.Success = false
+			result.Outcome = model.OutcomeUnsupported
+		case 5:
+			task.ExpectedPostRoot = "0x02"
+			result.StateRoot = "0x03"
+			result.Verdict = model.VerdictIncorrect
+		}
+	})
+	cases := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"all", Query{Limit: 10}, []string{"t5", "t4", "t3", "t2", "t1", "t0"}},
+		{"has fail", Query{Limit: 10, HasFail: true}, []string{"t3"}},
+		{"mismatch expected", Query{Limit: 10, MismatchExpected: true}, []string{"t5"}},
+		{"spec config", Query{Limit: 10, SpecConfig: "mainnet"}, []string{"t1"}},
+		{"spec version", Query{Limit: 10, SpecVersion: "v0.8.0"}, []string{}},
+		{"client version", Query{Limit: 10, Clients: map[string]string{"zrnt": "v2"}}, []string{"t2"}},
+		{"any client version", Query{Limit: 3, Clients: map[string]string{"zrnt": ""}}, []string{"t5", "t4", "t3"}},
+		{"unknown client", Query{Limit: 10, Clients: map[string]string{"prysm": ""}}, []string{}},
+		{"outcome", Query{Limit: 10, Outcomes: map[string]model.Outcome{"zrnt": model.OutcomeUnsupported}}, []string{"t4"}},
+		{"filter and offset", Query{Limit: 10, Offset: 1, Clients: map[string]string{"zrnt": "v1"}}, []string{"t4", "t3", "t1", "t0"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := s.QueryTasks(context.Background(), &c.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := taskKeys(res.Tasks); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got tasks %v, expected %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestBoltQueryDisagreement(t *testing.T) {
+	s, closeStore := openTestStore(t)
+	defer closeStore()
+	createTestTasks(t, s, 4, nil)
+	ctx := context.Background()
+	// t1 gets 3 distinct post-hashes, t0 and t2 get 2
+	extra := map[string][]string{"t0": {"0x02"}, "t1": {"0x02", "0x03"}, "t2": {"0x02"}}
+	for key, hashes := range extra {
+		for i, h := range hashes {
+			result := &model.ResultEntry{Success: true, Outcome: model.OutcomeOK, ClientName: fmt.Sprintf("client%d", i), PostHash: h}
+			if _, err := s.MergeResult(ctx, key, fmt.Sprintf("x%d", i), result, KeepFirst); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	cases := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"disagreement order", Query{Limit: 10, Order: OrderDisagreement}, []string{"t1", "t2", "t0", "t3"}},
+		{"disagreement order with offset", Query{Limit: 2, Offset: 1, Order: OrderDisagreement}, []string{"t2", "t0"}},
+		{"has disagreement", Query{Limit: 10, HasDisagreement: true}, []string{"t2", "t1", "t0"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := s.QueryTasks(ctx, &c.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := taskKeys(res.Tasks); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got tasks %v, expected %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestBoltQueryCheck(t *testing.T) {
+	s, closeStore := openTestStore(t)
+	defer closeStore()
+	cases := []struct {
+		name  string
+		query Query
+	}{
+		{"unknown order", Query{Limit: 1, Order: "random"}},
+		{"paginate disagreement by index", Query{Limit: 1, Order: OrderDisagreement, After: indexPtr(1)}},
+		{"negative offset", Query{Limit: 1, Offset: -1}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := s.QueryTasks(context.Background(), &c.query); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
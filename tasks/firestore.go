@@ -0,0 +1,299 @@
+package tasks
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"fmt"
+	"github.com/protolambda/muskoka-server/model"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FirestoreStore keeps tasks as documents in the "transitions" collection,
+// and tracks the next task index in the "transitions-meta/next-index" document.
+type FirestoreStore struct {
+	client                  *firestore.Client
+	fsTransitionsCollection *firestore.CollectionRef
+	fsTaskIndexRef          *firestore.DocumentRef
+}
+
+func NewFirestoreStore(ctx context.Context, projectID string) (*FirestoreStore, error) {
+	cl, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firestore client: %v", err)
+	}
+	return &FirestoreStore{
+		client:                  cl,
+		fsTransitionsCollection: cl.Collection("transitions"),
+		fsTaskIndexRef:          cl.Collection("transitions-meta").Doc("next-index"),
+	}, nil
+}
+
+func (s *FirestoreStore) CreateTask(ctx context.Context, key string, task *model.Task) error {
+	doc := s.fsTransitionsCollection.Doc(key)
+	index := 0
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// read the next index
+		indexDoc, err := tx.Get(s.fsTaskIndexRef)
+		var indexContainer model.TaskIndexDoc
+		if status.Code(err) == codes.NotFound || (err == nil && !indexDoc.Exists()) {
+			indexContainer.NextIndex = 0
+		} else if err != nil {
+			return err
+		} else {
+			if err := indexDoc.DataTo(&indexContainer); err != nil {
+				return err
+			}
+		}
+
+		// increment the index
+		if err := tx.Set(s.fsTaskIndexRef, model.TaskIndexDoc{NextIndex: indexContainer.NextIndex + 1}); err != nil {
+			return err
+		}
+		// create the task with the previously read ID. Fails if the document exists, it is not overwritten.
+		// A copy is created: the task is left as it is if it cannot be created.
+		created := *task
+		created.Index = indexContainer.NextIndex
+		created.Key = key
+		index = created.Index
+		return tx.Create(doc, &created)
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		return ErrExists
+	}
+	if err != nil {
+		return err
+	}
+	task.Index = index
+	task.Key = key
+	return nil
+}
+
+func (s *FirestoreStore) GetTask(ctx context.Context, key string) (*model.Task, error) {
+	dat, err := s.fsTransitionsCollection.Doc(key).Get(ctx)
+	if status.Code(err) == codes.NotFound || (err == nil && !dat.Exists()) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var task model.Task
+	if err := dat.DataTo(&task); err != nil {
+		return nil, fmt.Errorf("could not parse task %s: %v", key, err)
+	}
+	task.Key = key
+	if err := task.Migrate(); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (s *FirestoreStore) MergeResult(ctx context.Context, taskKey string, resultKey string, result *model.ResultEntry, policy DuplicatePolicy) (string, error) {
+	doc := s.fsTransitionsCollection.Doc(taskKey)
+	storedKey := ""
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// the consensus depends on all results, the task is read in the transaction to update it.
+		task, migrated, err := getTaskTx(tx, doc)
+		if err != nil {
+			return err
+		}
+		storedKey = ResultKeyFor(task.Results, resultKey, policy)
+		if storedKey == "" {
+			return nil
+		}
+		task.AddResult(storedKey, result)
+		// client names and result keys are not valid in dotted paths, use field paths.
+		return writeTaskTx(tx, doc, task, migrated, []firestore.Update{
+			{FieldPath: []string{"results", storedKey}, Value: task.Results[storedKey]},
+			{FieldPath: []string{"workers-versioned", result.ClientName}, Value: result.ClientVersion},
+			{FieldPath: []string{"workers", result.ClientName}, Value: true},
+			{Path: "worker-outcomes", Value: task.WorkerOutcomes},
+			{Path: "has-fail", Value: task.HasFail},
+			{Path: "mismatch-expected", Value: task.MismatchExpected},
+			{Path: "consensus", Value: task.Consensus},
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return storedKey, nil
+}
+
+func (s *FirestoreStore) UpdateResult(ctx context.Context, taskKey string, resultKey string, update func(task *model.Task, result *model.ResultEntry) error) error {
+	doc := s.fsTransitionsCollection.Doc(taskKey)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		task, migrated, err := getTaskTx(tx, doc)
+		if err != nil {
+			return err
+		}
+		result, ok := task.Results[resultKey]
+		if !ok {
+			return ErrNotFound
+		}
+		if err := update(task, &result); err != nil {
+			return err
+		}
+		task.ReplaceResult(resultKey, &result)
+		return writeTaskTx(tx, doc, task, migrated, []firestore.Update{
+			{FieldPath: []string{"results", resultKey}, Value: result},
+			{Path: "worker-outcomes", Value: task.WorkerOutcomes},
+			{Path: "has-fail", Value: task.HasFail},
+			{Path: "mismatch-expected", Value: task.MismatchExpected},
+			{Path: "consensus", Value: task.Consensus},
+		})
+	})
+}
+
+func (s *FirestoreStore) SetExpectedPostRoot(ctx context.Context, key string, root string) error {
+	doc := s.fsTransitionsCollection.Doc(key)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		task, migrated, err := getTaskTx(tx, doc)
+		if err != nil {
+			return err
+		}
+		task.SetExpectedPostRoot(root)
+		// the results are replaced as a whole, as read in this transaction.
+		updates := []firestore.Update{
+			{Path: "expected-post-root", Value: task.ExpectedPostRoot},
+			{Path: "mismatch-expected", Value: task.MismatchExpected},
+		}
+		if task.Results != nil {
+			updates = append(updates, firestore.Update{Path: "results", Value: task.Results})
+		}
+		return writeTaskTx(tx, doc, task, migrated, updates)
+	})
+}
+
+// getTaskTx reads the task in the transaction, and migrates it to the current schema version.
+// Returns true if the task was migrated.
+func getTaskTx(tx *firestore.Transaction, doc *firestore.DocumentRef) (*model.Task, bool, error) {
+	dat, err := tx.Get(doc)
+	if status.Code(err) == codes.NotFound || (err == nil && !dat.Exists()) {
+		return nil, false, ErrNotFound
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var task model.Task
+	if err := dat.DataTo(&task); err != nil {
+		return nil, false, fmt.Errorf("could not parse task %s: %v", doc.ID, err)
+	}
+	task.Key = doc.ID
+	version := task.SchemaVersion
+	if err := task.Migrate(); err != nil {
+		return nil, false, err
+	}
+	return &task, task.SchemaVersion != version, nil
+}
+
+// writeTaskTx applies the updates to the task document in the transaction.
+// A migrated task is written as a whole instead: updating only some fields would mix the fields of schema versions.
+func writeTaskTx(tx *firestore.Transaction, doc *firestore.DocumentRef, task *model.Task, migrated bool, updates []firestore.Update) error {
+	if migrated {
+		return tx.Set(doc, task)
+	}
+	return tx.Update(doc, updates)
+}
+
+func (s *FirestoreStore) QueryTasks(ctx context.Context, query *Query) (*QueryResult, error) {
+	if err := query.Check(); err != nil {
+		return nil, err
+	}
+	q := s.fsTransitionsCollection.Query.Limit(query.Limit)
+
+	if query.Order == OrderDisagreement {
+		q = q.OrderBy("consensus.distinct", firestore.Desc)
+	}
+	// latest-first
+	q = q.OrderBy("index", firestore.Desc)
+	if query.Offset > 0 {
+		q = q.Offset(query.Offset)
+	}
+
+	if query.HasFail {
+		q = q.Where("has-fail", "==", true)
+	}
+	if query.MismatchExpected {
+		q = q.Where("mismatch-expected", "==", true)
+	}
+	if query.HasDisagreement {
+		q = q.Where("consensus.has-disagreement", "==", true)
+	}
+	if query.SpecVersion != "" {
+		q = q.Where("spec-version", "==", query.SpecVersion)
+	}
+	if query.SpecConfig != "" {
+		q = q.Where("spec-config", "==", query.SpecConfig)
+	}
+	for clientName, clientVersion := range query.Clients {
+		if clientVersion != "" {
+			// look for the specific version
+			q = q.WherePath([]string{"workers-versioned", clientName}, "==", clientVersion)
+		} else {
+			// just that the key is present.
+			q = q.WherePath([]string{"workers", clientName}, "==", true)
+		}
+	}
+	for clientName, outcome := range query.Outcomes {
+		q = q.WherePath([]string{"worker-outcomes", clientName, string(outcome)}, "==", true)
+	}
+	// paginate forwards by continuing *after* (i.e. excl) a given index
+	if query.After != nil {
+		q = q.StartAfter(int(*query.After))
+	}
+	// paginate backwards by stopping *before* (i.e. excl) a given index
+	if query.Before != nil {
+		q = q.EndBefore(int(*query.Before))
+	}
+	// do not select "workers", "workers-versioned" or "worker-outcomes" helper fields.
+	q = q.Select("schema-version", "blocks", "spec-version", "spec-config", "created",
+		"pre-root", "pre-slot", "block-roots", "block-slots", "expected-post-root", "inputs",
+		"parent", "parent-blocks", "parent-result", "results", "consensus", "index")
+
+	res := &QueryResult{Tasks: make([]*model.Task, 0)}
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		res.Tasks = res.Tasks[:0]
+		// read the next index
+		indexDoc, err := tx.Get(s.fsTaskIndexRef)
+		if status.Code(err) == codes.NotFound || (err == nil && !indexDoc.Exists()) {
+			res.TotalTaskCount = 0
+		} else if err != nil {
+			return err
+		} else {
+			var indexContainer model.TaskIndexDoc
+			if err := indexDoc.DataTo(&indexContainer); err != nil {
+				return err
+			}
+			res.TotalTaskCount = indexContainer.NextIndex
+		}
+		// no need to query if there are no documents.
+		if res.TotalTaskCount == 0 {
+			return nil
+		}
+		docsIter := tx.Documents(q)
+		for {
+			doc, err := docsIter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			var task model.Task
+			if err := doc.DataTo(&task); err != nil {
+				return fmt.Errorf("could not parse result %s %v", doc.Ref.ID, err)
+			}
+			task.Key = doc.Ref.ID
+			if err := task.Migrate(); err != nil {
+				return err
+			}
+			res.Tasks = append(res.Tasks, &task)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,79 @@
+package tasks
+
+import (
+	"context"
+	"github.com/protolambda/muskoka-server/model"
+	"os"
+	"testing"
+	"time"
+)
+
+// openEmulatorStore connects to the firestore emulator, the tests are skipped if FIRESTORE_EMULATOR_HOST is not set.
+// Tasks are created with new keys, the emulator does not have to be reset between runs.
+func openEmulatorStore(t *testing.T) *FirestoreStore {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set, start the firestore emulator to run this test")
+	}
+	s, err := NewFirestoreStore(context.Background(), "muskoka-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestFirestoreCreateTask(t *testing.T) {
+	s := openEmulatorStore(t)
+	ctx := context.Background()
+	key := NewKey()
+	task := model.NewTask("v0.9.0", "minimal", 1, time.Now())
+	if err := s.CreateTask(ctx, key, task); err != nil {
+		t.Fatal(err)
+	}
+	if task.Key != key {
+		t.Errorf("got key %q, expected %q", task.Key, key)
+	}
+	conflict := model.NewTask("v0.9.0", "mainnet", 2, time.Now())
+	if err := s.CreateTask(ctx, key, conflict); err != ErrExists {
+		t.Fatalf("got error %v, expected ErrExists", err)
+	}
+	if conflict.Key != "" {
+		t.Errorf("task that was not created was changed: %+v", conflict)
+	}
+	got, err := s.GetTask(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SpecConfig != "minimal" || got.Blocks != 1 || got.Index != task.Index {
+		t.Errorf("existing task was changed: %+v", got)
+	}
+	if _, err := s.GetTask(ctx, NewKey()); err != ErrNotFound {
+		t.Errorf("got error %v, expected ErrNotFound", err)
+	}
+}
+
+func TestFirestoreMergeResult(t *testing.T) {
+	s := openEmulatorStore(t)
+	ctx := context.Background()
+	key := NewKey()
+	if err := s.CreateTask(ctx, key, model.NewTask("v0.9.0", "minimal", 1, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	first := &model.ResultEntry{Success: true, ClientName: "zrnt", ClientVersion: "v1", PostHash: "0x01"}
+	if stored, err := s.MergeResult(ctx, key, "r", first, KeepFirst); err != nil || stored != "r" {
+		t.Fatalf("got stored key %q and error %v, expected the result to be stored", stored, err)
+	}
+	second := &model.ResultEntry{Success: true, ClientName: "zrnt", ClientVersion: "v1", PostHash: "0x02"}
+	if stored, err := s.MergeResult(ctx, key, "r", second, KeepFirst); err != nil || stored != "" {
+		t.Fatalf("got stored key %q and error %v, expected the duplicate to be dropped", stored, err)
+	}
+	task, err := s.GetTask(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(task.Results) != 1 || task.Results["r"].PostHash != "0x01" {
+		t.Errorf("got results %+v, expected only the first result", task.Results)
+	}
+	if !task.Workers["zrnt"] {
+		t.Errorf("client of the result is not recorded as worker")
+	}
+}
@@ -0,0 +1,14 @@
+module github.com/protolambda/muskoka-server/tasks
+
+go 1.11
+
+require (
+	cloud.google.com/go v0.46.2 // indirect
+	cloud.google.com/go/firestore v1.0.0
+	github.com/protolambda/muskoka-server/model v0.0.0
+	go.etcd.io/bbolt v1.3.3
+	google.golang.org/api v0.10.0
+	google.golang.org/grpc v1.23.1
+)
+
+replace github.com/protolambda/muskoka-server/model => ../model
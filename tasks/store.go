@@ -0,0 +1,181 @@
+package tasks
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/protolambda/muskoka-server/model"
+	"os"
+	"sync"
+)
+
+var ErrNotFound = errors.New("task not found")
+
+var ErrExists = errors.New("task already exists")
+
+// Order is the order to list tasks in.
+type Order string
+
+const (
+	// latest task first, by index
+	OrderLatest Order = ""
+	// tasks with the most distinct post-hashes first, then latest first.
+	// Tasks of older schema versions, without a consensus summary, are not listed in this order by firestore.
+	OrderDisagreement Order = "disagreement"
+)
+
+// Query filters tasks.
+type Query struct {
+	// maximum number of tasks to return
+	Limit int
+	// the order of the tasks, latest first by default
+	Order Order
+	// number of matching tasks to skip, for paginating tasks that are not listed by index.
+	Offset int
+	// if not nil, only return tasks with an index lower than After (paginate forwards). Only for OrderLatest.
+	After *uint64
+	// if not nil, only return tasks with an index higher than Before (paginate backwards). Only for OrderLatest.
+	Before *uint64
+	// only return tasks that had a failed result, see model.ResultEntry.Failed.
+	HasFail bool
+	// only return tasks that had a result that did not match the expected post-state.
+	MismatchExpected bool
+	// only return tasks with successful results that disagree on the post-state.
+	HasDisagreement bool
+	// spec version to filter for, ignored if empty
+	SpecVersion string
+	// spec config to filter for, ignored if empty
+	SpecConfig string
+	// client name -> client version. Only return tasks with results of the given clients, for the given versions.
+	// An empty version matches any version of the client.
+	Clients map[string]string
+	// client name -> outcome. Only return tasks with a result of the given client with the given outcome.
+	Outcomes map[string]model.Outcome
+}
+
+// Check returns an error if the query cannot be processed.
+func (q *Query) Check() error {
+	if q.Order != OrderLatest && q.Order != OrderDisagreement {
+		return fmt.Errorf("unknown order: %q", q.Order)
+	}
+	if q.Order != OrderLatest && (q.After != nil || q.Before != nil) {
+		return errors.New("only tasks in latest-first order can be paginated by index")
+	}
+	if q.Offset < 0 {
+		return errors.New("offset must not be negative")
+	}
+	return nil
+}
+
+// DuplicatePolicy decides what happens to a result with the same key as a result that is already part of the task.
+type DuplicatePolicy string
+
+const (
+	// ignore the new result
+	KeepFirst DuplicatePolicy = "keep-first"
+	// replace the existing result with the new result
+	KeepLatest DuplicatePolicy = "keep-latest"
+	// add the new result under the key with the next free attempt suffix: "<key>-<n>", starting at 1
+	KeepAll DuplicatePolicy = "keep-all"
+)
+
+// ParseDuplicatePolicy parses the name of a duplicate policy. An empty name is KeepFirst.
+func ParseDuplicatePolicy(name string) (DuplicatePolicy, error) {
+	switch p := DuplicatePolicy(name); p {
+	case "":
+		return KeepFirst, nil
+	case KeepFirst, KeepLatest, KeepAll:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown duplicate results policy: %q", name)
+	}
+}
+
+// ResultKeyFor decides the key to store a result under, given the existing results of the task and the policy.
+// Returns an empty key if the result is to be ignored.
+func ResultKeyFor(results map[string]model.ResultEntry, key string, policy DuplicatePolicy) string {
+	if _, ok := results[key]; !ok {
+		return key
+	}
+	switch policy {
+	case KeepLatest:
+		return key
+	case KeepAll:
+		for n := 1; ; n++ {
+			attemptKey := fmt.Sprintf("%s-%d", key, n)
+			if _, ok := results[attemptKey]; !ok {
+				return attemptKey
+			}
+		}
+	default:
+		return ""
+	}
+}
+
+type QueryResult struct {
+	Tasks []*model.Task
+	// total number of tasks in the store, regardless of the query
+	TotalTaskCount int
+}
+
+// Store keeps the tasks and their results.
+// Tasks read from the store are migrated to the current schema version, see model.Task.Migrate.
+type Store interface {
+	// CreateTask stores a new task under the given key, and assigns it the next task index.
+	// Returns ErrExists if a task with the key already exists, the existing task is not changed.
+	CreateTask(ctx context.Context, key string, task *model.Task) error
+	// GetTask retrieves a task by key. Returns ErrNotFound if the task does not exist.
+	GetTask(ctx context.Context, key string) (*model.Task, error)
+	// MergeResult adds a result to the task, and judges it, see model.Task.AddResult. Returns ErrNotFound if the task does not exist.
+	// If the task already has a result with the same key, the policy decides what happens.
+	// Returns the key the result was stored under, or an empty key if the result was ignored.
+	MergeResult(ctx context.Context, taskKey string, resultKey string, result *model.ResultEntry, policy DuplicatePolicy) (string, error)
+	// UpdateResult changes an existing result of the task, see model.Task.ReplaceResult.
+	// The update is called with the current task and a copy of the result, and may be called more than once.
+	// The result is not changed if the update returns an error, the error is returned.
+	// Returns ErrNotFound if the task or the result does not exist.
+	UpdateResult(ctx context.Context, taskKey string, resultKey string, update func(task *model.Task, result *model.ResultEntry) error) error
+	// SetExpectedPostRoot changes the expected post-state root of the task, and judges its results again,
+	// see model.Task.SetExpectedPostRoot. Returns ErrNotFound if the task does not exist.
+	SetExpectedPostRoot(ctx context.Context, key string, root string) error
+	// QueryTasks lists the tasks matching the query. Returns an error if the query does not pass Query.Check.
+	QueryTasks(ctx context.Context, q *Query) (*QueryResult, error)
+}
+
+const keyAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// NewKey creates a new random task key.
+// Keys have the same format as the auto-generated firestore document IDs: 20 alphanumeric characters.
+func NewKey() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand.Read error: %v", err))
+	}
+	// 256 is not a multiple of 62, but the bias is negligible for a random ID.
+	for i := range b {
+		b[i] = keyAlphabet[int(b[i])%len(keyAlphabet)]
+	}
+	return string(b)
+}
+
+var envStore Store
+var envErr error
+var envOnce sync.Once
+
+// FromEnv returns the task store, as configured by the environment:
+// an embedded database file if MUSKOKA_TASKS_DB is set, firestore of the GCP_PROJECT otherwise.
+// The store is created once, and shared by all callers in the process.
+func FromEnv(ctx context.Context) (Store, error) {
+	envOnce.Do(func() {
+		if path := os.Getenv("MUSKOKA_TASKS_DB"); path != "" {
+			envStore, envErr = OpenBoltStore(path)
+			return
+		}
+		envStore, envErr = NewFirestoreStore(ctx, os.Getenv("GCP_PROJECT"))
+	})
+	if envErr != nil {
+		return nil, fmt.Errorf("could not create task store: %v", envErr)
+	}
+	return envStore, nil
+}
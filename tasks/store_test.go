@@ -0,0 +1,71 @@
+package tasks
+
+import (
+	"github.com/protolambda/muskoka-server/model"
+	"testing"
+)
+
+func TestResultKeyFor(t *testing.T) {
+	results := map[string]model.ResultEntry{"r": {}, "r-1": {}, "r-3": {}, "s": {}}
+	cases := []struct {
+		name   string
+		key    string
+		policy DuplicatePolicy
+		want   string
+	}{
+		{"new key, keep first", "x", KeepFirst, "x"},
+		{"new key, keep latest", "x", KeepLatest, "x"},
+		{"new key, keep all", "x", KeepAll, "x"},
+		{"repeated key, keep first", "s", KeepFirst, ""},
+		{"repeated key, keep latest", "s", KeepLatest, "s"},
+		{"repeated key, keep all", "s", KeepAll, "s-1"},
+		{"next free attempt", "r", KeepAll, "r-2"},
+		{"attempt key itself", "r-1", KeepAll, "r-1-1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := ResultKeyFor(results, c.key, c.policy); got != c.want {
+				t.Errorf("got key %q, expected %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseDuplicatePolicy(t *testing.T) {
+	cases := []struct {
+		name string
+		want DuplicatePolicy
+		err  bool
+	}{
+		{"", KeepFirst, false},
+		{"keep-first", KeepFirst, false},
+		{"keep-latest", KeepLatest, false},
+		{"keep-all", KeepAll, false},
+		{"keep-some", "", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := ParseDuplicatePolicy(c.name)
+			if (err != nil) != c.err {
+				t.Fatalf("got error %v, expected error: %v", err, c.err)
+			}
+			if got != c.want {
+				t.Errorf("got policy %q, expected %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		key := NewKey()
+		if len(key) != 20 || !model.KeyRegex.MatchString(key) {
+			t.Fatalf("invalid key %q", key)
+		}
+		if seen[key] {
+			t.Fatalf("repeated key %q", key)
+		}
+		seen[key] = true
+	}
+}
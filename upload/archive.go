@@ -0,0 +1,273 @@
+package upload
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// the entries that are not an input or meta file, e.g. directories, that an archive may have.
+const maxArchiveOtherEntries = 4
+
+const maxArchiveMetaSize = 1024
+
+// bounds the contents of an archive, to reject archive bombs early.
+type archiveLimits struct {
+	State     int64
+	Block     int64
+	MaxBlocks int
+}
+
+// the meta file of an archive, "meta.json". Both fields are optional.
+type archiveMeta struct {
+	SpecVersion string `json:"spec-version"`
+	SpecConfig  string `json:"spec-config"`
+}
+
+// the inputs in an archive, spooled to temporary files. Close removes them.
+type archiveContents struct {
+	Meta *archiveMeta
+	// nil if the archive does not contain a pre-state
+	Pre *SpooledInput
+	// in block order
+	Blocks []*SpooledInput
+}
+
+// Close removes the temporary files of the inputs.
+func (c *archiveContents) Close() {
+	if c.Pre != nil {
+		_ = c.Pre.Close()
+	}
+	for _, b := range c.Blocks {
+		_ = b.Close()
+	}
+}
+
+// the largest limits of all specs, the limits of the actual spec are checked when the inputs are processed.
+func (h *Handler) archiveLimits() archiveLimits {
+	limits := archiveLimits{State: h.FallbackSizeLimits.State, Block: h.FallbackSizeLimits.Block}
+	for _, l := range h.SizeLimits {
+		if l.State > limits.State {
+			limits.State = l.State
+		}
+		if l.Block > limits.Block {
+			limits.Block = l.Block
+		}
+	}
+	for _, spec := range h.Specs.List() {
+		if spec.MaxBlocks > limits.MaxBlocks {
+			limits.MaxBlocks = spec.MaxBlocks
+		}
+	}
+	return limits
+}
+
+// readArchive reads a tar, tar.gz or zip archive with a "pre.ssz" entry, "block_<n>.ssz" entries, and optionally a "meta.json" entry.
+// Entries may be in a directory, only the base name is used.
+// The blocks are ordered by their index n, indices must be consecutive and start at 0.
+// The archive and its inputs are spooled to temporary files in dir, the archive is removed before returning.
+func readArchive(dir string, r io.Reader, limits archiveLimits) (out *archiveContents, err error) {
+	// archives are not compressed better than the limits of their contents (plus some space for headers)
+	maxArchiveSize := limits.State + int64(limits.MaxBlocks)*limits.Block + maxArchiveMetaSize + (1 << 20)
+	archive, err := SpoolInput(dir, r, maxArchiveSize)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	contents := &archiveContents{}
+	blocks := make(map[int]*SpooledInput)
+	// remove the spooled inputs if the archive is rejected
+	defer func() {
+		if err != nil {
+			if contents.Pre != nil {
+				_ = contents.Pre.Close()
+			}
+			for _, b := range blocks {
+				_ = b.Close()
+			}
+		}
+	}()
+	entryCount := 0
+	countEntry := func() error {
+		entryCount++
+		if entryCount > 1+limits.MaxBlocks+1+maxArchiveOtherEntries {
+			return fmt.Errorf("archive has too many entries")
+		}
+		return nil
+	}
+	// addEntry reads an entry, after checking its name and declared size.
+	addEntry := func(name string, declaredSize int64, er io.Reader) error {
+		if err := countEntry(); err != nil {
+			return err
+		}
+		name = path.Base(name)
+		var limit int64
+		blockIndex := -1
+		switch {
+		case name == "pre.ssz":
+			if contents.Pre != nil {
+				return fmt.Errorf("archive has more than one pre-state")
+			}
+			limit = limits.State
+		case name == "meta.json":
+			if contents.Meta != nil {
+				return fmt.Errorf("archive has more than one meta file")
+			}
+			limit = maxArchiveMetaSize
+		case strings.HasPrefix(name, "block_") && strings.HasSuffix(name, ".ssz"):
+			n, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "block_"), ".ssz"), 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid block entry name: %s", name)
+			}
+			if n >= uint64(limits.MaxBlocks) {
+				return fmt.Errorf("cannot process high amount of blocks; more than %v", limits.MaxBlocks)
+			}
+			blockIndex = int(n)
+			if _, ok := blocks[blockIndex]; ok {
+				return fmt.Errorf("archive has more than one block %d", blockIndex)
+			}
+			limit = limits.Block
+		default:
+			return fmt.Errorf("unexpected archive entry: %s", name)
+		}
+		if declaredSize > limit {
+			return fmt.Errorf("archive entry %s is too large, limit is %d bytes", name, limit)
+		}
+		// the declared size cannot be trusted, the limit is enforced while reading
+		if name == "meta.json" {
+			entryData, err := ioutil.ReadAll(io.LimitReader(er, limit+1))
+			if err != nil {
+				return fmt.Errorf("could not read archive entry %s: %v", name, err)
+			}
+			if int64(len(entryData)) > limit {
+				return fmt.Errorf("archive entry %s is too large, limit is %d bytes", name, limit)
+			}
+			var meta archiveMeta
+			if err := json.Unmarshal(entryData, &meta); err != nil {
+				return fmt.Errorf("invalid meta file: %v", err)
+			}
+			contents.Meta = &meta
+			return nil
+		}
+		in, err := SpoolInput(dir, er, limit)
+		if err != nil {
+			return fmt.Errorf("could not read archive entry %s: %v", name, err)
+		}
+		if name == "pre.ssz" {
+			contents.Pre = in
+		} else {
+			blocks[blockIndex] = in
+		}
+		return nil
+	}
+
+	err = WalkArchive(archive, func(e *ArchiveEntry) error {
+		if e.Dir {
+			return countEntry()
+		}
+		if e.Contents == nil {
+			return fmt.Errorf("unexpected archive entry type of %s", e.Name)
+		}
+		return addEntry(e.Name, e.Size, e.Contents)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	contents.Blocks = make([]*SpooledInput, 0, len(blocks))
+	for i := 0; i < len(blocks); i++ {
+		b, ok := blocks[i]
+		if !ok {
+			return nil, fmt.Errorf("archive is missing block %d, block indices must be consecutive", i)
+		}
+		contents.Blocks = append(contents.Blocks, b)
+	}
+	return contents, nil
+}
+
+// ArchiveEntry is an entry of an archive, see WalkArchive.
+type ArchiveEntry struct {
+	// path of the entry in the archive
+	Name string
+	// the size declared by the archive. It cannot be trusted, limits must be enforced while reading the contents.
+	Size int64
+	// true if the entry is a directory
+	Dir bool
+	// the contents of a regular file, nil for other entries, e.g. directories and links
+	Contents io.Reader
+}
+
+// WalkArchive calls fn for each entry of a tar, tar.gz or zip archive, in archive order.
+// The format is recognized by the first bytes of the archive. The contents of an entry can only be read during its call.
+// Returning an error stops the walk.
+func WalkArchive(archive *SpooledInput, fn func(e *ArchiveEntry) error) error {
+	magic := make([]byte, 4)
+	n, err := archive.Reader().Read(magic)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	magic = magic[:n]
+
+	if bytes.HasPrefix(magic, []byte("PK\x03\x04")) {
+		zr, err := zip.NewReader(archive.Reader(), archive.Size)
+		if err != nil {
+			return fmt.Errorf("invalid zip archive: %v", err)
+		}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				if err := fn(&ArchiveEntry{Name: f.Name, Dir: true}); err != nil {
+					return err
+				}
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("could not open archive entry %s: %v", f.Name, err)
+			}
+			err = fn(&ArchiveEntry{Name: f.Name, Size: int64(f.UncompressedSize64), Contents: rc})
+			_ = rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var tarData io.Reader = bufio.NewReader(archive.Reader())
+	if bytes.HasPrefix(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(tarData)
+		if err != nil {
+			return fmt.Errorf("invalid gzip archive: %v", err)
+		}
+		tarData = gz
+	}
+	tr := tar.NewReader(tarData)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar archive: %v", err)
+		}
+		e := &ArchiveEntry{Name: hdr.Name, Size: hdr.Size}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			e.Dir = true
+		case tar.TypeReg:
+			e.Contents = tr
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
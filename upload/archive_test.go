@@ -0,0 +1,272 @@
+package upload
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testEntry struct {
+	name string
+	data string
+	dir  bool
+	link bool
+}
+
+func tarArchive(t *testing.T, entries []testEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.data))}
+		if e.dir {
+			hdr = &tar.Header{Name: e.name, Mode: 0755, Typeflag: tar.TypeDir}
+		} else if e.link {
+			hdr = &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarGzArchive(t *testing.T, entries []testEntry) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(tarArchive(t, entries)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T, entries []testEntry) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readSpooled(t *testing.T, in *SpooledInput) string {
+	data, err := ioutil.ReadAll(in.Reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestReadArchive(t *testing.T) {
+	limits := archiveLimits{State: 64, Block: 16, MaxBlocks: 3}
+	valid := []testEntry{
+		{name: "case", dir: true},
+		{name: "case/meta.json", data: `{"spec-version":"v0.9.0","spec-config":"minimal"}`},
+		{name: "case/block_1.ssz", data: "block 1"},
+		{name: "case/pre.ssz", data: "pre"},
+		{name: "case/block_0.ssz", data: "block 0"},
+	}
+	dirs := make([]testEntry, 0)
+	for _, name := range []string{"a/", "b/", "c/", "d/", "e/", "f/", "g/", "h/", "i/", "j/"} {
+		dirs = append(dirs, testEntry{name: name, dir: true})
+	}
+	cases := []struct {
+		name    string
+		archive func(t *testing.T) []byte
+		// the expected error, as part of the error message. Empty if the archive is valid.
+		err    string
+		pre    string
+		blocks []string
+		meta   *archiveMeta
+	}{
+		{
+			name:    "tar",
+			archive: func(t *testing.T) []byte { return tarArchive(t, valid) },
+			pre:     "pre",
+			blocks:  []string{"block 0", "block 1"},
+			meta:    &archiveMeta{SpecVersion: "v0.9.0", SpecConfig: "minimal"},
+		},
+		{
+			name:    "tar.gz",
+			archive: func(t *testing.T) []byte { return tarGzArchive(t, valid) },
+			pre:     "pre",
+			blocks:  []string{"block 0", "block 1"},
+			meta:    &archiveMeta{SpecVersion: "v0.9.0", SpecConfig: "minimal"},
+		},
+		{
+			name:    "zip",
+			archive: func(t *testing.T) []byte { return zipArchive(t, valid[1:]) },
+			pre:     "pre",
+			blocks:  []string{"block 0", "block 1"},
+			meta:    &archiveMeta{SpecVersion: "v0.9.0", SpecConfig: "minimal"},
+		},
+		{
+			name:    "blocks only",
+			archive: func(t *testing.T) []byte { return tarArchive(t, []testEntry{{name: "block_0.ssz", data: "b"}}) },
+			blocks:  []string{"b"},
+		},
+		{
+			name: "missing block",
+			archive: func(t *testing.T) []byte {
+				return tarArchive(t, []testEntry{{name: "block_0.ssz", data: "b"}, {name: "block_2.ssz", data: "b"}})
+			},
+			err: "missing block 1",
+		},
+		{
+			name:    "too many blocks",
+			archive: func(t *testing.T) []byte { return tarArchive(t, []testEntry{{name: "block_3.ssz", data: "b"}}) },
+			err:     "high amount of blocks",
+		},
+		{
+			name:    "invalid block name",
+			archive: func(t *testing.T) []byte { return tarArchive(t, []testEntry{{name: "block_x.ssz", data: "b"}}) },
+			err:     "invalid block entry name",
+		},
+		{
+			name: "repeated block",
+			archive: func(t *testing.T) []byte {
+				return zipArchive(t, []testEntry{{name: "a/block_0.ssz", data: "b"}, {name: "b/block_0.ssz", data: "b"}})
+			},
+			err: "more than one block 0",
+		},
+		{
+			name: "repeated pre-state",
+			archive: func(t *testing.T) []byte {
+				return tarArchive(t, []testEntry{{name: "a/pre.ssz", data: "p"}, {name: "b/pre.ssz", data: "p"}})
+			},
+			err: "more than one pre-state",
+		},
+		{
+			name: "pre-state too large",
+			archive: func(t *testing.T) []byte {
+				return zipArchive(t, []testEntry{{name: "pre.ssz", data: strings.Repeat("p", 65)}})
+			},
+			err: "too large",
+		},
+		{
+			name: "block too large",
+			archive: func(t *testing.T) []byte {
+				return tarArchive(t, []testEntry{{name: "block_0.ssz", data: strings.Repeat("b", 17)}})
+			},
+			err: "too large",
+		},
+		{
+			name: "compressed entry too large",
+			archive: func(t *testing.T) []byte {
+				return tarGzArchive(t, []testEntry{{name: "pre.ssz", data: strings.Repeat("\x00", 1<<20)}})
+			},
+			err: "too large",
+		},
+		{
+			name:    "archive too large",
+			archive: func(t *testing.T) []byte { return make([]byte, 2<<20) },
+			err:     "too large",
+		},
+		{
+			name: "meta file too large",
+			archive: func(t *testing.T) []byte {
+				return tarArchive(t, []testEntry{{name: "meta.json", data: strings.Repeat(" ", 1025)}})
+			},
+			err: "too large",
+		},
+		{
+			name:    "invalid meta file",
+			archive: func(t *testing.T) []byte { return tarArchive(t, []testEntry{{name: "meta.json", data: "{"}}) },
+			err:     "invalid meta file",
+		},
+		{
+			name:    "unexpected entry",
+			archive: func(t *testing.T) []byte { return zipArchive(t, []testEntry{{name: "post.ssz", data: "p"}}) },
+			err:     "unexpected archive entry",
+		},
+		{
+			name:    "symlink",
+			archive: func(t *testing.T) []byte { return tarArchive(t, []testEntry{{name: "pre.ssz", link: true}}) },
+			err:     "unexpected archive entry type",
+		},
+		{
+			name:    "too many entries",
+			archive: func(t *testing.T) []byte { return tarArchive(t, dirs) },
+			err:     "too many entries",
+		},
+		{
+			name:    "not an archive",
+			archive: func(t *testing.T) []byte { return []byte("not an archive, but longer than a tar header would be") },
+			err:     "invalid tar archive",
+		},
+		{
+			name:    "invalid zip",
+			archive: func(t *testing.T) []byte { return []byte("PK\x03\x04 truncated") },
+			err:     "invalid zip archive",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := testDir(t)
+			contents, err := readArchive(dir, bytes.NewReader(c.archive(t)), limits)
+			if c.err != "" {
+				if err == nil {
+					contents.Close()
+					t.Fatalf("expected error %q", c.err)
+				}
+				if !strings.Contains(err.Error(), c.err) {
+					t.Errorf("got error %q, expected error %q", err, c.err)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				pre := ""
+				if contents.Pre != nil {
+					pre = readSpooled(t, contents.Pre)
+				}
+				if pre != c.pre {
+					t.Errorf("got pre-state %q, expected %q", pre, c.pre)
+				}
+				blocks := make([]string, 0)
+				for _, b := range contents.Blocks {
+					blocks = append(blocks, readSpooled(t, b))
+				}
+				if !reflect.DeepEqual(blocks, c.blocks) {
+					t.Errorf("got blocks %q, expected %q", blocks, c.blocks)
+				}
+				if !reflect.DeepEqual(contents.Meta, c.meta) {
+					t.Errorf("got meta %+v, expected %+v", contents.Meta, c.meta)
+				}
+				contents.Close()
+			}
+			// the archive and the inputs are removed, whether the archive was accepted or not
+			left, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(left) != 0 {
+				t.Errorf("%d temporary files were not removed", len(left))
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/protolambda/muskoka-server/events"
+	"github.com/protolambda/muskoka-server/model"
+	"github.com/protolambda/muskoka-server/specs"
+	"github.com/protolambda/muskoka-server/tasks"
+	"time"
+)
+
+// TaskData holds the inputs of a new task, for tasks that are not created through an upload.
+type TaskData struct {
+	SpecVersion string
+	SpecConfig  string
+	Pre         []byte
+	// in block order
+	Blocks [][]byte
+	// optional, the expected post-state. Only its root is recorded, it is not stored.
+	ExpectedPost []byte
+}
+
+// CreateTask checks and stores the inputs, and creates and announces a new task, the same as an upload would.
+func (h *Handler) CreateTask(data *TaskData) (*model.Task, error) {
+	spec, err := h.Specs.Get(data.SpecVersion, data.SpecConfig)
+	if err != nil {
+		return nil, fmt.Errorf("cannot recognize spec %s %s: %v", data.SpecVersion, data.SpecConfig, err)
+	}
+	if len(data.Blocks) == 0 {
+		return nil, fmt.Errorf("no blocks were specified")
+	}
+	if len(data.Blocks) > spec.MaxBlocks {
+		return nil, fmt.Errorf("cannot process high amount of blocks; more than %v", spec.MaxBlocks)
+	}
+	topic := events.TransitionTopic(spec.Version, spec.Config)
+	{
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		if ok, err := h.Events.TopicExists(ctx, topic); err != nil {
+			return nil, fmt.Errorf("could not check if spec version + config is a valid topic: %v", err)
+		} else if !ok {
+			return nil, fmt.Errorf("spec version + config is not available for transitions")
+		}
+	}
+	limits := h.sizeLimits(spec.Config)
+
+	// inputs are not removed if the task is not created, other tasks may already refer to the same input.
+	checkAndStore := func(objName string, objType specs.ObjType, objDir string, limit int64, data []byte) (*inputSummary, string, error) {
+		if int64(len(data)) > limit {
+			return nil, "", fmt.Errorf("%s is too large, limit is %d bytes", objName, limit)
+		}
+		def, err := spec.Obj(objType)
+		if err != nil {
+			return nil, "", err
+		}
+		summary, err := checkSSZValidity(bytes.NewReader(data), int64(len(data)), objName, def)
+		if err != nil {
+			return nil, "", err
+		}
+		objKey := inputKey(spec, objDir, summary.Root)
+		if err := h.storeInput(objKey, bytes.NewReader(data)); err != nil {
+			return nil, "", err
+		}
+		return summary, objKey, nil
+	}
+
+	task := model.NewTask(spec.Version, spec.Config, len(data.Blocks), h.Now())
+	preSummary, preKey, err := checkAndStore("pre state", specs.BeaconState, "states", limits.State, data.Pre)
+	if err != nil {
+		return nil, err
+	}
+	task.PreRoot = preSummary.Root
+	task.PreSlot = preSummary.Slot
+	task.Inputs.Pre = preKey
+	task.BlockRoots = make([]string, len(data.Blocks), len(data.Blocks))
+	task.BlockSlots = make([]int64, len(data.Blocks), len(data.Blocks))
+	task.Inputs.Blocks = make([]string, len(data.Blocks), len(data.Blocks))
+	for i, block := range data.Blocks {
+		summary, key, err := checkAndStore(fmt.Sprintf("block %d", i), specs.BeaconBlock, "blocks", limits.Block, block)
+		if err != nil {
+			return nil, err
+		}
+		task.BlockRoots[i] = summary.Root
+		task.BlockSlots[i] = summary.Slot
+		task.Inputs.Blocks[i] = key
+	}
+	if data.ExpectedPost != nil {
+		def, err := spec.Obj(specs.BeaconState)
+		if err != nil {
+			return nil, err
+		}
+		summary, err := checkSSZValidity(bytes.NewReader(data.ExpectedPost), int64(len(data.ExpectedPost)), "expected post-state", def)
+		if err != nil {
+			return nil, err
+		}
+		task.ExpectedPostRoot = summary.Root
+	}
+
+	key := tasks.NewKey()
+	if h.LegacyInputs {
+		if err := h.storeLegacyInputs(key, task); err != nil {
+			return nil, err
+		}
+	}
+	{
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		if err := h.Tasks.CreateTask(ctx, key, task); err != nil {
+			return nil, fmt.Errorf("failed to register task: %v", err)
+		}
+	}
+	h.announceTask(task, topic)
+	return task, nil
+}
@@ -4,11 +4,24 @@ go 1.11
 
 require (
 	cloud.google.com/go v0.46.2
-	cloud.google.com/go/firestore v1.0.0
-	cloud.google.com/go/pubsub v1.0.1
 	github.com/protolambda/httphelpers v0.2.0
+	github.com/protolambda/muskoka-server/blobs v0.0.0
+	github.com/protolambda/muskoka-server/events v0.0.0
+	github.com/protolambda/muskoka-server/model v0.0.0
+	github.com/protolambda/muskoka-server/specs v0.0.0
+	github.com/protolambda/muskoka-server/tasks v0.0.0
 	github.com/protolambda/zssz v0.1.4
 	github.com/protolambda/zssz-spec-history v0.1.0
 	google.golang.org/api v0.10.0 // indirect
 	google.golang.org/grpc v1.23.1
 )
+
+replace github.com/protolambda/muskoka-server/blobs => ../blobs
+
+replace github.com/protolambda/muskoka-server/events => ../events
+
+replace github.com/protolambda/muskoka-server/model => ../model
+
+replace github.com/protolambda/muskoka-server/tasks => ../tasks
+
+replace github.com/protolambda/muskoka-server/specs => ../specs
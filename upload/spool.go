@@ -0,0 +1,51 @@
+package upload
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// SpooledInput is an input that was received into a temporary file, so that uploads do not hold their inputs in memory.
+// Other handlers that receive large files, e.g. archives of test cases, spool them the same way.
+// Inputs cannot be decoded while they are received: decoding needs their size, which multipart parts do not declare.
+type SpooledInput struct {
+	f    *os.File
+	Size int64
+}
+
+// SpoolInput copies the input into a new temporary file in dir, up to the limit. The file is removed with Close.
+// An empty dir is the default directory for temporary files.
+func SpoolInput(dir string, r io.Reader, limit int64) (*SpooledInput, error) {
+	f, err := ioutil.TempFile(dir, "muskoka-input-")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary file: %v", err)
+	}
+	in := &SpooledInput{f: f}
+	n, err := io.Copy(f, io.LimitReader(r, limit+1))
+	if err != nil {
+		_ = in.Close()
+		return nil, err
+	}
+	if n > limit {
+		_ = in.Close()
+		return nil, fmt.Errorf("input is too large, limit is %d bytes", limit)
+	}
+	in.Size = n
+	return in, nil
+}
+
+// Reader reads the input from the start. Readers are independent, and can be used one after the other.
+func (in *SpooledInput) Reader() *io.SectionReader {
+	return io.NewSectionReader(in.f, 0, in.Size)
+}
+
+// Close removes the temporary file.
+func (in *SpooledInput) Close() error {
+	err := in.f.Close()
+	if rmErr := os.Remove(in.f.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
@@ -1,367 +1,721 @@
 package upload
 
 import (
+	"bufio"
 	"bytes"
-	"cloud.google.com/go/firestore"
-	"cloud.google.com/go/pubsub"
-	"cloud.google.com/go/storage"
 	"context"
 	"encoding/json"
 	"fmt"
 	. "github.com/protolambda/httphelpers/codes"
-	"github.com/protolambda/zssz"
-	"github.com/protolambda/zssz-spec-history/mainnet_v0_8_4"
-	"github.com/protolambda/zssz-spec-history/mainnet_v0_9_0"
-	"github.com/protolambda/zssz-spec-history/minimal_v0_8_4"
-	"github.com/protolambda/zssz-spec-history/minimal_v0_9_0"
-	"github.com/protolambda/zssz/types"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"github.com/protolambda/muskoka-server/blobs"
+	"github.com/protolambda/muskoka-server/events"
+	"github.com/protolambda/muskoka-server/model"
+	"github.com/protolambda/muskoka-server/specs"
+	"github.com/protolambda/muskoka-server/tasks"
 	"io"
+	"io/ioutil"
 	"log"
-	"mime/multipart"
+	"math"
 	"net/http"
 	"os"
-	"regexp"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-var inputsBucket *storage.BucketHandle
-var pubSubClient *pubsub.Client
-var firestoreClient *firestore.Client
-var fsTransitionsCollection *firestore.CollectionRef
-var fsTaskIndexRef *firestore.DocumentRef
-
-func init() {
-	projectID := os.Getenv("GCP_PROJECT")
-	ctx := context.Background()
+// SizeLimits bounds the size of the uploaded inputs, in bytes.
+// Each input is spooled to a temporary file while it is checked and stored, the limits bound the disk use of an upload.
+type SizeLimits struct {
+	State int64
+	Block int64
+}
 
-	// database
-	{
-		cl, err := firestore.NewClient(ctx, projectID)
-		if err != nil {
-			log.Fatalf("Failed to create firestore client: %v", err)
-		}
-		firestoreClient = cl
-		fsTransitionsCollection = cl.Collection("transitions")
-		fsTaskIndexRef = cl.Collection("transitions-meta").Doc("next-index")
-	}
+// limits per spec config
+var defaultSizeLimits = map[string]SizeLimits{
+	"minimal": {State: 16 << 20, Block: 1 << 20},
+	"mainnet": {State: 64 << 20, Block: 2 << 20},
+}
 
-	// pubsub
-	{
-		cl, err := pubsub.NewClient(ctx, projectID)
-		if err != nil {
-			log.Fatalf("Failed to create pubsub client: %v", err)
-		}
-		pubSubClient = cl
-	}
+// limits for configs that do not have any specific limits
+var fallbackSizeLimits = SizeLimits{State: 16 << 20, Block: 1 << 20}
 
-	// storage
-	{
-		storageClient, err := storage.NewClient(ctx)
-		if err != nil {
-			log.Fatalf("Failed to create storage client: %v", err)
-		}
+// Handler processes transition uploads: the inputs are checked and stored, and a new task is created and announced.
+type Handler struct {
+	// Inputs stores the uploaded transition inputs.
+	Inputs blobs.Store
+	// Tasks stores the created transition tasks.
+	Tasks tasks.Store
+	// Events is the bus new transition tasks are published to.
+	Events events.Bus
+	// size limits of inputs, per spec config
+	SizeLimits map[string]SizeLimits
+	// size limits of inputs for spec configs without specific limits
+	FallbackSizeLimits SizeLimits
+	// Specs are the spec versions and configs that inputs are accepted for
+	Specs *specs.Registry
+	// Results are the stores of the result files, to fetch the post-states of results from, to chain new tasks from.
+	// Post-states in other places are not fetched.
+	Results blobs.Sources
+	// TempDir is the directory inputs are spooled to while they are checked and stored.
+	// Empty for the default directory for temporary files.
+	TempDir string
+	// LegacyInputs also stores a copy of the inputs of each new task at its per-task paths, see model.LegacyTaskInputs,
+	// for workers that build these paths themselves, instead of reading the inputs of the transition message.
+	// Off by default: the copies are written for every task, even if its inputs were stored already.
+	// The copies are made within the store, see blobs.Store.Copy.
+	LegacyInputs bool
+	// the time to register new tasks with
+	Now func() time.Time
+}
 
-		bucketName := "muskoka-transitions"
-		if envName := os.Getenv("TRANSITIONS_BUCKET"); envName != "" {
-			bucketName = envName
-		}
-		inputsBucket = storageClient.Bucket(bucketName)
+func NewHandler(inputs blobs.Store, tasks tasks.Store, events events.Bus, results blobs.Sources) *Handler {
+	return &Handler{
+		Inputs:             inputs,
+		Tasks:              tasks,
+		Events:             events,
+		SizeLimits:         defaultSizeLimits,
+		FallbackSizeLimits: fallbackSizeLimits,
+		Specs:              specs.NewDefaultRegistry(),
+		Results:            results,
+		Now:                time.Now,
 	}
 }
 
-// 10 MB
-const maxUploadMem = 10 * (1 << 20)
-
-type TaskIndexDoc struct {
-	NextIndex int `firestore:"next-index"`
+// LegacyInputsFromEnv checks if inputs are also stored at their per-task paths, see Handler.LegacyInputs.
+// Enabled only if MUSKOKA_LEGACY_INPUTS is set to "true".
+func LegacyInputsFromEnv() bool {
+	return os.Getenv("MUSKOKA_LEGACY_INPUTS") == "true"
 }
 
-type Task struct {
-	Index       int       `firestore:"index"`
-	Blocks      int       `firestore:"blocks"`
-	SpecVersion string    `firestore:"spec-version"`
-	SpecConfig  string    `firestore:"spec-config"`
-	Created     time.Time `firestore:"created"`
-	// Results and workers are ignored, only added later when workers make results available
-}
+var defaultHandler *Handler
+var defaultHandlerOnce sync.Once
 
-type TransitionMsg struct {
-	Blocks      int    `json:"blocks"`
-	SpecVersion string `json:"spec-version"`
-	SpecConfig  string `json:"spec-config"`
-	Key         string `json:"key"`
+// the handler for the cloud function, with its dependencies configured by the environment.
+func getDefaultHandler() *Handler {
+	defaultHandlerOnce.Do(func() {
+		ctx := context.Background()
+		inputs, err := blobs.FromEnv(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create inputs store: %v", err)
+		}
+		taskStore, err := tasks.FromEnv(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create task store: %v", err)
+		}
+		bus, err := events.FromEnv(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create event bus: %v", err)
+		}
+		results, err := blobs.ResultSourcesFromEnv(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create results stores: %v", err)
+		}
+		defaultHandler = NewHandler(inputs, taskStore, bus, results)
+		defaultHandler.LegacyInputs = LegacyInputsFromEnv()
+	})
+	return defaultHandler
 }
 
 type UploadResponse struct {
 	Key string `json:"key"`
 }
 
-var versionRegex, _ = regexp.Compile("[a-zA-Z0-9.-_]")
-
-var configRegex, _ = regexp.Compile("[a-zA-Z0-9-_]")
-
+// Upload is the cloud function entry point, see Handler.
 func Upload(w http.ResponseWriter, r *http.Request) {
-	specVersion := r.FormValue("spec-version")
-	if specVersion == "" {
-		SERVER_BAD_INPUT.Report(w, "spec version is not specified. Set the \"spec-version\" form value.")
-		return
-	}
-	if len(specVersion) > 10 {
-		SERVER_BAD_INPUT.Report(w, "spec version is too long")
-		return
-	}
-	specConfig := r.FormValue("spec-config")
-	if specConfig == "" {
-		SERVER_BAD_INPUT.Report(w, "spec config is not specified. Set the \"spec-config\" form value.")
-		return
-	}
-	if len(specConfig) > 100 {
-		SERVER_BAD_INPUT.Report(w, "spec config name is too long")
-		return
-	}
-	if !versionRegex.Match([]byte(specVersion)) {
-		SERVER_BAD_INPUT.Report(w, "spec version is invalid")
-		return
-	}
-	if !configRegex.Match([]byte(specConfig)) {
-		SERVER_BAD_INPUT.Report(w, "spec config name is invalid")
-		return
+	getDefaultHandler().ServeHTTP(w, r)
+}
+
+func (h *Handler) sizeLimits(specConfig string) SizeLimits {
+	if limits, ok := h.SizeLimits[specConfig]; ok {
+		return limits
 	}
-	err := r.ParseMultipartForm(maxUploadMem)
+	return h.FallbackSizeLimits
+}
+
+// ServeHTTP reads the multipart upload as a stream: each input is checked and stored as soon as it is received.
+// Hence the spec version and config have to precede the input files, and so do the blocks order and base task, if any.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mr, err := r.MultipartReader()
 	if SERVER_BAD_INPUT.Check(w, err, "cannot parse multipart upload") {
 		return
 	}
-	defer func() {
-		if err := r.MultipartForm.RemoveAll(); err != nil {
-			log.Printf("could not clean up mutli-part upload: %v", err)
-		}
-	}()
 
-	if blocks, ok := r.MultipartForm.File["blocks"]; !ok {
-		SERVER_BAD_INPUT.Report(w, "no blocks were specified")
-		return
-	} else if len(blocks) > 16 {
-		SERVER_BAD_INPUT.Report(w, fmt.Sprintf("cannot process high amount of blocks; %v", len(blocks)))
-	}
-	if pre, ok := r.MultipartForm.File["pre"]; !ok {
-		SERVER_BAD_INPUT.Report(w, "no pre-state was specified")
-		return
-	} else if len(pre) != 1 {
-		SERVER_BAD_INPUT.Report(w, "need exactly one pre-state file")
-		return
-	}
+	keyStr := tasks.NewKey()
 
-	pubSubTopic := pubSubClient.Topic(fmt.Sprintf("transition~%s~%s", specVersion, specConfig))
-	{
-		ctx, _ := context.WithTimeout(context.Background(), time.Second*5)
-		ok, err := pubSubTopic.Exists(ctx)
+	// inputs are not removed if the upload does not complete: other uploads may already refer to the same input.
+
+	var specVersion, specConfig string
+	var spec *specs.Spec
+	var topic string
+	// upload index -> block index, if the blocks are reordered
+	var blocksOrder []int
+	// the task to fork, if any. Its pre-state, and the first baseBlocks blocks, are re-used.
+	var baseKey string
+	var base *model.Task
+	baseBlocks := 0
+	// the result of the base task to chain from, if any. Its post-state is the pre-state of the new task.
+	var baseResultKey string
+	var baseResult *model.ResultEntry
+	// optional, the hash tree root of the expected post-state
+	var expectedPostRoot string
+	preCount := 0
+	blockCount := 0
+	var preSummary *inputSummary
+	// block index -> summary
+	blockSummaries := make(map[int]*inputSummary)
+	// storage keys of the inputs
+	var preKey string
+	blockKeys := make(map[int]string)
+
+	// prepare checks the form values, once they are all received.
+	prepare := func() bool {
+		if baseKey != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			t, err := h.Tasks.GetTask(ctx, baseKey)
+			if err == tasks.ErrNotFound {
+				SERVER_BAD_INPUT.Report(w, "base task does not exist")
+				return false
+			}
+			if SERVER_ERR.Check(w, err, "could not get base task") {
+				return false
+			}
+			// forks are of the same spec as the base task
+			if specVersion == "" {
+				specVersion = t.SpecVersion
+			} else if specVersion != t.SpecVersion {
+				SERVER_BAD_INPUT.Report(w, "spec version does not match the base task")
+				return false
+			}
+			if specConfig == "" {
+				specConfig = t.SpecConfig
+			} else if specConfig != t.SpecConfig {
+				SERVER_BAD_INPUT.Report(w, "spec config does not match the base task")
+				return false
+			}
+			if baseBlocks > t.Blocks {
+				SERVER_BAD_INPUT.Report(w, fmt.Sprintf("base task only has %d blocks", t.Blocks))
+				return false
+			}
+			if baseResultKey != "" {
+				res, ok := t.Results[baseResultKey]
+				if !ok {
+					SERVER_BAD_INPUT.Report(w, "base result does not exist")
+					return false
+				}
+				if !res.Success {
+					SERVER_BAD_INPUT.Report(w, "cannot chain from a result that was not a success")
+					return false
+				}
+				if baseBlocks > 0 {
+					SERVER_BAD_INPUT.Report(w, "cannot re-use blocks when chaining from a result, the post-state already includes them")
+					return false
+				}
+				baseResult = &res
+			}
+			base = t
+		} else if baseBlocks > 0 {
+			SERVER_BAD_INPUT.Report(w, "cannot re-use blocks without a base task")
+			return false
+		} else if baseResultKey != "" {
+			SERVER_BAD_INPUT.Report(w, "cannot chain from a result without a base task")
+			return false
+		}
+		if specVersion == "" {
+			SERVER_BAD_INPUT.Report(w, "spec version is not specified. Set the \"spec-version\" form value.")
+			return false
+		}
+		if specConfig == "" {
+			SERVER_BAD_INPUT.Report(w, "spec config is not specified. Set the \"spec-config\" form value.")
+			return false
+		}
+		s, err := h.Specs.Get(specVersion, specConfig)
+		if err == specs.ErrUnknownSpec {
+			SERVER_BAD_INPUT.Report(w, "Cannot recognize provided spec version + config")
+			return false
+		}
+		if SERVER_ERR.Check(w, err, "could not look up spec version + config") {
+			return false
+		}
+		if baseBlocks > s.MaxBlocks {
+			SERVER_BAD_INPUT.Report(w, fmt.Sprintf("cannot process high amount of blocks; more than %v", s.MaxBlocks))
+			return false
+		}
+		topic = events.TransitionTopic(specVersion, specConfig)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		ok, err := h.Events.TopicExists(ctx, topic)
 		if SERVER_ERR.Check(w, err, "could not check if spec version + config is a valid topic") {
-			return
+			return false
 		} else if !ok {
-			SERVER_BAD_INPUT.Report(w, "Cannot recognize provided spec version + config")
-			return
+			SERVER_ERR.Report(w, "spec version + config is not available for transitions")
+			return false
+		}
+		spec = s
+		if baseResult != nil {
+			post, err := h.fetchPostState(baseResult.Files.PostState, h.sizeLimits(specConfig).State)
+			if err == blobs.ErrUnknownURL {
+				SERVER_BAD_INPUT.Report(w, "post-state of base result is not in a known results store")
+				return false
+			}
+			if err == blobs.ErrNotFound {
+				SERVER_BAD_INPUT.Report(w, "post-state of base result does not exist")
+				return false
+			}
+			if err == blobs.ErrTooLarge {
+				SERVER_BAD_INPUT.Report(w, "post-state of base result is too large")
+				return false
+			}
+			if SERVER_ERR.Check(w, err, "could not fetch post-state of base result") {
+				return false
+			}
+			defer post.Close()
+			def, err := spec.Obj(specs.BeaconState)
+			if SERVER_ERR.Check(w, err, "cannot check post-state of base result") {
+				return false
+			}
+			summary, err := checkSSZValidity(post.Reader(), post.Size, "post-state of base result", def)
+			if SERVER_BAD_INPUT.Check(w, err, "invalid post-state of base result") {
+				_, _ = fmt.Fprintln(w, "")
+				_, _ = fmt.Fprintln(w, err)
+				return false
+			}
+			objKey := inputKey(spec, "states", summary.Root)
+			err = h.storeInput(objKey, post.Reader())
+			if SERVER_ERR.Check(w, err, "could not store post-state of base result") {
+				return false
+			}
+			preSummary = summary
+			preKey = objKey
 		}
+		return true
 	}
 
-	blocks := r.MultipartForm.File["blocks"]
+	// if the inputs were uploaded as an archive
+	archived := false
 
-	if indicesStr := r.FormValue("blocks-order"); indicesStr != "" {
-		blockIndices := strings.Split(indicesStr, ",")
-		if len(blockIndices) != len(blocks) {
-			SERVER_BAD_INPUT.Report(w, "specified blocks order has mismatching index count compared to actual blocks uploaded")
-			return
+	// limit of the size of a "pre" or "blocks" input
+	inputLimit := func(name string) int64 {
+		limits := h.sizeLimits(specConfig)
+		if name == "pre" {
+			return limits.State
 		}
-		blocksReordered := make([]*multipart.FileHeader, len(blockIndices), len(blockIndices))
-		blocksTaken := make([]bool, len(blockIndices), len(blockIndices))
-		for dstIndex := 0; dstIndex < len(blockIndices); dstIndex++ {
-			srcIndex, err := strconv.ParseUint(blockIndices[dstIndex], 10, 64)
-			if err != nil || srcIndex >= uint64(len(blockIndices)) || blocksTaken[srcIndex] {
-				SERVER_BAD_INPUT.Report(w, "specified block indices are not valid unique within-range indices")
-				return
-			}
-			blocksReordered[dstIndex] = blocks[srcIndex]
-			// don't re-use blocks. All must be unique.
-			blocksTaken[srcIndex] = true
-		}
-		blocks = blocksReordered
+		return limits.Block
 	}
 
-	doc := fsTransitionsCollection.NewDoc()
-	keyStr := doc.ID
-
-	// check validity
-	{
-		// check pre-state
-		preUpload := r.MultipartForm.File["pre"][0]
-		if err := checkSSZValidity(preUpload, "pre state", "BeaconState", specVersion, specConfig);
-			SERVER_BAD_INPUT.Check(w, err, "invalid pre-state") {
+	// processInput checks and stores a "pre" or "blocks" input.
+	// The upload index of a block is its position in the blocks order, or -1 to take the next index.
+	processInput := func(name string, uploadIndex int, in *SpooledInput) bool {
+		var objName, objDir string
+		var objType specs.ObjType
+		// -1 for the pre-state
+		blockIndex := -1
+		if name == "pre" {
+			if base != nil {
+				SERVER_BAD_INPUT.Report(w, "the pre-state is taken from the base task, it cannot be uploaded")
+				return false
+			}
+			if preCount > 0 {
+				SERVER_BAD_INPUT.Report(w, "need exactly one pre-state file")
+				return false
+			}
+			preCount++
+			objName, objType, objDir = "pre state", specs.BeaconState, "states"
+		} else {
+			if baseBlocks+blockCount >= spec.MaxBlocks {
+				SERVER_BAD_INPUT.Report(w, fmt.Sprintf("cannot process high amount of blocks; more than %v", spec.MaxBlocks))
+				return false
+			}
+			if uploadIndex < 0 {
+				uploadIndex = blockCount
+			}
+			blockIndex = uploadIndex
+			if blocksOrder != nil {
+				if uploadIndex >= len(blocksOrder) {
+					SERVER_BAD_INPUT.Report(w, "specified blocks order has mismatching index count compared to actual blocks uploaded")
+					return false
+				}
+				blockIndex = blocksOrder[uploadIndex]
+			}
+			// uploaded blocks follow after the blocks re-used from the base task
+			blockIndex += baseBlocks
+			if _, ok := blockKeys[blockIndex]; ok {
+				SERVER_BAD_INPUT.Report(w, fmt.Sprintf("block %d is specified more than once", blockIndex))
+				return false
+			}
+			blockCount++
+			objName, objType, objDir = fmt.Sprintf("block %d", blockIndex), specs.BeaconBlock, "blocks"
+		}
+		if limit := inputLimit(name); in.Size > limit {
+			SERVER_BAD_INPUT.Report(w, fmt.Sprintf("%s is too large, limit is %d bytes", objName, limit))
+			return false
+		}
+		def, err := spec.Obj(objType)
+		if SERVER_ERR.Check(w, err, "cannot check "+objName) {
+			return false
+		}
+		summary, err := checkSSZValidity(in.Reader(), in.Size, objName, def)
+		if SERVER_BAD_INPUT.Check(w, err, "invalid "+objName) {
 			_, _ = fmt.Fprintln(w, "")
 			_, _ = fmt.Fprintln(w, err)
-			return
+			return false
 		}
-		// check blocks
-		for i, b := range blocks {
-			err := checkSSZValidity(b, fmt.Sprintf("block %d", i), "BeaconBlock", specVersion, specConfig)
-			if SERVER_BAD_INPUT.Check(w, err,"invalid block") {
-				_, _ = fmt.Fprintln(w, "")
-				_, _ = fmt.Fprintln(w, err)
-				return
-			}
+		// the key is known only after decoding, the input is read again to store it, unless it is stored already.
+		objKey := inputKey(spec, objDir, summary.Root)
+		if blockIndex < 0 {
+			preSummary = summary
+			preKey = objKey
+		} else {
+			blockSummaries[blockIndex] = summary
+			blockKeys[blockIndex] = objKey
+		}
+		err = h.storeInput(objKey, in.Reader())
+		if SERVER_ERR.Check(w, err, "could not store "+objName) {
+			return false
 		}
+		return true
 	}
 
-	// store input data
-	{
-		// store pre-state
-		preUpload := r.MultipartForm.File["pre"][0]
-		if SERVER_ERR.Check(w, copyUploadToBucket(preUpload, specVersion+"/"+specConfig+"/"+keyStr+"/pre.ssz"),
-			"could not store pre-state") {
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if SERVER_BAD_INPUT.Check(w, err, "cannot parse multipart upload") {
 			return
 		}
-		// store blocks
-		for i, b := range blocks {
-			if SERVER_ERR.Check(w, copyUploadToBucket(b, specVersion+"/"+specConfig+"/"+keyStr+fmt.Sprintf("/block_%d.ssz", i)),
-				"could not store block") {
+		name := part.FormName()
+		switch name {
+		case "spec-version", "spec-config", "blocks-order", "base-task", "base-blocks", "base-result", "expected-post-root":
+			value, err := ReadFormValue(part)
+			if SERVER_BAD_INPUT.Check(w, err, "cannot read form value "+name) {
 				return
 			}
-		}
-	}
-
-	// store task in firestore
-	{
-		ctx, _ := context.WithTimeout(context.Background(), time.Second*5)
-		err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
-			// read the next index
-			indexDoc, err := tx.Get(fsTaskIndexRef)
-			var indexContainer TaskIndexDoc
-			if status.Code(err) == codes.NotFound || (err == nil && !indexDoc.Exists()) {
-				indexContainer.NextIndex = 0
-			} else if err != nil {
-				return err
-			} else {
-				if err := indexDoc.DataTo(&indexContainer); err != nil {
-					return err
+			if spec != nil {
+				SERVER_BAD_INPUT.Report(w, fmt.Sprintf("form value %s must precede the input files", name))
+				return
+			}
+			switch name {
+			case "spec-version":
+				specVersion = value
+			case "spec-config":
+				specConfig = value
+			case "blocks-order":
+				order, err := parseBlocksOrder(value)
+				if SERVER_BAD_INPUT.Check(w, err, "specified block indices are not valid unique within-range indices") {
+					return
+				}
+				blocksOrder = order
+			case "base-task":
+				if !model.KeyRegex.MatchString(value) {
+					SERVER_BAD_INPUT.Report(w, "base task key is invalid")
+					return
 				}
+				baseKey = value
+			case "base-blocks":
+				n, err := strconv.ParseUint(value, 10, 32)
+				if SERVER_BAD_INPUT.Check(w, err, "base blocks is not a valid number of blocks") {
+					return
+				}
+				baseBlocks = int(n)
+			case "base-result":
+				if !model.ResultKeyRegex.MatchString(value) {
+					SERVER_BAD_INPUT.Report(w, "base result key is invalid")
+					return
+				}
+				baseResultKey = value
+			case "expected-post-root":
+				if !model.RootRegex.MatchString(value) {
+					SERVER_BAD_INPUT.Report(w, "expected post root is not a 0x-prefixed lowercase hex hash tree root")
+					return
+				}
+				expectedPostRoot = value
 			}
-
-			// increment the index
-			if err := tx.Set(fsTaskIndexRef, TaskIndexDoc{NextIndex: indexContainer.NextIndex + 1}); err != nil {
-				return err
+		case "pre", "blocks":
+			if archived {
+				SERVER_BAD_INPUT.Report(w, "an archive cannot be combined with other input files")
+				return
+			}
+			if spec == nil && !prepare() {
+				return
+			}
+			in, err := SpoolInput(h.TempDir, part, inputLimit(name))
+			if SERVER_BAD_INPUT.Check(w, err, "could not receive "+name) {
+				return
 			}
-			// create the task with the previously read ID
-			task := &Task{
-				Index:       indexContainer.NextIndex,
-				Blocks:      len(blocks),
-				SpecVersion: specVersion,
-				SpecConfig:  specConfig,
-				Created:     time.Now(),
+			ok := processInput(name, -1, in)
+			_ = in.Close()
+			if !ok {
+				return
 			}
-			if err := tx.Set(doc, task); err != nil {
-				return err
+		case "archive":
+			if spec != nil {
+				SERVER_BAD_INPUT.Report(w, "an archive cannot be combined with other input files")
+				return
 			}
-			return nil
-		})
-		if SERVER_ERR.Check(w, err, "failed to register task.") {
-			return
+			if blocksOrder != nil {
+				SERVER_BAD_INPUT.Report(w, "blocks are ordered by their name in the archive, a blocks order cannot be specified")
+				return
+			}
+			contents, err := readArchive(h.TempDir, part, h.archiveLimits())
+			if SERVER_BAD_INPUT.Check(w, err, "invalid archive") {
+				_, _ = fmt.Fprintln(w, "")
+				_, _ = fmt.Fprintln(w, err)
+				return
+			}
+			// there is at most one archive, the spooled inputs are removed when the upload is done.
+			defer contents.Close()
+			// the meta file may specify the spec, if the form values do not
+			if meta := contents.Meta; meta != nil {
+				if specVersion == "" {
+					specVersion = meta.SpecVersion
+				} else if meta.SpecVersion != "" && meta.SpecVersion != specVersion {
+					SERVER_BAD_INPUT.Report(w, "spec version of the archive meta file does not match the form value")
+					return
+				}
+				if specConfig == "" {
+					specConfig = meta.SpecConfig
+				} else if meta.SpecConfig != "" && meta.SpecConfig != specConfig {
+					SERVER_BAD_INPUT.Report(w, "spec config of the archive meta file does not match the form value")
+					return
+				}
+			}
+			if !prepare() {
+				return
+			}
+			archived = true
+			if contents.Pre != nil && !processInput("pre", -1, contents.Pre) {
+				return
+			}
+			for i, block := range contents.Blocks {
+				if !processInput("blocks", i, block) {
+					return
+				}
+			}
+		default:
+			// ignore unknown form values
 		}
+		_ = part.Close()
 	}
 
-	// fire pubsub event
-	{
-		trMsg := &TransitionMsg{
-			Blocks:      len(blocks),
-			SpecVersion: specVersion,
-			SpecConfig:  specConfig,
-			Key:         keyStr,
+	// a fork may not upload any new inputs
+	if spec == nil && !prepare() {
+		return
+	}
+	if blocksOrder != nil && len(blocksOrder) != blockCount {
+		SERVER_BAD_INPUT.Report(w, "specified blocks order has mismatching index count compared to actual blocks uploaded")
+		return
+	}
+	if baseResult != nil {
+		// the pre-state is the post-state of the base result, no blocks are re-used
+	} else if base != nil {
+		// re-use the pre-state and the first blocks of the base task
+		preSummary = &inputSummary{Root: base.PreRoot, Slot: base.PreSlot}
+		preKey = base.Inputs.Pre
+		for i := 0; i < baseBlocks; i++ {
+			summary := &inputSummary{}
+			// tasks from before the inputs were summarized do not have block roots and slots.
+			if i < len(base.BlockRoots) && i < len(base.BlockSlots) {
+				summary.Root = base.BlockRoots[i]
+				summary.Slot = base.BlockSlots[i]
+			}
+			blockSummaries[i] = summary
+			blockKeys[i] = base.Inputs.Blocks[i]
+		}
+	} else if preCount == 0 {
+		SERVER_BAD_INPUT.Report(w, "no pre-state was specified")
+		return
+	}
+	totalBlocks := baseBlocks + blockCount
+	if totalBlocks == 0 {
+		SERVER_BAD_INPUT.Report(w, "no blocks were specified")
+		return
+	}
+
+	// store task
+	task := model.NewTask(specVersion, specConfig, totalBlocks, h.Now())
+	task.PreRoot = preSummary.Root
+	task.PreSlot = preSummary.Slot
+	task.BlockRoots = make([]string, totalBlocks, totalBlocks)
+	task.BlockSlots = make([]int64, totalBlocks, totalBlocks)
+	task.Inputs.Pre = preKey
+	task.Inputs.Blocks = make([]string, totalBlocks, totalBlocks)
+	for i := 0; i < totalBlocks; i++ {
+		task.BlockRoots[i] = blockSummaries[i].Root
+		task.BlockSlots[i] = blockSummaries[i].Slot
+		task.Inputs.Blocks[i] = blockKeys[i]
+	}
+	if base != nil {
+		task.Parent = base.Key
+		task.ParentBlocks = baseBlocks
+		task.ParentResult = baseResultKey
+	}
+	task.ExpectedPostRoot = expectedPostRoot
+	if h.LegacyInputs {
+		if SERVER_ERR.Check(w, h.storeLegacyInputs(keyStr, task), "could not store inputs at their per-task paths") {
+			return
 		}
-		var buf bytes.Buffer
-		enc := json.NewEncoder(&buf)
-		if err := enc.Encode(trMsg); err != nil {
-			log.Printf("failed to emit event, could not encode task to JSON: %v, err: %v", trMsg, err)
+	}
+	{
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		if SERVER_ERR.Check(w, h.Tasks.CreateTask(ctx, keyStr, task), "failed to register task.") {
 			return
 		}
-		ctx, _ := context.WithTimeout(context.Background(), time.Second*5)
-		<-pubSubTopic.Publish(ctx, &pubsub.Message{
-			Data: buf.Bytes(),
-		}).Ready()
 	}
 
+	h.announceTask(task, topic)
+
 	// Success, redirect to result
 	http.Redirect(w, r, "/task/"+keyStr, http.StatusSeeOther)
 }
 
-func copyUploadToBucket(u *multipart.FileHeader, key string) error {
-	ctx, _ := context.WithTimeout(context.Background(), time.Second*5)
-	bucketW := inputsBucket.Object(key).NewWriter(ctx)
-	f, err := u.Open()
+// fires the transition event for a new task. Errors are logged, the task is already registered.
+func (h *Handler) announceTask(task *model.Task, topic string) {
+	trMsg := model.TransitionMsgOf(task)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if err := enc.Encode(trMsg); err != nil {
+		log.Printf("failed to emit event, could not encode task to JSON: %v, err: %v", trMsg, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if _, err := h.Events.Publish(ctx, topic, buf.Bytes()); err != nil {
+		log.Printf("failed to emit event for task %s: %v", task.Key, err)
+	}
+}
+
+// inputs are content-addressed: the same input is only stored once, and shared between tasks.
+func inputKey(spec *specs.Spec, objDir string, root string) string {
+	return spec.Version + "/" + spec.Config + "/" + objDir + "/" + root + ".ssz"
+}
+
+// fetches the post-state of a result from the store its URL refers to, into a temporary file, up to the limit.
+func (h *Handler) fetchPostState(url string, limit int64) (*SpooledInput, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	rc, err := h.Results.GetLimited(ctx, url, limit)
 	if err != nil {
-		return fmt.Errorf("could not receive uploaded data for %s: %v", key, err)
+		return nil, err
 	}
-	if _, err = io.Copy(bucketW, f); err != nil {
+	defer rc.Close()
+	return SpoolInput(h.TempDir, rc, limit)
+}
+
+// ReadFormValue reads a small form value. Only the first 1024 bytes are read.
+func ReadFormValue(part io.Reader) (string, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(part, 1024))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// parses the blocks order, and returns the inverse: upload index -> block index.
+// Block i will be sourced from upload order[i].
+func parseBlocksOrder(indicesStr string) ([]int, error) {
+	blockIndices := strings.Split(indicesStr, ",")
+	inverse := make([]int, len(blockIndices), len(blockIndices))
+	blocksTaken := make([]bool, len(blockIndices), len(blockIndices))
+	for dstIndex := 0; dstIndex < len(blockIndices); dstIndex++ {
+		srcIndex, err := strconv.ParseUint(blockIndices[dstIndex], 10, 64)
+		if err != nil || srcIndex >= uint64(len(blockIndices)) || blocksTaken[srcIndex] {
+			return nil, fmt.Errorf("invalid block index at position %d", dstIndex)
+		}
+		inverse[srcIndex] = dstIndex
+		// don't re-use blocks. All must be unique.
+		blocksTaken[srcIndex] = true
+	}
+	return inverse, nil
+}
+
+// stores the input, unless it is already stored.
+// Stored inputs are never removed by an upload: another task may refer to them, even if the upload that stored them fails.
+func (h *Handler) storeInput(key string, r io.Reader) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	if _, err := h.Inputs.Stat(ctx, key); err == nil {
+		// already stored, by a previous upload of the same input
+		return nil
+	} else if err != blobs.ErrNotFound {
+		return fmt.Errorf("could not check if input %s is already stored: %v", key, err)
+	}
+	// a concurrent upload of the same input may store it too, with the same contents.
+	if err := h.Inputs.Put(ctx, key, r); err != nil {
 		return fmt.Errorf("could not store uploaded data %s: %v", key, err)
 	}
-	if err := f.Close(); err != nil {
-		return fmt.Errorf("could not close uploaded data file for %s: %v", key, err)
+	return nil
+}
+
+// stores a copy of the inputs of the task with the given key at its per-task paths, see Handler.LegacyInputs.
+func (h *Handler) storeLegacyInputs(key string, task *model.Task) error {
+	legacy := model.LegacyTaskInputs(task.SpecVersion, task.SpecConfig, key, task.Blocks)
+	if err := h.copyInput(task.Inputs.Pre, legacy.Pre); err != nil {
+		return err
+	}
+	for i, src := range task.Inputs.Blocks {
+		if err := h.copyInput(src, legacy.Blocks[i]); err != nil {
+			return err
+		}
 	}
-	if err := bucketW.Close(); err != nil {
-		return fmt.Errorf("could not push uploaded data to cloud bucket for %s: %v", key, err)
+	return nil
+}
+
+// copies a stored input to another key, within the store
+func (h *Handler) copyInput(src string, dst string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	if err := h.Inputs.Copy(ctx, src, dst); err != nil {
+		return fmt.Errorf("could not copy input %s to %s: %v", src, dst, err)
 	}
 	return nil
 }
 
-var objSSZDefinitions = map[string]map[string]map[string]types.SSZ{
-	"BeaconBlock": {
-		"v0.8.3": {
-			// Same as v0.8.4
-			"minimal": minimal_v0_8_4.BeaconBlockSSZ,
-			"mainnet": mainnet_v0_8_4.BeaconBlockSSZ,
-		},
-		"v0.8.4": {
-			"minimal": minimal_v0_8_4.BeaconBlockSSZ,
-			"mainnet": mainnet_v0_8_4.BeaconBlockSSZ,
-		},
-		"v0.9.0": {
-			"minimal": minimal_v0_9_0.BeaconBlockSSZ,
-			"mainnet": mainnet_v0_9_0.BeaconBlockSSZ,
-		},
-	},
-	"BeaconState": {
-		"v0.8.3": {
-			// Same as v0.8.4
-			"minimal": minimal_v0_8_4.BeaconStateSSZ,
-			"mainnet": mainnet_v0_8_4.BeaconStateSSZ,
-		},
-		"v0.8.4": {
-			"minimal": minimal_v0_8_4.BeaconStateSSZ,
-			"mainnet": mainnet_v0_8_4.BeaconStateSSZ,
-		},
-		"v0.9.0": {
-			"minimal": minimal_v0_9_0.BeaconStateSSZ,
-			"mainnet": mainnet_v0_9_0.BeaconStateSSZ,
-		},
-	},
+// identifies a checked input
+type inputSummary struct {
+	// hash tree root of the input, 0x-prefixed hex
+	Root string
+	Slot int64
 }
 
-func checkSSZValidity(u *multipart.FileHeader, name string, objType string, specVersion string, specConfig string) error {
-	f, err := u.Open()
+// checks the input of the given size by decoding it, and summarizes it.
+func checkSSZValidity(r io.Reader, size int64, name string, def *specs.ObjDef) (*inputSummary, error) {
+	obj, err := def.DecodeFrom(bufio.NewReader(r), uint64(size))
+	if err != nil {
+		return nil, fmt.Errorf("%s is invalid: %v", name, err)
+	}
+	slot, err := slotOf(obj)
 	if err != nil {
-		return fmt.Errorf("could not receive uploaded data for %s: %v", name, err)
+		return nil, fmt.Errorf("%s has an invalid slot: %v", name, err)
 	}
-	byVersionConfig, ok := objSSZDefinitions[objType]
-	if !ok {
-		return fmt.Errorf("cannot recognize object type: %s", objType)
+	return &inputSummary{
+		Root: def.HashTreeRoot(obj),
+		Slot: slot,
+	}, nil
+}
+
+// both blocks and states have a slot field in all registered spec versions, a type without one is an error, not a panic.
+// Slots are stored as signed integers, larger slots are an error.
+func slotOf(obj interface{}) (int64, error) {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return 0, fmt.Errorf("decoded %T is not a struct", obj)
 	}
-	byConfig, ok := byVersionConfig[specVersion]
-	if !ok {
-		return fmt.Errorf("cannot recognize spec version: %s", specVersion)
+	field := v.Elem().FieldByName("Slot")
+	if !field.IsValid() {
+		return 0, fmt.Errorf("%T does not have a Slot field", obj)
 	}
-	sszDef, ok := byConfig[specConfig]
-	if !ok {
-		return fmt.Errorf("cannot recognize spec config: %s", specConfig)
+	switch field.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		slot := field.Uint()
+		if slot > math.MaxInt64 {
+			return 0, fmt.Errorf("slot %d is too large, limit is %d", slot, int64(math.MaxInt64))
+		}
+		return int64(slot), nil
+	default:
+		return 0, fmt.Errorf("the Slot field of %T is a %s, not an unsigned integer", obj, field.Kind())
 	}
-	return zssz.DryCheck(f, uint64(u.Size), sszDef)
 }
@@ -0,0 +1,337 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"github.com/protolambda/muskoka-server/blobs"
+	"github.com/protolambda/muskoka-server/events"
+	"github.com/protolambda/muskoka-server/model"
+	"github.com/protolambda/muskoka-server/specs"
+	"github.com/protolambda/muskoka-server/tasks"
+	"github.com/protolambda/zssz"
+	"io/ioutil"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDir creates a temporary directory, which is removed after the test.
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "muskoka-upload-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+// newTestHandler creates a handler with a task store, an inputs store and a results store in a temporary directory,
+// and a local event bus with the topic of v0.9.0 minimal. Result files are referred to by "results://<key>".
+// The task store is closed after the test.
+func newTestHandler(t *testing.T) *Handler {
+	dir := testDir(t)
+	taskStore, err := tasks.OpenBoltStore(filepath.Join(dir, "tasks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = taskStore.Close() })
+	inputs, err := blobs.NewLocalStore(filepath.Join(dir, "inputs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := blobs.NewLocalStore(filepath.Join(dir, "results"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewLocalBus()
+	bus.CreateTopic(events.TransitionTopic("v0.9.0", "minimal"))
+	h := NewHandler(inputs, taskStore, bus, blobs.Sources{{URLPrefix: "results://", Store: results}})
+	h.TempDir = dir
+	return h
+}
+
+// encodeInput encodes a zeroed object of the v0.9.0 minimal type with the given slot.
+func encodeInput(t *testing.T, objType specs.ObjType, slot uint64) []byte {
+	spec, err := specs.NewDefaultRegistry().Get("v0.9.0", "minimal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := spec.Obj(objType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := def.Alloc()
+	reflect.ValueOf(obj).Elem().FieldByName("Slot").SetUint(slot)
+	var buf bytes.Buffer
+	if _, err := zssz.Encode(&buf, obj, def.SSZ); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readInput(t *testing.T, h *Handler, key string) []byte {
+	rc, err := h.Inputs.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("could not get input %s: %v", key, err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCreateTaskLegacyInputs(t *testing.T) {
+	cases := []struct {
+		name      string
+		configure func(h *Handler)
+		legacy    bool
+	}{
+		{"default", func(h *Handler) {}, false},
+		{"legacy inputs", func(h *Handler) { h.LegacyInputs = true }, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			c.configure(h)
+			pre := encodeInput(t, specs.BeaconState, 1)
+			block := encodeInput(t, specs.BeaconBlock, 2)
+			task, err := h.CreateTask(&TaskData{SpecVersion: "v0.9.0", SpecConfig: "minimal", Pre: pre, Blocks: [][]byte{block}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(readInput(t, h, task.Inputs.Pre), pre) {
+				t.Error("content-addressed pre-state differs from the upload")
+			}
+			legacy := model.LegacyTaskInputs("v0.9.0", "minimal", task.Key, 1)
+			for key, want := range map[string][]byte{legacy.Pre: pre, legacy.Blocks[0]: block} {
+				if !c.legacy {
+					if _, err := h.Inputs.Stat(context.Background(), key); err != blobs.ErrNotFound {
+						t.Errorf("got error %v for legacy input %s, expected ErrNotFound", err, key)
+					}
+					continue
+				}
+				if !bytes.Equal(readInput(t, h, key), want) {
+					t.Errorf("legacy input %s differs from the upload", key)
+				}
+			}
+		})
+	}
+}
+
+// a part of a multipart upload: a form value, or a file if data is set
+type formPart struct {
+	name  string
+	value string
+	data  []byte
+}
+
+// upload posts the parts, in order, to the handler.
+func upload(t *testing.T, h *Handler, parts []formPart) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		if p.data == nil {
+			if err := mw.WriteField(p.name, p.value); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		w, err := mw.CreateFormFile(p.name, p.name+".ssz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(p.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/upload", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// uploadTask posts the parts, and returns the created task.
+func uploadTask(t *testing.T, h *Handler, parts []formPart) *model.Task {
+	rec := upload(t, h, parts)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("upload failed with status %d: %s", rec.Code, rec.Body.String())
+	}
+	key := strings.TrimPrefix(rec.Header().Get("Location"), "/task/")
+	task, err := h.Tasks.GetTask(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+var testSpecParts = []formPart{{name: "spec-version", value: "v0.9.0"}, {name: "spec-config", value: "minimal"}}
+
+// uploadBaseTask uploads a task with a pre-state at slot 1, and blocks at slot 2 and 3.
+func uploadBaseTask(t *testing.T, h *Handler) *model.Task {
+	return uploadTask(t, h, append(testSpecParts,
+		formPart{name: "pre", data: encodeInput(t, specs.BeaconState, 1)},
+		formPart{name: "blocks", data: encodeInput(t, specs.BeaconBlock, 2)},
+		formPart{name: "blocks", data: encodeInput(t, specs.BeaconBlock, 3)},
+	))
+}
+
+func TestUploadRepeatedInputs(t *testing.T) {
+	h := newTestHandler(t)
+	a := uploadBaseTask(t, h)
+	attrs, err := h.Inputs.Stat(context.Background(), a.Inputs.Pre)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a later write would be noticed by a later modification time
+	time.Sleep(10 * time.Millisecond)
+	b := uploadBaseTask(t, h)
+	if a.Key == b.Key {
+		t.Fatal("repeated upload did not create a new task")
+	}
+	if !reflect.DeepEqual(a.Inputs, b.Inputs) {
+		t.Errorf("repeated upload got inputs %+v, expected the existing inputs %+v", b.Inputs, a.Inputs)
+	}
+	if a.Inputs.Pre != "v0.9.0/minimal/states/"+a.PreRoot+".ssz" {
+		t.Errorf("pre-state is not stored by its root: %s", a.Inputs.Pre)
+	}
+	after, err := h.Inputs.Stat(context.Background(), b.Inputs.Pre)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.Updated.Equal(attrs.Updated) {
+		t.Error("repeated upload wrote the existing pre-state again")
+	}
+}
+
+func TestUploadSlotTooLarge(t *testing.T) {
+	h := newTestHandler(t)
+	cases := []struct {
+		name      string
+		preSlot   uint64
+		blockSlot uint64
+	}{
+		{"pre-state", math.MaxInt64 + 1, 2},
+		{"block", 1, math.MaxUint64},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rec := upload(t, h, append(testSpecParts,
+				formPart{name: "pre", data: encodeInput(t, specs.BeaconState, c.preSlot)},
+				formPart{name: "blocks", data: encodeInput(t, specs.BeaconBlock, c.blockSlot)},
+			))
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "is too large, limit is 9223372036854775807") {
+				t.Errorf("got status %d: %s, expected status %d for the slot", rec.Code, rec.Body.String(), http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestUploadFork(t *testing.T) {
+	h := newTestHandler(t)
+	base := uploadBaseTask(t, h)
+	block := encodeInput(t, specs.BeaconBlock, 4)
+	// the spec defaults to the spec of the base task
+	task := uploadTask(t, h, []formPart{
+		{name: "base-task", value: base.Key},
+		{name: "base-blocks", value: "1"},
+		{name: "blocks", data: block},
+	})
+	if task.Parent != base.Key || task.ParentBlocks != 1 || task.ParentResult != "" {
+		t.Errorf("got parent %q with %d blocks and result %q", task.Parent, task.ParentBlocks, task.ParentResult)
+	}
+	if task.SpecVersion != "v0.9.0" || task.SpecConfig != "minimal" {
+		t.Errorf("got spec %s %s", task.SpecVersion, task.SpecConfig)
+	}
+	if task.Blocks != 2 || task.PreRoot != base.PreRoot || task.Inputs.Pre != base.Inputs.Pre {
+		t.Errorf("pre-state of the base task is not re-used: %+v", task)
+	}
+	if task.Inputs.Blocks[0] != base.Inputs.Blocks[0] || task.BlockRoots[0] != base.BlockRoots[0] {
+		t.Errorf("first block of the base task is not re-used: %v", task.Inputs.Blocks)
+	}
+	if task.BlockSlots[1] != 4 || !bytes.Equal(readInput(t, h, task.Inputs.Blocks[1]), block) {
+		t.Errorf("uploaded block does not follow the base blocks: %v", task.Inputs.Blocks)
+	}
+}
+
+func TestUploadChain(t *testing.T) {
+	h := newTestHandler(t)
+	base := uploadBaseTask(t, h)
+	post := encodeInput(t, specs.BeaconState, 3)
+	ctx := context.Background()
+	if err := h.Results[0].Store.Put(ctx, "post.ssz", bytes.NewReader(post)); err != nil {
+		t.Fatal(err)
+	}
+	result := &model.ResultEntry{Success: true, Outcome: model.OutcomeOK, ClientName: "zrnt",
+		Files: model.ResultFilesRef{PostState: "results://post.ssz"}}
+	resultKey, err := h.Tasks.MergeResult(ctx, base.Key, "r", result, tasks.KeepFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := uploadTask(t, h, []formPart{
+		{name: "base-task", value: base.Key},
+		{name: "base-result", value: resultKey},
+		{name: "blocks", data: encodeInput(t, specs.BeaconBlock, 4)},
+	})
+	if task.Parent != base.Key || task.ParentResult != resultKey || task.ParentBlocks != 0 {
+		t.Errorf("got parent %q with %d blocks and result %q", task.Parent, task.ParentBlocks, task.ParentResult)
+	}
+	if task.Blocks != 1 || task.PreSlot != 3 || task.PreRoot == base.PreRoot {
+		t.Errorf("pre-state is not the post-state of the result: %+v", task)
+	}
+	if !bytes.Equal(readInput(t, h, task.Inputs.Pre), post) {
+		t.Error("stored pre-state differs from the post-state of the result")
+	}
+}
+
+func TestUploadBaseInvalid(t *testing.T) {
+	h := newTestHandler(t)
+	base := uploadBaseTask(t, h)
+	ctx := context.Background()
+	failed := &model.ResultEntry{Outcome: model.OutcomeCrash, ClientName: "zrnt"}
+	failedKey, err := h.Tasks.MergeResult(ctx, base.Key, "failed", failed, tasks.KeepFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := formPart{name: "blocks", data: encodeInput(t, specs.BeaconBlock, 4)}
+	cases := []struct {
+		name  string
+		parts []formPart
+		// part of the expected error message
+		err string
+	}{
+		{"unknown base task", []formPart{{name: "base-task", value: "unknown"}, block}, "base task does not exist"},
+		{"unknown base result", []formPart{{name: "base-task", value: base.Key}, {name: "base-result", value: "unknown"}, block},
+			"base result does not exist"},
+		{"failed base result", []formPart{{name: "base-task", value: base.Key}, {name: "base-result", value: failedKey}, block},
+			"not a success"},
+		{"prefix longer than the base", []formPart{{name: "base-task", value: base.Key}, {name: "base-blocks", value: "3"}, block},
+			"base task only has 2 blocks"},
+		{"blocks without a base", append(testSpecParts, formPart{name: "base-blocks", value: "1"}, block),
+			"without a base task"},
+		{"other spec than the base", []formPart{{name: "base-task", value: base.Key}, {name: "spec-config", value: "mainnet"}, block},
+			"does not match the base task"},
+		{"pre-state with a base", []formPart{{name: "base-task", value: base.Key},
+			{name: "pre", data: encodeInput(t, specs.BeaconState, 1)}, block}, "taken from the base task"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rec := upload(t, h, c.parts)
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), c.err) {
+				t.Errorf("got status %d: %s, expected status %d: %s", rec.Code, rec.Body.String(), http.StatusBadRequest, c.err)
+			}
+		})
+	}
+}